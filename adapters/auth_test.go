@@ -0,0 +1,136 @@
+package adapters
+
+import (
+	"fmt"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/middleware"
+	"github.com/fabriqs/go-micro/tests"
+	"github.com/fabriqs/go-micro/util/errors"
+	"github.com/fabriqs/go-micro/util/h"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestEnv(t *testing.T, models ...any) *micro.Env {
+	db := NewGormAdapter(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()), "")
+	if len(models) > 0 {
+		assert.Nil(t, db.AutoMigrate(models...))
+	}
+	env := &micro.Env{
+		DB:           map[string]micro.DataSource{micro.DefaultTenantId: db},
+		TenantLoader: micro.NewFixedTenantLoader([]string{micro.DefaultTenantId}),
+	}
+	app := &micro.App{Name: t.Name(), Env: env}
+	_, err := app.Init(nil)
+	assert.Nil(t, err)
+	return env
+}
+
+func TestApiKeyAuthentication(t *testing.T) {
+	env := newTestEnv(t, &micro.ApiKey{})
+	keys := micro.NewApiKeyService()
+	router := NewEchoAdapter(micro.RouterConfig{ApiKeys: keys})
+	router.GET("/me", func(ctx micro.Ctx) (any, error) {
+		return h.Map{"id": ctx.Auth.UserId, "scheme": ctx.Auth.Scheme}, nil
+	}, middleware.AuthenticatedWithRole("admin"))
+
+	issued, err := keys.Issue(micro.NewCtx(micro.DefaultTenantId), micro.ApiKeyRequest{
+		Name:   "ci",
+		Roles:  []string{"admin"},
+		Scopes: []string{"orders:read"},
+	})
+	assert.Nil(t, err)
+	assert.NotEqual(t, issued.Hash, issued.Key)
+
+	server := tests.HttpTest(t, router.Handler(), env.Close)
+	defer server.Teardown()
+
+	server.GET("/me").Expect().IsUnauthorized()
+	server.GET("/me").Header(micro.HeaderApiKey, issued.Key+"x").Expect().IsUnauthorized()
+	res := server.GET("/me").Header(micro.HeaderApiKey, issued.Key).Expect().IsOK().JSON().Object()
+	res.Path("$.id").String().IsEqual(issued.Id)
+	res.Path("$.scheme").String().IsEqual(micro.AuthSchemeApiKey)
+
+	assert.Nil(t, keys.Revoke(micro.NewCtx(micro.DefaultTenantId), issued.Id))
+	server.GET("/me").Header(micro.HeaderApiKey, issued.Key).Expect().IsUnauthorized()
+}
+
+func TestHmacAuthentication(t *testing.T) {
+	verifier := micro.NewHmacVerifier(micro.NewFixedHmacCredentialStore(micro.HmacCredential{
+		KeyId:  "webhooks",
+		Secret: "s3cr3t",
+		Roles:  []string{"webhook"},
+	}), time.Minute)
+	env := newTestEnv(t)
+	router := NewEchoAdapter(micro.RouterConfig{HmacVerifier: verifier})
+	router.POST("/hooks", func(ctx micro.Ctx) (any, error) {
+		return h.Map{"id": ctx.Auth.UserId}, nil
+	}, middleware.AuthenticatedWithRole("webhook"))
+
+	server := tests.HttpTest(t, router.Handler(), env.Close)
+	defer server.Teardown()
+
+	body := h.Map{"event": "ping"}
+	payload, _ := h.ToJsonString(body)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := micro.SignRequest("s3cr3t", ts, "POST", "/hooks", []byte(payload))
+
+	signed := func() *tests.HttpRequest {
+		return server.POST("/hooks", body).
+			Header(micro.HeaderSignatureKey, "webhooks").
+			Header(micro.HeaderSignatureTimestamp, ts)
+	}
+
+	signed().Header(micro.HeaderSignature, "bad").Expect().IsUnauthorized()
+	signed().Header(micro.HeaderSignature, signature).Expect().IsOK().
+		JSON().Object().Path("$.id").String().IsEqual("webhooks")
+	// replay
+	signed().Header(micro.HeaderSignature, signature).Expect().IsUnauthorized()
+
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	server.POST("/hooks", body).
+		Header(micro.HeaderSignatureKey, "webhooks").
+		Header(micro.HeaderSignatureTimestamp, old).
+		Header(micro.HeaderSignature, micro.SignRequest("s3cr3t", old, "POST", "/hooks", []byte(payload))).
+		Expect().IsUnauthorized()
+}
+
+func TestHmacReplayAcrossInstances(t *testing.T) {
+	env := newTestEnv(t, &micro.DistributedLock{})
+	defer env.Close()
+	store := micro.NewFixedHmacCredentialStore(micro.HmacCredential{KeyId: "webhooks", Secret: "s3cr3t"})
+	locker := micro.NewDbLocker(env.DB[micro.DefaultTenantId], "")
+	first := micro.NewHmacVerifier(store, time.Minute)
+	first.UseLocker(locker)
+	second := micro.NewHmacVerifier(store, time.Minute)
+	second.UseLocker(locker)
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := micro.SignRequest("s3cr3t", ts, "POST", "/hooks", nil)
+	_, err := first.Verify("webhooks", ts, signature, "POST", "/hooks", nil)
+	assert.Nil(t, err)
+	_, err = second.Verify("webhooks", ts, signature, "POST", "/hooks", nil)
+	assert.EqualError(t, err, errors.Unauthorized("signature_replayed").Error())
+}
+
+func TestDbRolePermissionsCache(t *testing.T) {
+	env := newTestEnv(t, &micro.RolePermission{})
+	defer env.Close()
+	roles := micro.NewDbRolePermissions()
+	ctx := micro.NewCtx(micro.DefaultTenantId)
+
+	assert.Nil(t, roles.Grant(ctx, "manager", "orders:read"))
+	assert.Equal(t, []string{"orders:read"}, roles.PermissionsFor(ctx, []string{"manager"}))
+
+	// cached until it expires or the permissions change through Grant/Revoke
+	assert.Nil(t, env.DB[micro.DefaultTenantId].Save(&micro.RolePermission{Role: "manager", Permission: "orders:edit"}))
+	assert.Equal(t, []string{"orders:read"}, roles.PermissionsFor(ctx, []string{"manager"}))
+	assert.Nil(t, roles.Revoke(ctx, "manager", "orders:read"))
+	assert.Equal(t, []string{"orders:edit"}, roles.PermissionsFor(ctx, []string{"manager"}))
+
+	roles.UseCacheTTL(0)
+	assert.Nil(t, env.DB[micro.DefaultTenantId].Save(&micro.RolePermission{Role: "manager", Permission: "orders:read"}))
+	assert.ElementsMatch(t, []string{"orders:read", "orders:edit"}, roles.PermissionsFor(ctx, []string{"manager"}))
+}
@@ -0,0 +1,127 @@
+package adapters
+
+import (
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCloudEvents(t *testing.T) {
+	micro.Reset()
+	env := newTestEnv(t)
+	defer env.Close()
+
+	var received []micro.Event
+	var tenants []string
+	_ = micro.Subscribe("orders", func(ctx micro.Ctx, payload micro.Event) error {
+		received = append(received, payload)
+		tenants = append(tenants, ctx.TenantId+"/"+ctx.CorrelationId)
+		return nil
+	})
+	router := NewEchoAdapter(micro.RouterConfig{})
+	micro.RegisterCloudEventRoute(router, "/events")
+	server := httptest.NewServer(router.Handler())
+	defer server.Close()
+
+	for _, binary := range []bool{false, true} {
+		sender := micro.NewBus(NewHttpPushTransport(HttpPushConfig{
+			Endpoints: map[string][]string{"orders": {server.URL + "/events"}},
+			Source:    "https://billing.example.com",
+			Binary:    binary,
+		}))
+		ctx := micro.NewCtx(micro.DefaultTenantId)
+		ctx.CorrelationId = "req-1"
+		sender.Publish(ctx, "orders", micro.Event{Subject: "o1", Event: "order.created", Data: map[string]any{"total": 10}})
+		sender.Publish(ctx, "invoices", micro.Event{Subject: "i1", Event: "invoice.created"})
+		sender.WaitAsync()
+	}
+
+	assert.Len(t, received, 2)
+	for _, evt := range received {
+		assert.Equal(t, "o1", evt.Subject)
+		assert.Equal(t, "order.created", evt.Event)
+		assert.Equal(t, float64(10), evt.Data.(map[string]interface{})["total"])
+	}
+	assert.Equal(t, []string{micro.DefaultTenantId + "/req-1", micro.DefaultTenantId + "/req-1"}, tenants)
+
+	// binary mode from another stack, without our extensions
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/events", strings.NewReader(`{"total":5}`))
+	req.Header.Set("ce-specversion", "1.0")
+	req.Header.Set("ce-id", "A234-1234-1234")
+	req.Header.Set("ce-source", "/sales")
+	req.Header.Set("ce-type", "orders")
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Len(t, received, 3)
+	assert.Equal(t, micro.DefaultTenantId+"/A234-1234-1234", tenants[2])
+
+	// missing required attributes
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"/events", strings.NewReader(`{"specversion":"1.0","id":"1"}`))
+	req.Header.Set("Content-Type", micro.CloudEventsContentType)
+	res, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestCloudEventJson(t *testing.T) {
+	ce, err := micro.ToCloudEvent(micro.NewCtx("acme"), "/billing", "invoices", micro.Event{Event: "invoice.paid", Data: "ok"})
+	assert.Nil(t, err)
+	data, err := ce.MarshalJSON()
+	assert.Nil(t, err)
+
+	var decoded micro.CloudEvent
+	assert.Nil(t, decoded.UnmarshalJSON(data))
+	assert.Nil(t, decoded.Validate())
+	assert.Equal(t, ce.Id, decoded.Id)
+	assert.Equal(t, "acme", decoded.Extensions[micro.CloudEventTenant])
+	assert.True(t, ce.Time.Equal(decoded.Time))
+
+	topic, payload, err := micro.FromCloudEvent(decoded)
+	assert.Nil(t, err)
+	assert.Equal(t, "invoices", topic)
+	assert.Equal(t, micro.Event{Event: "invoice.paid", Data: "ok"}, payload)
+
+	decoded.DataContentType = "text/plain"
+	decoded.Data = []byte("hello")
+	data, _ = decoded.MarshalJSON()
+	assert.Contains(t, string(data), `"data_base64":"aGVsbG8="`)
+	assert.Nil(t, decoded.UnmarshalJSON(data))
+	assert.Equal(t, "hello", string(decoded.Data))
+}
+
+func TestHttpPushFailures(t *testing.T) {
+	var calls int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer flaky.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	transport := NewHttpPushTransport(HttpPushConfig{
+		Endpoints: map[string][]string{"orders": {down.URL + "/events"}, "*": {flaky.URL + "/events"}},
+		Retries:   1,
+		Backoff:   time.Millisecond,
+	})
+	defer transport.Close()
+	var received int32
+	assert.Nil(t, transport.Subscribe("orders", micro.SubscribeOptions{}, func(ctx micro.Ctx, payload micro.Event) error {
+		atomic.AddInt32(&received, 1)
+		return nil
+	}))
+
+	// the local handlers get the event even though an endpoint is down, the other endpoint gets it after a retry
+	assert.Nil(t, transport.Publish(micro.NewCtx(micro.DefaultTenantId), "orders", micro.Event{Subject: "o1", Event: "order.created"}))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+	transport.WaitAsync()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,72 @@
+package adapters
+
+import (
+	"fmt"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestEventRetriesAndDeadLetters(t *testing.T) {
+	micro.Reset()
+	env := newTestEnv(t, &micro.DeadLetter{})
+	defer env.Close()
+	env.DeadLetters = true
+
+	calls := 0
+	broken := true
+	err := micro.SubscribeWith("invoices", micro.SubscribeOptions{
+		Name:  "invoices.mailer",
+		Async: true,
+		Retry: &micro.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+	}, func(ctx micro.Ctx, payload micro.Event) error {
+		calls++
+		if broken {
+			return fmt.Errorf("smtp down")
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	retry := &micro.RetryPolicy{MaxAttempts: 3}
+	assert.NotNil(t, micro.SubscribeWith("invoices", micro.SubscribeOptions{Async: true, Retry: retry}, nil))
+	assert.NotNil(t, micro.SubscribeWith("invoices", micro.SubscribeOptions{Name: "invoices.sync", Retry: retry}, nil))
+	assert.NotNil(t, micro.SubscribeWith("invoices", micro.SubscribeOptions{Name: "invoices.mailer", Async: true, Retry: retry}, nil))
+
+	var dead []micro.Event
+	_ = micro.Subscribe("invoices.dead", func(ctx micro.Ctx, payload micro.Event) error {
+		dead = append(dead, payload)
+		return nil
+	})
+	_ = micro.SubscribeAsync("invoices", func(ctx micro.Ctx, payload micro.Event) error {
+		panic("async boom")
+	})
+
+	ctx := micro.NewCtx(micro.DefaultTenantId)
+	micro.Publish(ctx, "invoices", micro.Event{Subject: "inv1", Event: "invoice.created"})
+	micro.WaitAsync()
+
+	assert.Equal(t, 3, calls)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, "smtp down", dead[0].Error)
+
+	stats := micro.EventStats()["invoices"]
+	assert.Equal(t, int64(2), stats.Retried)
+	assert.Equal(t, int64(1), stats.DeadLettered)
+	assert.Equal(t, int64(1), stats.Panics)
+
+	letters, err := micro.ListDeadLetters(ctx, true)
+	assert.Nil(t, err)
+	assert.Len(t, letters, 1)
+	assert.Equal(t, "invoices.mailer", letters[0].Subscription)
+	assert.Equal(t, "smtp down", letters[0].Reason)
+
+	assert.NotNil(t, micro.ReplayDeadLetter(ctx, letters[0].Id))
+	broken = false
+	assert.Nil(t, micro.ReplayDeadLetter(ctx, letters[0].Id))
+	assert.Equal(t, 5, calls)
+
+	letters, err = micro.ListDeadLetters(ctx, true)
+	assert.Nil(t, err)
+	assert.Empty(t, letters)
+}
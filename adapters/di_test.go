@@ -0,0 +1,47 @@
+package adapters
+
+import (
+	"github.com/fabriqs/go-micro/di"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/tests"
+	"github.com/fabriqs/go-micro/util/h"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type requestAudit struct {
+	requestId string
+	entries   []string
+}
+
+func TestRequestScope(t *testing.T) {
+	env := newTestEnv(t)
+	assert.Nil(t, env.Container.Provide(func(ctx micro.Ctx) *requestAudit {
+		return &requestAudit{requestId: ctx.CorrelationId}
+	}, di.WithLifetime(di.Scoped)))
+
+	router := NewEchoAdapter(micro.RouterConfig{})
+	router.GET("/audit", func(ctx micro.Ctx) (any, error) {
+		audit, err := micro.Inject[*requestAudit](ctx)
+		if err != nil {
+			return nil, err
+		}
+		audit.entries = append(audit.entries, "read")
+		again, _ := micro.Inject[*requestAudit](ctx)
+		again.entries = append(again.entries, "checked")
+		return h.Map{"request_id": audit.requestId, "entries": len(again.entries)}, nil
+	})
+	server := tests.HttpTest(t, router.Handler(), env.Close)
+	defer server.Teardown()
+
+	first := server.GET("/audit").Expect().IsOK().JSON()
+	first.Path("$.entries").Number().IsEqual(2)
+	first.Path("$.request_id").String().NotEmpty()
+	server.GET("/audit").Expect().IsOK().JSON().Path("$.entries").Number().IsEqual(2)
+
+	_, err := micro.Inject[*requestAudit](micro.NewCtx(micro.DefaultTenantId))
+	assert.NotNil(t, err)
+	env2, err := micro.Inject[*micro.Env](micro.NewCtx(micro.DefaultTenantId))
+	assert.Nil(t, err)
+	assert.Same(t, env, env2)
+}
@@ -1,11 +1,18 @@
 package adapters
 
 import (
-	"fmt"
 	"github.com/fabriqs/go-micro/micro"
 	"github.com/fabriqs/go-micro/util/h"
 	"github.com/go-resty/resty/v2"
 	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	discordMaxAttempts = 3
+	discordMaxWait     = 5 * time.Second
 )
 
 type discordClient struct {
@@ -26,17 +33,34 @@ func NewDiscordClient(webhook string) micro.NotificationService {
 }
 
 func (s *discordClient) Send(_ micro.Ctx, message micro.Notification) error {
-	out, err := s.client.R().
-		SetBody(h.Map{
-			"content": message.Message,
-		}).
-		SetContentLength(true).
-		Post(s.webHookUrl)
-	if err != nil {
-		return fmt.Errorf("failed to send discord message -- %v", err)
+	body := h.Map{
+		"content": message.Message,
+	}
+	// plain messages are posted as before, the others as an embed colored by severity
+	if message.Title != "" || message.Severity != "" || len(message.Fields) > 0 || len(message.Links) > 0 {
+		color, _ := strconv.ParseInt(strings.TrimPrefix(severityColor(message.Severity), "#"), 16, 64)
+		fields := make([]h.Map, 0, len(message.Fields))
+		for _, field := range message.Fields {
+			fields = append(fields, h.Map{"name": field.Name, "value": field.Value, "inline": field.Inline})
+		}
+		description := message.Message
+		for _, link := range message.Links {
+			description += "\n[" + link.Title + "](" + link.Url + ")"
+		}
+		body = h.Map{"embeds": []h.Map{{
+			"title":       message.Title,
+			"description": description,
+			"color":       color,
+			"fields":      fields,
+		}}}
 	}
-	if out.IsError() {
-		return fmt.Errorf("failed to send discord message -- %v", out.Body())
+	// short rate limits are waited for, the longer ones are returned to the caller (see micro.ThrottledNotifier)
+	for attempt := 1; ; attempt++ {
+		err := postNotification(s.client, s.webHookUrl, body, nil, "discord")
+		limited, ok := err.(*micro.RateLimitError)
+		if !ok || attempt >= discordMaxAttempts || limited.RetryAfter > discordMaxWait {
+			return err
+		}
+		time.Sleep(limited.RetryAfter)
 	}
-	return nil
 }
@@ -2,6 +2,7 @@ package adapters
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/fabriqs/go-micro/micro"
 	"github.com/fabriqs/go-micro/schema"
@@ -15,15 +16,33 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 	log "github.com/sirupsen/logrus"
 	echoSwagger "github.com/swaggo/echo-swagger"
+	"net"
 	"net/http"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 )
 
 var validate *validator.Validate
 
+// shutdownTimeout bounds Shutdown, the requests still running after it are dropped
+const shutdownTimeout = 10 * time.Second
+
 //goland:noinspection GoTypeAssertionOnErrors
 func Bind(c echo.Context, input interface{}) error {
+	if b, ok := input.(micro.RequestBinder); ok {
+		if err := b.BindRequest(c.Request()); err != nil {
+			return echo.NewHTTPError(
+				http.StatusBadRequest,
+				schema.ErrorResponse{
+					Kind:    "input.bindind",
+					Message: "invalid_request_payload",
+					Errors:  err.Error(),
+				})
+		}
+		return nil
+	}
 
 	binder := &echo.DefaultBinder{}
 	if err := binder.Bind(input, c); err != nil {
@@ -70,27 +89,23 @@ func Bind(c echo.Context, input interface{}) error {
 
 type echoRouterAdapter struct {
 	micro.Router
-	e *echo.Echo
+	e     *echo.Echo
+	ready chan struct{}
+	// done is closed by Shutdown to end the streams, which would block it otherwise
+	done      chan struct{}
+	doneOnce  sync.Once
+	heartbeat time.Duration
 }
 
 func NewEchoAdapter(config micro.RouterConfig) micro.Router {
 	e := echo.New()
 	e.HideBanner = true
 
-	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			tenantId := c.Request().Header.Get("X-TenantId")
-			if tenantId == "" {
-				tenantId = micro.DefaultTenantId
-			}
-			auth := &micro.Authentication{
-				Authenticated: false,
-				TenantId:      tenantId,
-			}
-			c.Set(micro.AuthKey, auth)
-			return next(c)
-		}
-	})
+	tenancy := config.Tenancy
+	if tenancy == nil {
+		tenancy = micro.NewTenantResolution(nil)
+	}
+	e.Pre(tenantMiddleware(tenancy))
 	e.Use(middleware.GzipWithConfig(middleware.GzipConfig{
 		Level: 5,
 	}))
@@ -156,13 +171,16 @@ func NewEchoAdapter(config micro.RouterConfig) micro.Router {
 				issuer, _ := claims0.GetIssuer()
 
 				tenantId := micro.DefaultTenantId
-				if config.MultiTenant && issuer != "" {
-					tenantId = issuer
+				if config.MultiTenant && tenancy.Has(micro.TenantFromClaim) {
+					if value := tokenClaim(claims0, tenancy.ClaimName()); value != "" {
+						tenantId = value
+					}
 				}
 
 				auth := &micro.Authentication{
 					UserId:        sub,
 					Authenticated: true,
+					Scheme:        micro.AuthSchemeBearer,
 					TenantId:      tenantId,
 					Token: &micro.AuthToken{
 						Issuer: issuer,
@@ -203,12 +221,33 @@ func NewEchoAdapter(config micro.RouterConfig) micro.Router {
 		}))
 	}
 
+	if config.ApiKeys != nil {
+		e.Use(apiKeyMiddleware(config.ApiKeys))
+	}
+	if config.HmacVerifier != nil {
+		e.Use(hmacMiddleware(config.HmacVerifier))
+	}
+	if config.Sessions != nil {
+		e.Use(sessionMiddleware(config.Sessions))
+	}
+	e.Use(tenantGuard(tenancy))
+
 	e.GET("/health", func(c echo.Context) error {
 		status := schema.NewHealthStatus()
+		if config.Health != nil {
+			status = config.Health(c.Request().Context())
+		}
+		if status.Status != "UP" {
+			return c.JSON(http.StatusServiceUnavailable, status)
+		}
 		return c.JSON(http.StatusOK, status)
 	})
 
-	return &echoRouterAdapter{e: e}
+	heartbeat := config.StreamHeartbeat
+	if heartbeat <= 0 {
+		heartbeat = 15 * time.Second
+	}
+	return &echoRouterAdapter{e: e, ready: make(chan struct{}), done: make(chan struct{}), heartbeat: heartbeat}
 }
 
 func (r *echoRouterAdapter) Handler() http.Handler {
@@ -216,11 +255,30 @@ func (r *echoRouterAdapter) Handler() http.Handler {
 }
 
 func (r *echoRouterAdapter) Start(addr string) error {
+	// listen first, so that Ready is closed when the port is bound
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	r.e.Listener = listener
+	close(r.ready)
 	return r.e.Start(addr)
 }
 
+func (r *echoRouterAdapter) Ready() <-chan struct{} {
+	return r.ready
+}
+
 func (r *echoRouterAdapter) Shutdown() error {
-	return r.e.Shutdown(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return r.ShutdownContext(ctx)
+}
+
+// ShutdownContext drops the requests still running once ctx is done
+func (r *echoRouterAdapter) ShutdownContext(ctx context.Context) error {
+	r.doneOnce.Do(func() { close(r.done) })
+	return r.e.Shutdown(ctx)
 }
 
 func (r *echoRouterAdapter) GET(path string, handler interface{}, filters ...micro.MiddlewareFunc) {
@@ -243,6 +301,12 @@ func (r *echoRouterAdapter) DELETE(path string, handler interface{}, filters ...
 	r.request(http.MethodDelete, path, handler, filters)
 }
 
+func (r *echoRouterAdapter) Stream(path string, handler micro.StreamHandler, filters ...micro.MiddlewareFunc) {
+	r.e.GET(path, func(c echo.Context) error {
+		return handleStream(c, handler, r.done, r.heartbeat)
+	}, createMiddlewares(filters)...)
+}
+
 func (r *echoRouterAdapter) request(method string, path string, handler interface{}, filters []micro.MiddlewareFunc) {
 	r.e.Match([]string{method}, path, func(c echo.Context) (err error) {
 		defer func() {
@@ -250,13 +314,18 @@ func (r *echoRouterAdapter) request(method string, path string, handler interfac
 				err = mapHttpResponse(err0.(error), c)
 			}
 		}()
-		return handleRequest(c, handler)
+		if err = handleRequest(c, handler); err != nil {
+			return mapHttpResponse(err, c)
+		}
+		return nil
 	}, createMiddlewares(filters)...)
 }
 
 func (r *echoRouterAdapter) Group(path string, filters ...micro.MiddlewareFunc) micro.BaseRouter {
 	return &echoGroupRoute{
-		g: r.e.Group(path, createMiddlewares(filters)...),
+		g:         r.e.Group(path, createMiddlewares(filters)...),
+		done:      r.done,
+		heartbeat: r.heartbeat,
 	}
 }
 
@@ -266,8 +335,10 @@ func (r *echoRouterAdapter) Group(path string, filters ...micro.MiddlewareFunc)
 
 type echoGroupRoute struct {
 	micro.BaseRouter
-	g   *echo.Group
-	ctx micro.Ctx
+	g         *echo.Group
+	ctx       micro.Ctx
+	done      <-chan struct{}
+	heartbeat time.Duration
 }
 
 func (r *echoGroupRoute) GET(path string, handler interface{}, filters ...micro.MiddlewareFunc) {
@@ -290,8 +361,13 @@ func (r *echoGroupRoute) DELETE(path string, handler interface{}, filters ...mic
 	r.request(http.MethodDelete, path, handler, filters)
 }
 
-func (r *echoGroupRoute) request(method string, path string, handler interface{}, filters []micro.MiddlewareFunc) {
+func (r *echoGroupRoute) Stream(path string, handler micro.StreamHandler, filters ...micro.MiddlewareFunc) {
+	r.g.GET(path, func(c echo.Context) error {
+		return handleStream(c, handler, r.done, r.heartbeat)
+	}, createMiddlewares(filters)...)
+}
 
+func (r *echoGroupRoute) request(method string, path string, handler interface{}, filters []micro.MiddlewareFunc) {
 	r.g.Match([]string{method}, path, func(c echo.Context) (err error) {
 		defer func() {
 			if err0 := recover(); err0 != nil {
@@ -299,8 +375,10 @@ func (r *echoGroupRoute) request(method string, path string, handler interface{}
 				err = mapHttpResponse(err0.(error), c)
 			}
 		}()
-		return handleRequest(c, handler)
-
+		if err = handleRequest(c, handler); err != nil {
+			return mapHttpResponse(err, c)
+		}
+		return nil
 	}, createMiddlewares(filters)...)
 }
 
@@ -429,6 +507,83 @@ func mapHttpResponse(err error, c echo.Context) error {
 	return err
 }
 
+// =================================================================================
+// SERVER-SENT EVENTS
+// =================================================================================
+
+type echoEventStream struct {
+	micro.EventStream
+	c  echo.Context
+	mu sync.Mutex
+}
+
+func (s *echoEventStream) Send(event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := s.c.Response()
+	if event != "" {
+		if _, err = fmt.Fprintf(w, "event: %s\n", event); err != nil {
+			return err
+		}
+	}
+	if _, err = fmt.Fprintf(w, "data: %s\n\n", payload); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
+func (s *echoEventStream) heartbeat() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := s.c.Response()
+	if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
+// handleStream runs the handler until the client disconnects or the router shuts down (done), with a heartbeat
+func handleStream(c echo.Context, handler micro.StreamHandler, done <-chan struct{}, heartbeat time.Duration) error {
+	streamCtx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+	ctx := createRouteContext(c).WithContext(streamCtx)
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+	stream := &echoEventStream{c: c}
+	go func() {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-streamCtx.Done():
+				return
+			case <-done:
+				cancel()
+				return
+			case <-ticker.C:
+				if err := stream.heartbeat(); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	if err := handler(ctx, stream); err != nil {
+		log.Errorf("stream %s closed: %v", c.Request().RequestURI, err)
+	}
+	return nil
+}
+
 // =================================================================================
 // INIT
 // =================================================================================
@@ -449,12 +604,18 @@ func createMiddlewares(filters []micro.MiddlewareFunc) []echo.MiddlewareFunc {
 }
 
 func createRouteContext(c echo.Context) micro.Ctx {
+	var ctx micro.Ctx
 	value := c.Get(micro.AuthKey)
 	if value == nil {
-		return micro.NewCtx(micro.DefaultTenantId)
+		ctx = micro.NewCtx(micro.DefaultTenantId)
+	} else {
+		ctx = micro.NewAuthCtx(value.(*micro.Authentication))
+	}
+	if control, ok := c.Get(micro.SessionKey).(micro.SessionControl); ok {
+		ctx = ctx.WithSession(control)
 	}
-	auth := value.(*micro.Authentication)
-	return micro.NewAuthCtx(auth)
+	ctx.CorrelationId = c.Response().Header().Get(echo.HeaderXRequestID)
+	return ctx.WithContext(c.Request().Context()).WithScope()
 }
 
 func init() {
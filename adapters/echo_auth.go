@@ -0,0 +1,130 @@
+package adapters
+
+import (
+	"bytes"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/util/errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"io"
+)
+
+// =================================================================================
+// API KEY & HMAC AUTHENTICATION
+// =================================================================================
+
+func apiKeyMiddleware(service micro.ApiKeyService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(micro.HeaderApiKey)
+			if key == "" {
+				return next(c)
+			}
+			auth, err := service.Authenticate(key)
+			if err != nil {
+				return mapHttpResponse(err, c)
+			}
+			c.Set(micro.AuthKey, auth)
+			return next(c)
+		}
+	}
+}
+
+func hmacMiddleware(verifier *micro.HmacVerifier) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			signature := req.Header.Get(micro.HeaderSignature)
+			if signature == "" {
+				return next(c)
+			}
+			var body []byte
+			if req.Body != nil {
+				var err error
+				if body, err = io.ReadAll(req.Body); err != nil {
+					return err
+				}
+				req.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			// RequestURI is the target sent by the client, URL.Path may have been rewritten by the tenant resolution
+			uri := req.RequestURI
+			if uri == "" {
+				uri = req.URL.RequestURI()
+			}
+			auth, err := verifier.Verify(
+				req.Header.Get(micro.HeaderSignatureKey),
+				req.Header.Get(micro.HeaderSignatureTimestamp),
+				signature,
+				req.Method,
+				uri,
+				body,
+			)
+			if err != nil {
+				return mapHttpResponse(err, c)
+			}
+			c.Set(micro.AuthKey, auth)
+			return next(c)
+		}
+	}
+}
+
+// =================================================================================
+// TENANT RESOLUTION
+// =================================================================================
+
+func tenantMiddleware(tenancy *micro.TenantResolution) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			tenantId, path := tenancy.Resolve(req)
+			if tenantId != "" && !tenancy.Exists(tenantId) {
+				return mapHttpResponse(errors.ResourceNotFound("tenant_not_found", tenantId), c)
+			}
+			if path != req.URL.Path {
+				req.URL.Path = path
+				req.URL.RawPath = ""
+			}
+			c.Set(micro.TenantKey, tenantId)
+			if tenantId == "" {
+				tenantId = micro.DefaultTenantId
+			}
+			c.Set(micro.AuthKey, &micro.Authentication{
+				Authenticated: false,
+				TenantId:      tenantId,
+			})
+			return next(c)
+		}
+	}
+}
+
+// tenantGuard rejects authenticated requests whose tenant is unknown or differs from the requested tenant.
+func tenantGuard(tenancy *micro.TenantResolution) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth, ok := c.Get(micro.AuthKey).(*micro.Authentication)
+			if !ok || !auth.Authenticated {
+				return next(c)
+			}
+			if !tenancy.Exists(auth.TenantId) {
+				return mapHttpResponse(errors.Forbidden("unknown_tenant", auth.TenantId), c)
+			}
+			if requested, _ := c.Get(micro.TenantKey).(string); requested != "" && requested != auth.TenantId {
+				return mapHttpResponse(errors.Forbidden("tenant_mismatch", requested), c)
+			}
+			return next(c)
+		}
+	}
+}
+
+func tokenClaim(claims jwt.Claims, name string) string {
+	if name == "iss" {
+		issuer, _ := claims.GetIssuer()
+		return issuer
+	}
+	if mapClaims, ok := claims.(jwt.MapClaims); ok {
+		if value, ok := mapClaims[name].(string); ok {
+			return value
+		}
+	}
+	return ""
+}
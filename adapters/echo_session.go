@@ -0,0 +1,121 @@
+package adapters
+
+import (
+	"crypto/subtle"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/util/errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+// =================================================================================
+// COOKIE SESSIONS
+// =================================================================================
+
+type echoSessionControl struct {
+	micro.SessionControl
+	c       echo.Context
+	manager *micro.SessionManager
+	current *micro.Session
+}
+
+func (s *echoSessionControl) Login(auth *micro.Authentication) (*micro.Session, error) {
+	if s.current != nil {
+		_ = s.Logout()
+	}
+	session, value, err := s.manager.Create(auth)
+	if err != nil {
+		return nil, err
+	}
+	s.setCookie(value, session.ExpiresAt)
+	s.current = session
+	if sessionAuth, err := session.Authentication(); err == nil {
+		s.c.Set(micro.AuthKey, sessionAuth)
+	}
+	return session, nil
+}
+
+func (s *echoSessionControl) Logout() error {
+	if cookie, err := s.c.Cookie(s.manager.CookieName); err == nil {
+		if err = s.manager.Destroy(cookie.Value); err != nil {
+			return err
+		}
+	}
+	s.current = nil
+	s.setCookie("", time.Unix(0, 0))
+	return nil
+}
+
+func (s *echoSessionControl) Current() *micro.Session {
+	return s.current
+}
+
+func (s *echoSessionControl) setCookie(value string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     s.manager.CookieName,
+		Value:    value,
+		Path:     s.manager.Path,
+		Domain:   s.manager.Domain,
+		Expires:  expires,
+		Secure:   s.manager.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	s.c.SetCookie(cookie)
+}
+
+// sessionMiddleware authenticates requests carrying a session cookie (unless another scheme already did)
+// and enforces the CSRF token on unsafe methods.
+func sessionMiddleware(manager *micro.SessionManager) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			control := &echoSessionControl{c: c, manager: manager}
+			c.Set(micro.SessionKey, control)
+
+			if auth, ok := c.Get(micro.AuthKey).(*micro.Authentication); ok && auth.Authenticated {
+				return next(c)
+			}
+			cookie, err := c.Cookie(manager.CookieName)
+			if err != nil || cookie.Value == "" {
+				return next(c)
+			}
+			session, refreshed, err := manager.Load(cookie.Value)
+			if err != nil {
+				if _, ok := err.(*errors.UnauthorizedError); ok {
+					control.setCookie("", time.Unix(0, 0))
+					return next(c)
+				}
+				return mapHttpResponse(err, c)
+			}
+			auth, err := session.Authentication()
+			if err != nil {
+				return mapHttpResponse(err, c)
+			}
+			if !isSafeMethod(c.Request().Method) && !validCsrfToken(c, session.CsrfToken) {
+				return mapHttpResponse(errors.Forbidden("invalid_csrf_token"), c)
+			}
+			control.current = session
+			if refreshed {
+				control.setCookie(cookie.Value, session.ExpiresAt)
+			}
+			c.Set(micro.AuthKey, auth)
+			return next(c)
+		}
+	}
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func validCsrfToken(c echo.Context, expected string) bool {
+	token := c.Request().Header.Get(micro.HeaderCsrfToken)
+	if token == "" {
+		token = c.FormValue("_csrf")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
@@ -0,0 +1,140 @@
+package adapters
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/tests"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// flakyMailer fails the first sends
+type flakyMailer struct {
+	micro.Mailer
+	failures int
+	sent     []micro.Email
+}
+
+func (m *flakyMailer) Send(message micro.Email) (string, error) {
+	if m.failures > 0 {
+		m.failures--
+		return "", fmt.Errorf("provider unavailable")
+	}
+	m.sent = append(m.sent, message)
+	return fmt.Sprintf("msg-%d", len(m.sent)), nil
+}
+
+func (m *flakyMailer) SendBatch(messages []micro.Email) ([]string, error) {
+	return micro.SendEach(m, messages)
+}
+
+func TestEmailOutbox(t *testing.T) {
+	env := newTestEnv(t, &micro.QueuedJob{}, &micro.EmailMessage{})
+	defer env.Close()
+	jobs := micro.NewJobs(micro.JobsConfig{Backoff: time.Millisecond, MaxAttempts: 2}, env.TenantLoader)
+	env.Jobs = jobs
+	mailer := &flakyMailer{failures: 1}
+	outbox := micro.NewEmailOutbox(mailer, jobs)
+
+	ctx := micro.NewCtx(micro.DefaultTenantId)
+	welcome := micro.Email{To: []micro.EmailAddress{{Address: "jane@example.com"}}, Subject: "Welcome"}
+	var first string
+	assert.Nil(t, ctx.Tx(func(tx micro.Ctx) error {
+		var err error
+		first, err = outbox.Queue(tx, welcome, micro.EmailOptions{IdempotencyKey: "welcome-jane"})
+		return err
+	}))
+	again, err := outbox.Queue(ctx, welcome, micro.EmailOptions{IdempotencyKey: "welcome-jane"})
+	assert.Nil(t, err)
+	assert.Equal(t, first, again)
+	assert.NotNil(t, ctx.Tx(func(tx micro.Ctx) error {
+		_, _ = outbox.Queue(tx, micro.Email{To: welcome.To, Subject: "Rolled back"})
+		return fmt.Errorf("rollback")
+	}))
+
+	// the first attempt fails and is retried
+	_, err = jobs.RunPending(micro.DefaultTenantId)
+	assert.Nil(t, err)
+	emails, err := outbox.List(ctx, micro.EmailsFilter{})
+	assert.Nil(t, err)
+	assert.Len(t, emails, 1)
+	assert.Equal(t, micro.EmailQueued, emails[0].Status)
+	assert.Equal(t, "provider unavailable", emails[0].LastError)
+
+	time.Sleep(5 * time.Millisecond)
+	_, err = jobs.RunPending(micro.DefaultTenantId)
+	assert.Nil(t, err)
+	emails, _ = outbox.List(ctx, micro.EmailsFilter{Status: micro.EmailSent})
+	assert.Len(t, emails, 1)
+	assert.Equal(t, "msg-1", emails[0].ProviderId)
+	assert.Equal(t, 2, emails[0].Attempts)
+	assert.NotNil(t, emails[0].SentAt)
+	assert.Len(t, mailer.sent, 1)
+	assert.Equal(t, micro.DefaultTenantId, mailer.sent[0].TenantId)
+
+	// gives up after MaxAttempts
+	mailer.failures = 2
+	_, _ = outbox.Queue(ctx, micro.Email{To: welcome.To, Subject: "Reminder"})
+	_, _ = jobs.RunPending(micro.DefaultTenantId)
+	time.Sleep(5 * time.Millisecond)
+	_, _ = jobs.RunPending(micro.DefaultTenantId)
+	emails, _ = outbox.List(ctx, micro.EmailsFilter{Status: micro.EmailFailed})
+	assert.Len(t, emails, 1)
+	assert.Equal(t, "Reminder", emails[0].Subject)
+
+	router := NewEchoAdapter(micro.RouterConfig{})
+	micro.RegisterEmailWebhookRoute(router, "/webhooks/sendgrid", outbox, ParseSendGridEvents, micro.VerifyEmailWebhookSecret("s3cret"))
+	micro.RegisterEmailRoutes(router, outbox)
+	server := tests.HttpTest(t, router.Handler(), nil)
+	webhookEvents := []map[string]any{
+		{"event": "delivered", "sg_message_id": "msg-1.filter0001", "timestamp": 1767225600},
+		{"event": "open", "sg_message_id": "msg-1.filter0001", "timestamp": 1767229200},
+		{"event": "processed", "sg_message_id": "msg-1.filter0001", "timestamp": 1767225600},
+		{"event": "bounce", "sg_message_id": "msg-1.filter0001", "timestamp": 1767232800, "reason": "mailbox full"},
+		{"event": "bounce", "sg_message_id": "unknown.filter0001", "timestamp": 1767232800},
+	}
+	server.POST("/webhooks/sendgrid", webhookEvents).Expect().Status(http.StatusUnauthorized)
+	server.POST("/webhooks/sendgrid", webhookEvents).Header(micro.HeaderWebhookSecret, "wrong").Expect().Status(http.StatusUnauthorized)
+	server.POST("/webhooks/sendgrid", webhookEvents).Header(micro.HeaderWebhookSecret, "s3cret").Expect().IsOK()
+	_, err = ParseSendGridEvents([]byte("not json"), nil)
+	assert.NotNil(t, err)
+
+	res := server.GET("/emails").Params(map[string]string{"status": micro.EmailBounced}).Expect().IsOK().JSON()
+	res.Array().Length().IsEqual(1)
+	res.Path("$[0].id").String().IsEqual(first)
+	res.Path("$[0].last_error").String().IsEqual("mailbox full")
+	res.Path("$[0].delivered_at").String().IsEqual("2026-01-01T00:00:00Z")
+	res.Path("$[0].opened_at").String().IsEqual("2026-01-01T01:00:00Z")
+}
+
+func TestSendGridWebhookVerifier(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.Nil(t, err)
+	verify, err := NewSendGridWebhookVerifier(base64.StdEncoding.EncodeToString(der))
+	assert.Nil(t, err)
+
+	body := []byte(`[{"event":"delivered","sg_message_id":"msg-1.filter0001"}]`)
+	hash := sha256.Sum256(append([]byte("1767225600"), body...))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	assert.Nil(t, err)
+	header := http.Header{}
+	header.Set(sendGridTimestampHeader, "1767225600")
+	header.Set(sendGridSignatureHeader, base64.StdEncoding.EncodeToString(signature))
+	assert.Nil(t, verify(body, header))
+
+	header.Set(sendGridTimestampHeader, "1767225601")
+	assert.NotNil(t, verify(body, header))
+	assert.NotNil(t, verify(body, http.Header{}))
+	_, err = NewSendGridWebhookVerifier("not a key")
+	assert.NotNil(t, err)
+}
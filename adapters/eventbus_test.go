@@ -0,0 +1,45 @@
+package adapters
+
+import (
+	"fmt"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type auditEntry struct {
+	Id    string
+	Event string
+}
+
+func TestEventHandlerTransaction(t *testing.T) {
+	env := newTestEnv(t, &auditEntry{})
+	defer env.Close()
+	bus := micro.NewBus(nil)
+	repo := micro.NewRepoImpl[auditEntry](func(e *auditEntry) {})
+
+	assert.Nil(t, bus.SubscribeWith("orders", micro.SubscribeOptions{Async: true, Tx: true}, func(ctx micro.Ctx, payload micro.Event) error {
+		if err := repo.Create(ctx, &auditEntry{Id: payload.Subject, Event: payload.Event}); err != nil {
+			return err
+		}
+		if payload.Event == "order.failed" {
+			return fmt.Errorf("rollback")
+		}
+		return nil
+	}))
+
+	ctx := micro.NewCtx(micro.DefaultTenantId)
+	err := ctx.Tx(func(tx micro.Ctx) error {
+		bus.Publish(tx, "orders", micro.Event{Subject: "o1", Event: "order.created"})
+		bus.Publish(tx, "orders", micro.Event{Subject: "o2", Event: "order.failed"})
+		return nil
+	})
+	assert.Nil(t, err)
+	bus.WaitAsync()
+
+	entries, err := repo.FindAll(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "o1", entries[0].Id)
+	assert.Equal(t, int64(1), bus.Stats()["orders"].Failed)
+}
@@ -1,21 +1,169 @@
 package adapters
 
 import (
+	"fmt"
 	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/schema"
+	"github.com/fabriqs/go-micro/util/errors"
+	"strings"
+	"sync"
+	"time"
 )
 
+// FakeEmailSender keeps the sent emails in memory. Create one per test to assert what was sent:
+//
+//	mailer := adapters.NewFakeEmailSender()
+//	env.Mailer = mailer
+//	...
+//	welcome, err := mailer.WaitFor(func(e micro.Email) bool { return e.Subject == "Welcome" }, time.Second)
 type FakeEmailSender struct {
 	micro.Mailer
 	EmailSent int
+	mu        sync.Mutex
+	messages  []CapturedEmail
+	// sent is closed and replaced each time an email is sent, it wakes up WaitFor
+	sent chan struct{}
+}
+
+// CapturedEmail is an email sent by FakeEmailSender.
+type CapturedEmail struct {
+	Id     string      `json:"id"`
+	SentAt time.Time   `json:"sent_at"`
+	Email  micro.Email `json:"email"`
 }
 
 func NewFakeEmailSender() *FakeEmailSender {
 	return &FakeEmailSender{
 		EmailSent: 0,
+		sent:      make(chan struct{}),
 	}
 }
 
-func (s *FakeEmailSender) Send(_ micro.Email) error {
+func (s *FakeEmailSender) Send(message micro.Email) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.EmailSent++
-	return nil
+	id := fmt.Sprintf("fake-%d", s.EmailSent)
+	s.messages = append(s.messages, CapturedEmail{Id: id, SentAt: time.Now(), Email: message})
+	close(s.sent)
+	s.sent = make(chan struct{})
+	return id, nil
+}
+
+func (s *FakeEmailSender) SendBatch(messages []micro.Email) ([]string, error) {
+	return micro.SendEach(s, messages)
+}
+
+// Sent returns the sent emails, oldest first.
+func (s *FakeEmailSender) Sent() []micro.Email {
+	return s.Find(func(micro.Email) bool { return true })
+}
+
+// Find returns the sent emails matching the predicate, oldest first.
+func (s *FakeEmailSender) Find(predicate func(micro.Email) bool) []micro.Email {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []micro.Email
+	for _, captured := range s.messages {
+		if predicate(captured.Email) {
+			out = append(out, captured.Email)
+		}
+	}
+	return out
+}
+
+// SentTo returns the emails sent to the address (To, Cc or Bcc), oldest first.
+func (s *FakeEmailSender) SentTo(address string) []micro.Email {
+	return s.Find(func(e micro.Email) bool { return hasRecipient(e, address) })
+}
+
+// LastTo returns the last email sent to the address, false when there is none.
+func (s *FakeEmailSender) LastTo(address string) (micro.Email, bool) {
+	sent := s.SentTo(address)
+	if len(sent) == 0 {
+		return micro.Email{}, false
+	}
+	return sent[len(sent)-1], true
+}
+
+// WaitFor returns the first email matching the predicate, waiting for it to be sent (by a background job for
+// instance) until the timeout.
+func (s *FakeEmailSender) WaitFor(predicate func(micro.Email) bool, timeout time.Duration) (micro.Email, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		s.mu.Lock()
+		sent := s.sent
+		for _, captured := range s.messages {
+			if predicate(captured.Email) {
+				s.mu.Unlock()
+				return captured.Email, nil
+			}
+		}
+		s.mu.Unlock()
+		select {
+		case <-sent:
+		case <-deadline.C:
+			return micro.Email{}, fmt.Errorf("no matching email sent within %s", timeout)
+		}
+	}
+}
+
+// Reset forgets the sent emails.
+func (s *FakeEmailSender) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+	s.EmailSent = 0
+}
+
+func hasRecipient(message micro.Email, address string) bool {
+	for _, recipient := range message.Recipients() {
+		if strings.EqualFold(recipient.Address, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// =================================================================================
+// DEV INBOX
+// =================================================================================
+
+type devInboxFilter struct {
+	To string `query:"to" json:"to"`
+}
+
+type devInboxRef struct {
+	Id string `param:"id" json:"id" validate:"required"`
+}
+
+// RegisterDevInboxRoutes exposes the emails captured by the fake mailer, most recent first (GET /?to=, GET /:id,
+// DELETE /). It is meant for local development, never mount it in production.
+func RegisterDevInboxRoutes(r micro.BaseRouter, inbox *FakeEmailSender, filters ...micro.MiddlewareFunc) {
+	r.GET("", func(ctx micro.Ctx, input devInboxFilter) (any, error) {
+		inbox.mu.Lock()
+		defer inbox.mu.Unlock()
+		out := make([]CapturedEmail, 0, len(inbox.messages))
+		for i := len(inbox.messages) - 1; i >= 0; i-- {
+			if input.To == "" || hasRecipient(inbox.messages[i].Email, input.To) {
+				out = append(out, inbox.messages[i])
+			}
+		}
+		return out, nil
+	}, filters...)
+	r.GET("/:id", func(ctx micro.Ctx, input devInboxRef) (any, error) {
+		inbox.mu.Lock()
+		defer inbox.mu.Unlock()
+		for _, captured := range inbox.messages {
+			if captured.Id == input.Id {
+				return captured, nil
+			}
+		}
+		return nil, errors.ResourceNotFound("email_not_found", input.Id)
+	}, filters...)
+	r.DELETE("", func(ctx micro.Ctx) (any, error) {
+		inbox.Reset()
+		return schema.Ack{Value: "ok"}, nil
+	}, filters...)
 }
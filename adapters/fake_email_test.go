@@ -0,0 +1,51 @@
+package adapters
+
+import (
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/tests"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFakeEmailSender(t *testing.T) {
+	mailer := NewFakeEmailSender()
+	_, err := mailer.SendBatch([]micro.Email{
+		{To: []micro.EmailAddress{{Address: "jane@example.com"}}, Subject: "Welcome"},
+		{To: []micro.EmailAddress{{Address: "john@example.com"}}, Bcc: []micro.EmailAddress{{Address: "Jane@Example.com"}}, Subject: "Invoice"},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, mailer.Sent(), 2)
+	assert.Len(t, mailer.SentTo("jane@example.com"), 2)
+	last, ok := mailer.LastTo("jane@example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "Invoice", last.Subject)
+	_, ok = mailer.LastTo("nobody@example.com")
+	assert.False(t, ok)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_, _ = mailer.Send(micro.Email{To: []micro.EmailAddress{{Address: "jane@example.com"}}, Subject: "Reset your password",
+			TemplateData: map[string]any{"token": "t0k3n"}})
+	}()
+	reset, err := mailer.WaitFor(func(e micro.Email) bool { return e.Subject == "Reset your password" }, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "t0k3n", reset.TemplateData["token"])
+	_, err = mailer.WaitFor(func(e micro.Email) bool { return e.Subject == "Never" }, 10*time.Millisecond)
+	assert.NotNil(t, err)
+
+	env := newTestEnv(t)
+	router := NewEchoAdapter(micro.RouterConfig{})
+	RegisterDevInboxRoutes(router.Group("/dev/emails"), mailer)
+	server := tests.HttpTest(t, router.Handler(), env.Close)
+	inbox := server.GET("/dev/emails").Params(map[string]string{"to": "jane@example.com"}).Expect().IsOK().JSON()
+	inbox.Array().Length().IsEqual(3)
+	inbox.Path("$[0].id").String().IsEqual("fake-3")
+	inbox.Path("$[0].email.Subject").String().IsEqual("Reset your password")
+	server.GET("/dev/emails/fake-1").Expect().IsOK().JSON().Path("$.email.Subject").String().IsEqual("Welcome")
+	server.DELETE("/dev/emails").Expect().IsOK()
+	assert.Empty(t, mailer.Sent())
+
+	// a new mailer per test keeps them isolated
+	assert.Empty(t, NewFakeEmailSender().Sent())
+}
@@ -0,0 +1,88 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/tests"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"testing/fstest"
+)
+
+type featureNote struct {
+	Id   string
+	Text string
+}
+
+func TestFeatureContributions(t *testing.T) {
+	db := NewGormAdapter(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()), "")
+	assert.Nil(t, db.AutoMigrate(&micro.QueuedJob{}))
+	env := &micro.Env{
+		DB:           map[string]micro.DataSource{micro.DefaultTenantId: db},
+		TenantLoader: micro.NewFixedTenantLoader([]string{micro.DefaultTenantId}),
+	}
+	env.Jobs = micro.NewJobs(micro.JobsConfig{}, env.TenantLoader)
+	env.Router = NewEchoAdapter(micro.RouterConfig{Health: env.Health})
+
+	repo := micro.NewRepoImpl[featureNote](func(n *featureNote) {})
+	searchErr := fmt.Errorf("index unavailable")
+	app := &micro.App{Name: t.Name(), Env: env}
+	_, err := app.Init([]micro.Feature{
+		{
+			Name:      "search",
+			DependsOn: []string{"notes"},
+			Health:    func(ctx context.Context) error { return searchErr },
+		},
+		{
+			Name: "notes",
+			Migrations: fstest.MapFS{
+				"00100_feature_notes.sql": {Data: []byte("-- +goose Up\nCREATE TABLE feature_notes (id text PRIMARY KEY, text text);\n")},
+			},
+			JobHandlers: map[string]micro.JobHandler{
+				"note.create": func(ctx micro.Ctx, job *micro.QueuedJob) error {
+					return repo.Create(ctx, &featureNote{Id: job.Id, Text: "from job"})
+				},
+			},
+			Subscriptions: map[string]micro.SubscribeFunc{
+				"feature.notes": func(ctx micro.Ctx, payload micro.Event) error {
+					return repo.Create(ctx, &featureNote{Id: payload.Subject, Text: payload.Event})
+				},
+			},
+			Routes: func(r micro.Router) {
+				r.GET("/notes", func(ctx micro.Ctx) (any, error) {
+					return repo.FindAll(ctx)
+				})
+			},
+			Health: func(ctx context.Context) error { return nil },
+		},
+	})
+	assert.Nil(t, err)
+	features := app.Features()
+	assert.Equal(t, "notes", features[0].Name)
+	assert.Equal(t, "search", features[1].Name)
+
+	// the jobs and subscriptions of a feature only run while it is started
+	ctx := micro.NewCtx(micro.DefaultTenantId)
+	micro.Publish(ctx, "feature.notes", micro.Event{Subject: "n0", Event: "before start"})
+	assert.Nil(t, env.Container.Start(context.Background()))
+	micro.Publish(ctx, "feature.notes", micro.Event{Subject: "n1", Event: "from event"})
+	_, err = env.Jobs.Enqueue(ctx, "note.create", nil)
+	assert.Nil(t, err)
+	count, err := env.Jobs.RunPending(micro.DefaultTenantId)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	assert.Nil(t, env.Container.Stop(context.Background()))
+	micro.Publish(ctx, "feature.notes", micro.Event{Subject: "n2", Event: "after stop"})
+
+	server := tests.HttpTest(t, env.Router.Handler(), env.Close)
+	defer server.Teardown()
+	server.GET("/notes").Expect().IsOK().JSON().Array().Length().IsEqual(2)
+
+	health := server.GET("/health").Expect().Status(http.StatusServiceUnavailable).JSON().Object()
+	health.Path("$.components.notes.status").String().IsEqual("UP")
+	health.Path("$.components.search.details").String().IsEqual("index unavailable")
+	searchErr = nil
+	server.GET("/health").Expect().IsOK()
+}
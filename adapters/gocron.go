@@ -1,9 +1,18 @@
 package adapters
 
 import (
+	"context"
+	"fmt"
 	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/util/dates"
+	"github.com/fabriqs/go-micro/util/errors"
+	"github.com/fabriqs/go-micro/util/ids"
 	"github.com/go-co-op/gocron"
 	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -12,65 +21,497 @@ type GoCronSchedulingAdapter struct {
 	//ctx          micro.Ctx
 	internal     *gocron.Scheduler
 	tenantLoader micro.TenantLoader
+	locker       micro.Locker
+	history      micro.JobHistory
+	mu           sync.RWMutex
+	jobs         map[string]*scheduledJob
+	names        []string
+	// context is cancelled when the running jobs are not over at the end of the grace period of Stop
+	context  context.Context
+	cancel   context.CancelFunc
+	started  bool
+	stopped  bool
+	inflight sync.WaitGroup
+}
+
+// scheduledJob is the state of a job managed by the runtime API
+type scheduledJob struct {
+	sync.Mutex
+	// exec is held while the job runs, unless overlapping runs are allowed
+	exec      sync.Mutex
+	opts      micro.JobOptions
+	handler   micro.SchedulerHandler
+	job       *gocron.Job
+	paused    bool
+	running   int
+	runs      int
+	lastRun   time.Time
+	lastError string
 }
 
 func NewGoCronAdapter(tenantLoader micro.TenantLoader) micro.Scheduler {
 	s := gocron.NewScheduler(time.UTC)
+	c, cancel := context.WithCancel(context.Background())
 	return &GoCronSchedulingAdapter{
 		internal:     s,
 		tenantLoader: tenantLoader,
+		history:      micro.NewMemoryJobHistory(100),
+		jobs:         map[string]*scheduledJob{},
+		context:      c,
+		cancel:       cancel,
 	}
 }
 
+// NewGoCronAdapterWithLocker runs the jobs on a single instance at a time, unless they are JobEveryInstance.
+func NewGoCronAdapterWithLocker(tenantLoader micro.TenantLoader, locker micro.Locker) micro.Scheduler {
+	s := NewGoCronAdapter(tenantLoader).(*GoCronSchedulingAdapter)
+	s.locker = locker
+	return s
+}
+
+// UseHistory replaces the in-memory run history (last 100 runs per job), ex: with micro.NewDbJobHistory.
+func (s *GoCronSchedulingAdapter) UseHistory(history micro.JobHistory) {
+	s.history = history
+}
+
 func (s *GoCronSchedulingAdapter) StartAsync() {
-	s.internal.StartAsync()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started || s.stopped {
+		return
+	}
+	s.started = true
+	var startup []*scheduledJob
+	for _, name := range s.names {
+		if job := s.jobs[name]; job.opts.Startup {
+			startup = append(startup, job)
+		}
+	}
+	if len(startup) == 0 {
+		s.internal.StartAsync()
+		log.Infof("scheduler started")
+		return
+	}
+	sort.SliceStable(startup, func(i, j int) bool {
+		return startup[i].opts.Order < startup[j].opts.Order
+	})
+	go func() {
+		for _, job := range startup {
+			if !job.isPaused() {
+				s.run(job)
+			}
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if !s.stopped {
+			s.internal.StartAsync()
+			log.Infof("scheduler started")
+		}
+	}()
+}
+
+// Stop stops the periodic jobs and waits for the running ones, they are cancelled when ctx is done.
+func (s *GoCronSchedulingAdapter) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		// gocron waits for the jobs it started
+		s.internal.Stop()
+		s.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Infof("scheduler stopped")
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		return errors.Technical("scheduler_drain_timeout", ctx.Err().Error())
+	}
 }
 
 func (s *GoCronSchedulingAdapter) Every(interval string, handler micro.SchedulerHandler) {
-	s.schedule(interval, 0, handler)
+	s.mustSchedule(micro.JobOptions{Every: interval}, handler)
 }
 
 func (s *GoCronSchedulingAdapter) Once(handler micro.SchedulerHandler) {
-	s.schedule("5s", 1, handler)
+	s.mustSchedule(micro.JobOptions{Startup: true}, handler)
 }
 
 func (s *GoCronSchedulingAdapter) EveryTenant(interval string, handler micro.SchedulerHandler) {
-	s.schedule(interval, 0, handler, s.tenantLoader.GetTenant()...)
+	s.mustSchedule(micro.JobOptions{Every: interval, PerTenant: true}, handler)
 }
 
 func (s *GoCronSchedulingAdapter) OncePerTenant(handler micro.SchedulerHandler) {
-	s.schedule("5s", 1, handler, s.tenantLoader.GetTenant()...)
+	s.mustSchedule(micro.JobOptions{Startup: true, PerTenant: true}, handler)
+}
+
+func (s *GoCronSchedulingAdapter) mustSchedule(opts micro.JobOptions, handler micro.SchedulerHandler) {
+	if err := s.Schedule(opts, handler); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (s *GoCronSchedulingAdapter) Schedule(opts micro.JobOptions, handler micro.SchedulerHandler) error {
+	if opts.Startup && (opts.Every != "" || opts.Cron != "") {
+		return errors.Functional("invalid_job", "startup jobs have no Every or Cron")
+	}
+	if !opts.Startup && (opts.Every == "") == (opts.Cron == "") {
+		return errors.Functional("invalid_job", "either Every or Cron is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if opts.Name == "" {
+		opts.Name = fmt.Sprintf("job-%d", len(s.names)+1)
+	}
+	if _, exists := s.jobs[opts.Name]; exists {
+		return errors.Conflict("job_already_scheduled", opts.Name)
+	}
+	if opts.Mode == "" {
+		opts.Mode = micro.JobEveryInstance
+		if s.locker != nil {
+			opts.Mode = micro.JobSingleton
+		}
+	}
+	if opts.Mode == micro.JobSingleton && s.locker == nil {
+		return errors.Functional("invalid_job", "singleton jobs require a Locker")
+	}
+	if opts.LockTTL <= 0 {
+		opts.LockTTL = time.Minute
+	}
+	if opts.Overlap == "" {
+		opts.Overlap = micro.OverlapSkip
+	}
+	if opts.Parallelism <= 0 {
+		opts.Parallelism = 1
+	}
+
+	state := &scheduledJob{opts: opts, handler: handler}
+	if !opts.Startup {
+		job, err := s.schedule(state)
+		if err != nil {
+			return err
+		}
+		state.job = job
+	}
+	s.jobs[opts.Name] = state
+	s.names = append(s.names, opts.Name)
+	return nil
 }
 
-func (s *GoCronSchedulingAdapter) schedule(interval string, limit int, handler func(ctx micro.Ctx) error, tenants ...string) {
-	sched, err := s.internal.Every(interval).Do(func() error {
-		defer func() {
-			if err := recover(); err != nil {
-				log.Error(err)
+func (s *GoCronSchedulingAdapter) schedule(state *scheduledJob) (*gocron.Job, error) {
+	opts := state.opts
+	var sched *gocron.Scheduler
+	if opts.Cron != "" {
+		expression := opts.Cron
+		if opts.TimeZone != "" {
+			if _, err := time.LoadLocation(opts.TimeZone); err != nil {
+				return nil, errors.Functional("invalid_time_zone", opts.TimeZone)
 			}
-		}()
+			expression = fmt.Sprintf("CRON_TZ=%s %s", opts.TimeZone, opts.Cron)
+		}
+		if len(strings.Fields(opts.Cron)) == 6 {
+			sched = s.internal.CronWithSeconds(expression)
+		} else {
+			sched = s.internal.Cron(expression)
+		}
+	} else {
+		sched = s.internal.Every(opts.Every)
+	}
+	if opts.Limit > 0 {
+		sched = sched.LimitRunsTo(opts.Limit)
+	}
+	job, err := sched.Tag(append([]string{opts.Name}, opts.Tags...)...).Do(func() {
+		if state.isPaused() {
+			return
+		}
+		if opts.Jitter > 0 {
+			time.Sleep(time.Duration(rand.Int63n(int64(opts.Jitter))))
+		}
+		s.run(state)
+	})
+	if err != nil {
+		return nil, errors.Functional("invalid_job", err.Error())
+	}
+	return job, nil
+}
+
+func (j *scheduledJob) isPaused() bool {
+	j.Lock()
+	defer j.Unlock()
+	return j.paused
+}
 
-		if tenants == nil || len(tenants) == 0 {
-			err := handler(micro.NewCtx(micro.DefaultTenantId))
+// run runs the job unless the scheduler is stopped, Stop waits for it
+func (s *GoCronSchedulingAdapter) run(job *scheduledJob) {
+	s.mu.RLock()
+	if s.stopped {
+		s.mu.RUnlock()
+		return
+	}
+	s.inflight.Add(1)
+	s.mu.RUnlock()
+	defer s.inflight.Done()
+
+	switch job.opts.Overlap {
+	case micro.OverlapSkip:
+		if !job.exec.TryLock() {
+			log.Warnf("job %s skipped, the previous run is not over", job.opts.Name)
+			now := dates.Now()
+			s.record(micro.JobRun{Job: job.opts.Name, Status: micro.JobRunSkipped, StartedAt: now, EndedAt: &now})
+			return
+		}
+		defer job.exec.Unlock()
+	case micro.OverlapQueue:
+		job.exec.Lock()
+		defer job.exec.Unlock()
+	}
+
+	job.Lock()
+	job.running++
+	job.Unlock()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ran := false
+	var failure error
+	slots := make(chan struct{}, job.opts.Parallelism)
+	for _, tenantId := range s.tenants(job.opts.PerTenant) {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(tenantId string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			executed, err := s.runTenant(job, tenantId)
+			mu.Lock()
+			defer mu.Unlock()
+			ran = ran || executed
 			if err != nil {
-				log.Error(err)
+				log.Errorf("job %s failed for tenant %s: %v", job.opts.Name, tenantId, err)
+				failure = err
 			}
-			return err
+		}(tenantId)
+	}
+	wg.Wait()
 
-		} else {
-			for _, tenantId := range tenants {
-				err := handler(micro.NewCtx(tenantId))
-				if err != nil {
-					log.Error(err)
+	job.Lock()
+	defer job.Unlock()
+	job.running--
+	if !ran && failure == nil {
+		return
+	}
+	job.runs++
+	job.lastRun = time.Now()
+	job.lastError = ""
+	if failure != nil {
+		job.lastError = failure.Error()
+	}
+}
+
+// runTenant runs the job for a tenant, it returns false when a singleton job runs on another instance
+func (s *GoCronSchedulingAdapter) runTenant(job *scheduledJob, tenantId string) (bool, error) {
+	if job.opts.Mode != micro.JobSingleton {
+		return true, s.execute(job, tenantId)
+	}
+	key := fmt.Sprintf("job:%s:%s", job.opts.Name, tenantId)
+	lock, ok, err := s.locker.TryLock(key, job.opts.LockTTL)
+	if err != nil || !ok {
+		if err == nil {
+			log.Debugf("job %s skipped, running on another instance", key)
+		}
+		return false, err
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			log.Errorf("unable to release lock %s: %v", key, err)
+		}
+	}()
+	stop := renewLease(key, lock, job.opts.LockTTL)
+	defer stop()
+	return true, s.execute(job, tenantId)
+}
+
+// execute runs the handler for a tenant and records the run in the history
+func (s *GoCronSchedulingAdapter) execute(job *scheduledJob, tenantId string) error {
+	run := micro.JobRun{Job: job.opts.Name, TenantId: tenantId, Status: micro.JobRunSucceeded, StartedAt: dates.Now()}
+	finished, err := runJob(job.handler, micro.NewCtx(tenantId).WithContext(s.context), job.opts.Timeout)
+	ended := dates.Now()
+	run.EndedAt = &ended
+	if err == micro.ErrJobTimeout {
+		run.Status = micro.JobRunTimeout
+	} else if err != nil {
+		run.Status = micro.JobRunFailed
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	s.record(run)
+	if err == micro.ErrJobTimeout {
+		// the job stays in flight (overlap, lock, Stop) until the handler returns
+		log.Warnf("job %s timed out after %s, waiting for its handler to return", job.opts.Name, job.opts.Timeout)
+		<-finished
+	}
+	return err
+}
+
+func (s *GoCronSchedulingAdapter) record(run micro.JobRun) {
+	run.Id = ids.NewId("run")
+	run.Instance = micro.InstanceId
+	if err := s.history.Record(run); err != nil {
+		log.Errorf("unable to record run of job %s: %v", run.Job, err)
+	}
+}
+
+// renewLease refreshes the lock until stop is called, so that long jobs keep it
+func renewLease(key string, lock micro.Lock, ttl time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := lock.Refresh(ttl); err != nil {
+					log.Errorf("unable to renew lock %s: %v", key, err)
 				}
 			}
-			return nil
 		}
-	})
-	if limit > 0 {
-		sched.LimitRunsTo(limit)
+	}()
+	return func() { close(done) }
+}
+
+// runJob cancels ctx.Context() after timeout and returns ErrJobTimeout, finished is closed once the handler has
+// returned
+func runJob(handler micro.SchedulerHandler, ctx micro.Ctx, timeout time.Duration) (<-chan struct{}, error) {
+	finished := make(chan struct{})
+	if timeout <= 0 {
+		defer close(finished)
+		return finished, safeRunJob(handler, ctx)
+	}
+	c, cancel := context.WithTimeout(ctx.Context(), timeout)
+	done := make(chan error, 1)
+	go func() {
+		defer close(finished)
+		defer cancel()
+		done <- safeRunJob(handler, ctx.WithContext(c))
+	}()
+	select {
+	case err := <-done:
+		return finished, err
+	case <-c.Done():
+		return finished, micro.ErrJobTimeout
+	}
+}
+
+func safeRunJob(handler micro.SchedulerHandler, ctx micro.Ctx) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx)
+}
+
+func (s *GoCronSchedulingAdapter) tenants(perTenant bool) []string {
+	if perTenant && s.tenantLoader != nil {
+		if tenants := s.tenantLoader.GetTenant(); len(tenants) > 0 {
+			return tenants
+		}
+	}
+	return []string{micro.DefaultTenantId}
+}
+
+func (s *GoCronSchedulingAdapter) find(name string) (*scheduledJob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	job, ok := s.jobs[name]
+	if !ok {
+		return nil, errors.ResourceNotFound("job_not_found", name)
 	}
+	return job, nil
+}
+
+func (s *GoCronSchedulingAdapter) Jobs() []micro.JobInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]micro.JobInfo, 0, len(s.names))
+	for _, name := range s.names {
+		job := s.jobs[name]
+		job.Lock()
+		info := micro.JobInfo{
+			Name:      name,
+			Tags:      job.opts.Tags,
+			Schedule:  job.opts.Cron,
+			Mode:      job.opts.Mode,
+			TimeZone:  job.opts.TimeZone,
+			Paused:    job.paused,
+			Running:   job.running > 0,
+			RunCount:  job.runs,
+			LastError: job.lastError,
+		}
+		if job.opts.Startup {
+			info.Schedule = "at startup"
+		} else if info.Schedule == "" {
+			info.Schedule = "every " + job.opts.Every
+		}
+		if !job.lastRun.IsZero() {
+			lastRun := job.lastRun
+			info.LastRun = &lastRun
+		}
+		job.Unlock()
+		if job.job != nil && !info.Paused && s.internal.IsRunning() {
+			if next := job.job.NextRun(); !next.IsZero() {
+				info.NextRun = &next
+			}
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+func (s *GoCronSchedulingAdapter) Pause(name string) error {
+	return s.setPaused(name, true)
+}
+
+func (s *GoCronSchedulingAdapter) Resume(name string) error {
+	return s.setPaused(name, false)
+}
+
+func (s *GoCronSchedulingAdapter) setPaused(name string, paused bool) error {
+	job, err := s.find(name)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	job.Lock()
+	defer job.Unlock()
+	job.paused = paused
+	return nil
+}
+
+func (s *GoCronSchedulingAdapter) Trigger(name string) error {
+	job, err := s.find(name)
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.stopped {
+		return micro.ErrSchedulerStopped
+	}
+	go s.run(job)
+	return nil
+}
+
+func (s *GoCronSchedulingAdapter) History(name string, limit int) ([]micro.JobRun, error) {
+	if _, err := s.find(name); err != nil {
+		return nil, err
 	}
+	return s.history.List(name, limit)
 }
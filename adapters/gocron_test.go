@@ -0,0 +1,203 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/tests"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduledJobs(t *testing.T) {
+	env := newTestEnv(t)
+	scheduler := NewGoCronAdapter(micro.NewFixedTenantLoader([]string{"t1", "t2"}))
+
+	var reports, cleanups int32
+	assert.Nil(t, scheduler.Schedule(micro.JobOptions{
+		Name:      "reports",
+		Tags:      []string{"billing"},
+		Cron:      "0 3 * * *",
+		TimeZone:  "Europe/Paris",
+		PerTenant: true,
+	}, func(ctx micro.Ctx) error {
+		atomic.AddInt32(&reports, 1)
+		return fmt.Errorf("report failed for %s", ctx.TenantId)
+	}))
+	assert.Nil(t, scheduler.Schedule(micro.JobOptions{Name: "cleanup", Every: "1h", Jitter: time.Millisecond}, func(ctx micro.Ctx) error {
+		atomic.AddInt32(&cleanups, 1)
+		return nil
+	}))
+	assert.NotNil(t, scheduler.Schedule(micro.JobOptions{Name: "cleanup", Every: "1h"}, nil))
+	assert.NotNil(t, scheduler.Schedule(micro.JobOptions{Name: "bad", Cron: "0 3 * * *", TimeZone: "Mars/Olympus"}, nil))
+	assert.NotNil(t, scheduler.Schedule(micro.JobOptions{Name: "bad", Cron: "not a cron"}, nil))
+	scheduler.StartAsync()
+
+	jobs := scheduler.Jobs()
+	assert.Len(t, jobs, 2)
+	assert.Equal(t, "reports", jobs[0].Name)
+	assert.Equal(t, "0 3 * * *", jobs[0].Schedule)
+	paris, _ := time.LoadLocation("Europe/Paris")
+	assert.Equal(t, 3, jobs[0].NextRun.In(paris).Hour())
+	assert.Equal(t, 0, jobs[0].NextRun.In(paris).Minute())
+	assert.Equal(t, "every 1h", jobs[1].Schedule)
+
+	router := NewEchoAdapter(micro.RouterConfig{})
+	micro.RegisterSchedulerRoutes(router.Group("/admin"), scheduler)
+	server := tests.HttpTest(t, router.Handler(), env.Close)
+	defer server.Teardown()
+
+	server.POST("/admin/jobs/reports/trigger").Expect().IsOK()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&reports) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool { return scheduler.Jobs()[0].RunCount == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "report failed for t2", scheduler.Jobs()[0].LastError)
+
+	server.POST("/admin/jobs/cleanup/pause").Expect().IsOK()
+	list := server.GET("/admin/jobs").Expect().IsOK().JSON().Array()
+	list.Length().IsEqual(2)
+	assert.True(t, scheduler.Jobs()[1].Paused)
+	assert.Nil(t, scheduler.Jobs()[1].NextRun)
+	server.POST("/admin/jobs/cleanup/resume").Expect().IsOK()
+	assert.False(t, scheduler.Jobs()[1].Paused)
+
+	assert.NotNil(t, scheduler.Trigger("unknown"))
+}
+
+func TestScheduledJobRuns(t *testing.T) {
+	env := newTestEnv(t, &micro.JobRun{})
+	scheduler := NewGoCronAdapter(micro.NewFixedTenantLoader([]string{"t1", "t2", "t3", "t4"})).(*GoCronSchedulingAdapter)
+	scheduler.UseHistory(micro.NewDbJobHistory(env.DB[micro.DefaultTenantId]))
+
+	release := make(chan struct{})
+	var active, peak int32
+	assert.Nil(t, scheduler.Schedule(micro.JobOptions{Name: "sync", Every: "1h", PerTenant: true, Parallelism: 2}, func(ctx micro.Ctx) error {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		<-release
+		return nil
+	}))
+	var cancelled int32
+	assert.Nil(t, scheduler.Schedule(micro.JobOptions{Name: "slow", Every: "1h", Timeout: 20 * time.Millisecond}, func(ctx micro.Ctx) error {
+		<-ctx.Context().Done()
+		atomic.AddInt32(&cancelled, 1)
+		return nil
+	}))
+	stuck := make(chan struct{})
+	assert.Nil(t, scheduler.Schedule(micro.JobOptions{Name: "stuck", Every: "1h", Timeout: 20 * time.Millisecond}, func(ctx micro.Ctx) error {
+		// ignores the cancellation of its context
+		<-stuck
+		return nil
+	}))
+
+	// a second run is skipped while the first one is not over
+	assert.Nil(t, scheduler.Trigger("sync"))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&active) == 2 }, time.Second, 5*time.Millisecond)
+	assert.True(t, scheduler.Jobs()[0].Running)
+	assert.Nil(t, scheduler.Trigger("sync"))
+	assert.Eventually(t, func() bool {
+		runs, _ := scheduler.History("sync", 10)
+		return len(runs) == 1 && runs[0].Status == micro.JobRunSkipped
+	}, time.Second, 5*time.Millisecond)
+	close(release)
+	assert.Eventually(t, func() bool { return scheduler.Jobs()[0].RunCount == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&peak))
+	runs, err := scheduler.History("sync", 10)
+	assert.Nil(t, err)
+	assert.Len(t, runs, 5)
+
+	assert.Nil(t, scheduler.Trigger("slow"))
+	assert.Eventually(t, func() bool { return scheduler.Jobs()[1].RunCount == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, micro.ErrJobTimeout.Error(), scheduler.Jobs()[1].LastError)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&cancelled) == 1 }, time.Second, 5*time.Millisecond)
+
+	// a timed out run is reported, but the job is running until its handler returns
+	assert.Nil(t, scheduler.Trigger("stuck"))
+	assert.Eventually(t, func() bool {
+		runs, _ := scheduler.History("stuck", 10)
+		return len(runs) == 1 && runs[0].Status == micro.JobRunTimeout
+	}, time.Second, 5*time.Millisecond)
+	assert.True(t, scheduler.Jobs()[2].Running)
+	assert.Nil(t, scheduler.Trigger("stuck"))
+	assert.Eventually(t, func() bool {
+		runs, _ := scheduler.History("stuck", 10)
+		return len(runs) == 2 && runs[0].Status == micro.JobRunSkipped
+	}, time.Second, 5*time.Millisecond)
+	close(stuck)
+	assert.Eventually(t, func() bool { return !scheduler.Jobs()[2].Running }, time.Second, 5*time.Millisecond)
+
+	router := NewEchoAdapter(micro.RouterConfig{})
+	micro.RegisterSchedulerRoutes(router.Group("/admin"), scheduler)
+	server := tests.HttpTest(t, router.Handler(), env.Close)
+	defer server.Teardown()
+
+	history := server.GET("/admin/jobs/slow/runs").Expect().IsOK().JSON()
+	history.Array().Length().IsEqual(1)
+	history.Path("$[0].status").String().IsEqual(micro.JobRunTimeout)
+	history.Path("$[0].tenant_id").String().IsEqual(micro.DefaultTenantId)
+
+	_, err = scheduler.History("unknown", 10)
+	assert.NotNil(t, err)
+}
+
+func TestSchedulerLifecycle(t *testing.T) {
+	scheduler := NewGoCronAdapter(micro.NewFixedTenantLoader([]string{micro.DefaultTenantId}))
+
+	var order []string
+	var mu sync.Mutex
+	startup := func(name string) micro.SchedulerHandler {
+		return func(ctx micro.Ctx) error {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+			return nil
+		}
+	}
+	assert.Nil(t, scheduler.Schedule(micro.JobOptions{Name: "seed", Startup: true, Order: 2}, startup("seed")))
+	assert.Nil(t, scheduler.Schedule(micro.JobOptions{Name: "migrate", Startup: true, Order: 1}, startup("migrate")))
+	scheduler.Once(startup("warmup"))
+	assert.NotNil(t, scheduler.Schedule(micro.JobOptions{Name: "bad", Startup: true, Every: "1h"}, nil))
+
+	started := make(chan struct{})
+	var finished, cancelled int32
+	assert.Nil(t, scheduler.Schedule(micro.JobOptions{Name: "export", Every: "1h"}, func(ctx micro.Ctx) error {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&finished, 1)
+		return nil
+	}))
+	hanging := make(chan struct{})
+	assert.Nil(t, scheduler.Schedule(micro.JobOptions{Name: "hanging", Every: "1h"}, func(ctx micro.Ctx) error {
+		close(hanging)
+		<-ctx.Context().Done()
+		atomic.AddInt32(&cancelled, 1)
+		return ctx.Context().Err()
+	}))
+
+	scheduler.StartAsync()
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order) == 3
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"warmup", "migrate", "seed"}, order)
+	assert.Equal(t, "at startup", scheduler.Jobs()[0].Schedule)
+
+	// the periodic jobs start after the startup jobs, Stop waits for them
+	<-started
+	<-hanging
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.NotNil(t, scheduler.Stop(ctx))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&finished))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&cancelled) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, micro.ErrSchedulerStopped, scheduler.Trigger("export"))
+}
@@ -7,6 +7,7 @@ import (
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 	"io/fs"
 	"log"
@@ -26,6 +27,11 @@ func (a adapter) Create(model interface{}) error {
 	return a.internal.Create(model).Error
 }
 
+func (a adapter) CreateIfAbsent(model interface{}) (bool, error) {
+	res := a.internal.Clauses(clause.OnConflict{DoNothing: true}).Create(model)
+	return res.RowsAffected == 1, res.Error
+}
+
 func (a adapter) Save(model interface{}) error {
 	return a.internal.Save(model).Error
 }
@@ -88,6 +94,12 @@ func (a adapter) buildQuery(model any, q micro.Query) *gorm.DB {
 		if q.Select != "" {
 			builder = builder.Select(q.Select)
 		}
+		if q.Offset > 0 {
+			builder = builder.Offset(int(q.Offset))
+		}
+		if q.Limit > 0 {
+			builder = builder.Limit(int(q.Limit))
+		}
 	}
 
 	return builder
@@ -98,6 +110,15 @@ func (a adapter) Patch(model interface{}, id string, data map[string]interface{}
 	return res.RowsAffected, res.Error
 }
 
+func (a adapter) Update(model interface{}, q micro.Query, data map[string]interface{}) (int64, error) {
+	res := a.internal.Model(model).Where(q.W, q.Args...).Updates(data)
+	return res.RowsAffected, res.Error
+}
+
+func (a adapter) Dialect() string {
+	return a.internal.Dialector.Name()
+}
+
 func (a adapter) Ping() error {
 	return a.internal.Exec("SELECT 1").Error
 }
@@ -130,6 +151,10 @@ func (a adapter) Migrate(fs fs.FS, location string) {
 	}
 }
 
+func (a adapter) AutoMigrate(models ...any) error {
+	return a.internal.AutoMigrate(models...)
+}
+
 func NewGormAdapter(url string, schema string) micro.DataSource {
 	db := createLink(url, schema)
 	return &adapter{
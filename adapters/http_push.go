@@ -0,0 +1,126 @@
+package adapters
+
+import (
+	"fmt"
+	"github.com/fabriqs/go-micro/micro"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type HttpPushConfig struct {
+	// Endpoints are the urls events are pushed to, per topic ("*" for all topics)
+	Endpoints map[string][]string
+	// Source is the CloudEvents source of the pushed events, usually the url of the service
+	Source string
+	// Binary pushes in binary mode (ce-* headers), structured mode otherwise
+	Binary bool
+	// Headers are added to each request (ex: Authorization)
+	Headers map[string]string
+	Client  *http.Client
+	// Retries is the number of retries of a failed push (default 3), after Backoff (default 1s) doubled each time
+	Retries int
+	Backoff time.Duration
+}
+
+type httpPushTransport struct {
+	micro.EventTransport
+	cfg    HttpPushConfig
+	local  micro.EventTransport
+	pushes sync.WaitGroup
+}
+
+// NewHttpPushTransport pushes published events as CloudEvents to HTTP endpoints, and delivers them to the local
+// handlers. Events received with micro.RegisterCloudEventRoute are only delivered locally. The pushes run in the
+// background, with retries, so that an endpoint down neither blocks the publishers nor the local handlers.
+func NewHttpPushTransport(cfg HttpPushConfig) micro.EventTransport {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.Retries <= 0 {
+		cfg.Retries = 3
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	return &httpPushTransport{cfg: cfg, local: micro.NewMemoryTransport()}
+}
+
+func (t *httpPushTransport) endpoints(topic string) []string {
+	return append(append([]string{}, t.cfg.Endpoints[topic]...), t.cfg.Endpoints["*"]...)
+}
+
+// Publish delivers the event locally and queues its pushes, it returns the errors of the local handlers.
+func (t *httpPushTransport) Publish(ctx micro.Ctx, topic string, payload micro.Event) error {
+	if endpoints := t.endpoints(topic); len(endpoints) > 0 {
+		if ce, err := micro.ToCloudEvent(ctx, t.cfg.Source, topic, payload); err != nil {
+			log.Errorf("unable to push event %s: %v", topic, err)
+		} else {
+			for _, url := range endpoints {
+				t.pushes.Add(1)
+				go func(url string) {
+					defer t.pushes.Done()
+					t.push(topic, url, ce)
+				}(url)
+			}
+		}
+	}
+	return t.local.Publish(ctx, topic, payload)
+}
+
+func (t *httpPushTransport) PublishLocal(ctx micro.Ctx, topic string, payload micro.Event) error {
+	return t.local.Publish(ctx, topic, payload)
+}
+
+// push sends the event to an endpoint, retrying with a backoff, the last error is logged
+func (t *httpPushTransport) push(topic string, url string, ce micro.CloudEvent) {
+	backoff := t.cfg.Backoff
+	var err error
+	for attempt := 0; attempt <= t.cfg.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = t.send(topic, url, ce); err == nil {
+			return
+		}
+	}
+	log.Errorf("giving up the push of event %s to %s after %d attempts: %v", topic, url, t.cfg.Retries+1, err)
+}
+
+func (t *httpPushTransport) send(topic string, url string, ce micro.CloudEvent) error {
+	req, err := micro.NewCloudEventRequest(url, ce, t.cfg.Binary)
+	if err != nil {
+		return err
+	}
+	for name, value := range t.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	res, err := t.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	_ = res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("push of event %s to %s failed with status %d", topic, url, res.StatusCode)
+	}
+	return nil
+}
+
+func (t *httpPushTransport) Subscribe(topic string, opts micro.SubscribeOptions, handle micro.SubscribeFunc) error {
+	return t.local.Subscribe(topic, opts, handle)
+}
+
+// WaitAsync waits for the local async handlers and the pending pushes.
+func (t *httpPushTransport) WaitAsync() {
+	t.local.WaitAsync()
+	t.pushes.Wait()
+}
+
+func (t *httpPushTransport) Close() error {
+	t.pushes.Wait()
+	return t.local.Close()
+}
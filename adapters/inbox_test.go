@@ -0,0 +1,168 @@
+package adapters
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/middleware"
+	"github.com/fabriqs/go-micro/tests"
+	"github.com/fabriqs/go-micro/util/h"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordedPushes struct {
+	micro.PushSender
+	pushed []string
+}
+
+func (p *recordedPushes) Push(_ micro.Ctx, notification *micro.UserNotification) error {
+	p.pushed = append(p.pushed, notification.UserId+":"+notification.Title)
+	return nil
+}
+
+func TestInbox(t *testing.T) {
+	env := newTestEnv(t, &micro.UserNotification{}, &micro.NotificationPreferences{})
+	mailer := NewFakeEmailSender()
+	pushes := &recordedPushes{}
+	inbox := micro.NewInbox(micro.InboxConfig{
+		Mailer: mailer,
+		Push:   pushes,
+		Recipient: func(ctx micro.Ctx, userId string) (*micro.EmailAddress, error) {
+			return &micro.EmailAddress{Address: userId + "@example.com"}, nil
+		},
+	})
+	var hooked []string
+	inbox.OnNotification(func(ctx micro.Ctx, notification *micro.UserNotification) {
+		hooked = append(hooked, notification.Id)
+	})
+
+	provider := micro.NewTokenProvider("secret")
+	router := NewEchoAdapter(micro.RouterConfig{TokenProvider: provider})
+	micro.RegisterInboxRoutes(router, inbox, middleware.Authenticated())
+	server := tests.HttpTest(t, router.Handler(), env.Close)
+	defer server.Teardown()
+	token, _ := provider.CreateJwt("jane", "", "", nil, nil)
+
+	server.GET("/notifications").Expect().IsUnauthorized()
+	// no email nor push for invoices, nothing but urgent ones from 22:00 to 07:00
+	server.PUT("/notifications/preferences", h.Map{
+		"muted_types": []string{"invoice.paid"},
+		"quiet_start": "22:00",
+		"quiet_end":   "07:00",
+		"timezone":    "Europe/Paris",
+	}).BearerAuth(token).Expect().IsOK()
+
+	ctx := micro.NewCtx(micro.DefaultTenantId)
+	assert.NotNil(t, inbox.SavePreferences(ctx, &micro.NotificationPreferences{UserId: "jane", Timezone: "Mars/Olympus"}))
+	assert.NotNil(t, inbox.SavePreferences(ctx, &micro.NotificationPreferences{UserId: "jane", QuietStart: "25:00"}))
+	preferences, err := inbox.Preferences(ctx, "jane")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"invoice.paid"}, preferences.MutedTypes)
+	night := time.Date(2026, 1, 1, 23, 30, 0, 0, time.UTC)
+	assert.True(t, preferences.Allows(micro.ChannelEmail, "comment.added", false, time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)))
+	assert.False(t, preferences.Allows(micro.ChannelPush, "comment.added", false, night))
+	assert.True(t, preferences.Allows(micro.ChannelPush, "comment.added", true, night))
+	assert.True(t, preferences.Allows(micro.ChannelInbox, "invoice.paid", false, night))
+	defaults, _ := inbox.Preferences(ctx, "john")
+	assert.True(t, defaults.Allows(micro.ChannelEmail, "invoice.paid", false, night))
+	// the quiet hours are compared as times, not strings
+	office := &micro.NotificationPreferences{UserId: "joe", QuietStart: "9:00", QuietEnd: "17:00"}
+	assert.Nil(t, inbox.SavePreferences(ctx, office))
+	assert.Equal(t, "09:00", office.QuietStart)
+	assert.False(t, office.Allows(micro.ChannelEmail, "comment.added", false, time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)))
+	assert.True(t, office.Allows(micro.ChannelEmail, "comment.added", false, night))
+	legacy := &micro.NotificationPreferences{QuietStart: "22:00", QuietEnd: "7:00"}
+	assert.False(t, legacy.Allows(micro.ChannelEmail, "comment.added", false, time.Date(2026, 1, 1, 6, 30, 0, 0, time.UTC)))
+
+	stream := httptest.NewServer(router.Handler())
+	defer stream.Close()
+	streamCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(streamCtx, http.MethodGet, stream.URL+"/notifications/stream", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	_, err = inbox.Notify(ctx, micro.UserNotificationRequest{UserId: "jane", Type: "invoice.paid", Title: "Invoice paid"})
+	assert.Nil(t, err)
+	comment, err := inbox.Notify(ctx, micro.UserNotificationRequest{
+		UserId: "jane", Type: "comment.added", Title: "New comment", Body: "Looks good", Link: "https://acme.com/c/1", Urgent: true,
+	})
+	assert.Nil(t, err)
+	_, err = inbox.Notify(ctx, micro.UserNotificationRequest{UserId: "john", Type: "invoice.paid", Title: "Invoice paid"})
+	assert.Nil(t, err)
+	_, err = inbox.Notify(ctx, micro.UserNotificationRequest{UserId: "john"})
+	assert.NotNil(t, err)
+
+	// within a transaction, push, email and real time delivery wait for the commit
+	assert.NotNil(t, ctx.Tx(func(tx micro.Ctx) error {
+		_, err := inbox.Notify(tx, micro.UserNotificationRequest{UserId: "john", Title: "Rolled back"})
+		assert.Nil(t, err)
+		return fmt.Errorf("rollback")
+	}))
+	assert.Nil(t, ctx.Tx(func(tx micro.Ctx) error {
+		_, err := inbox.Notify(tx, micro.UserNotificationRequest{UserId: "john", Title: "Committed"})
+		assert.Len(t, pushes.pushed, 2)
+		return err
+	}))
+	assert.Len(t, mailer.SentTo("john@example.com"), 2)
+
+	assert.Len(t, hooked, 4)
+	assert.Equal(t, []string{"jane:New comment", "john:Invoice paid", "john:Committed"}, pushes.pushed)
+	email, ok := mailer.LastTo("jane@example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "Looks good\n\nhttps://acme.com/c/1", email.Body)
+	assert.Len(t, mailer.SentTo("jane@example.com"), 1)
+
+	events := bufio.NewScanner(res.Body)
+	var received []string
+	for len(received) < 2 && events.Scan() {
+		if strings.HasPrefix(events.Text(), "data: ") {
+			received = append(received, events.Text())
+		}
+	}
+	assert.Len(t, received, 2)
+	assert.Contains(t, received[1], `"title":"New comment"`)
+
+	server.GET("/notifications/unread").BearerAuth(token).Expect().IsOK().JSON().Path("$.unread").Number().IsEqual(2)
+	list := server.GET("/notifications").BearerAuth(token).Expect().IsOK().JSON()
+	list.Array().Length().IsEqual(2)
+	list.Path("$[0].title").String().IsEqual("New comment")
+	server.POST("/notifications/read", h.Map{"ids": []string{comment.Id}}).BearerAuth(token).Expect().IsOK().
+		JSON().Path("$.unread").Number().IsEqual(1)
+	server.GET("/notifications").Params(map[string]any{"unread": true}).BearerAuth(token).Expect().IsOK().
+		JSON().Path("$[0].title").String().IsEqual("Invoice paid")
+	server.POST("/notifications/read", h.Map{}).BearerAuth(token).Expect().IsOK().JSON().Path("$.unread").Number().IsEqual(0)
+	count, _ := inbox.UnreadCount(ctx, "john")
+	assert.Equal(t, int64(2), count)
+}
+
+func TestStreamHeartbeatAndShutdown(t *testing.T) {
+	router := NewEchoAdapter(micro.RouterConfig{StreamHeartbeat: 10 * time.Millisecond})
+	router.Stream("/events", func(ctx micro.Ctx, stream micro.EventStream) error {
+		<-ctx.Context().Done()
+		return nil
+	})
+	server := httptest.NewServer(router.Handler())
+	defer server.Close()
+	res, err := http.Get(server.URL + "/events")
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	lines := bufio.NewScanner(res.Body)
+	assert.True(t, lines.Scan())
+	assert.Equal(t, ": ping", lines.Text())
+
+	// the open stream doesn't hold the shutdown
+	assert.Nil(t, router.Shutdown())
+	for lines.Scan() {
+	}
+	assert.Nil(t, lines.Err())
+}
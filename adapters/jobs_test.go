@@ -0,0 +1,182 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type reportRequest struct {
+	Month string `json:"month"`
+}
+
+func TestJobQueue(t *testing.T) {
+	env := newTestEnv(t, &micro.QueuedJob{})
+	defer env.Close()
+	jobs := micro.NewJobs(micro.JobsConfig{Backoff: time.Millisecond, MaxAttempts: 2}, env.TenantLoader)
+	env.Jobs = jobs
+
+	var reports []string
+	micro.HandleJob(jobs, "report", func(ctx micro.Ctx, payload reportRequest) error {
+		reports = append(reports, payload.Month+"/"+ctx.Auth.UserId)
+		return nil
+	})
+	jobs.Handle("reindex", func(ctx micro.Ctx, job *micro.QueuedJob) error {
+		panic("index unavailable")
+	})
+
+	ctx := micro.NewAuthCtx(&micro.Authentication{UserId: "u1", TenantId: micro.DefaultTenantId})
+	err := ctx.Tx(func(tx micro.Ctx) error {
+		_, err := jobs.Enqueue(tx, "report", reportRequest{Month: "2026-01"})
+		assert.Nil(t, err)
+		return fmt.Errorf("rollback")
+	})
+	assert.NotNil(t, err)
+
+	first, err := jobs.Enqueue(ctx, "report", reportRequest{Month: "2026-02"}, micro.EnqueueOptions{UniqueKey: "2026-02"})
+	assert.Nil(t, err)
+	again, err := jobs.Enqueue(ctx, "report", reportRequest{Month: "2026-02"}, micro.EnqueueOptions{UniqueKey: "2026-02"})
+	assert.Nil(t, err)
+	assert.Equal(t, first, again)
+	// the unique index rejects a concurrent insert of the same key
+	created, err := env.DB[micro.DefaultTenantId].CreateIfAbsent(&micro.QueuedJob{
+		Id: "job_dup", Type: "report", UniqueKey: "2026-02", Status: micro.JobQueued, RunAt: time.Now(),
+	})
+	assert.Nil(t, err)
+	assert.False(t, created)
+	_, _ = jobs.Enqueue(ctx, "report", reportRequest{Month: "2026-03"}, micro.EnqueueOptions{Priority: 10})
+	_, _ = jobs.Enqueue(ctx, "report", reportRequest{Month: "2026-04"}, micro.EnqueueOptions{Delay: time.Hour})
+	dead, _ := jobs.Enqueue(ctx, "reindex", nil)
+
+	count, err := jobs.RunPending(micro.DefaultTenantId)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, []string{"2026-03/u1", "2026-02/u1"}, reports)
+
+	time.Sleep(5 * time.Millisecond)
+	count, err = jobs.RunPending(micro.DefaultTenantId)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	list, err := jobs.List(ctx, micro.JobsFilter{Status: micro.JobDead})
+	assert.Nil(t, err)
+	assert.Len(t, list, 1)
+	assert.Equal(t, dead, list[0].Id)
+	assert.Equal(t, 2, list[0].Attempts)
+	assert.Contains(t, list[0].LastError, "index unavailable")
+
+	list, _ = jobs.List(ctx, micro.JobsFilter{Status: micro.JobQueued})
+	assert.Len(t, list, 1)
+	list, _ = jobs.List(ctx, micro.JobsFilter{Type: "report", Status: micro.JobDone})
+	assert.Len(t, list, 2)
+
+	assert.Nil(t, jobs.Retry(ctx, dead))
+	assert.NotNil(t, jobs.Retry(ctx, dead))
+	list, _ = jobs.List(ctx, micro.JobsFilter{Type: "reindex"})
+	assert.Equal(t, micro.JobQueued, list[0].Status)
+	assert.Equal(t, 0, list[0].Attempts)
+}
+
+func TestJobWorkers(t *testing.T) {
+	env := newTestEnv(t, &micro.QueuedJob{})
+	defer env.Close()
+	jobs := micro.NewJobs(micro.JobsConfig{Workers: 2, Interval: time.Hour}, env.TenantLoader)
+	env.Jobs = jobs
+
+	var mu sync.Mutex
+	var done []string
+	micro.HandleJob(jobs, "report", func(ctx micro.Ctx, payload reportRequest) error {
+		mu.Lock()
+		defer mu.Unlock()
+		done = append(done, payload.Month)
+		return nil
+	})
+	jobs.Start()
+	defer jobs.Stop(context.Background())
+
+	ctx := micro.NewCtx(micro.DefaultTenantId)
+	assert.Nil(t, ctx.Tx(func(tx micro.Ctx) error {
+		_, err := jobs.Enqueue(tx, "report", reportRequest{Month: "2026-05"})
+		return err
+	}))
+	// picked after commit, not after the polling interval
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(done) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestJobsStopTimeout(t *testing.T) {
+	env := newTestEnv(t, &micro.QueuedJob{})
+	defer env.Close()
+	jobs := micro.NewJobs(micro.JobsConfig{Workers: 1, Interval: time.Hour}, env.TenantLoader)
+	env.Jobs = jobs
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	jobs.Handle("stuck", func(ctx micro.Ctx, job *micro.QueuedJob) error {
+		close(started)
+		<-ctx.Context().Done()
+		close(cancelled)
+		return ctx.Context().Err()
+	})
+	jobs.Start()
+
+	ctx := micro.NewCtx(micro.DefaultTenantId)
+	assert.Nil(t, ctx.Tx(func(tx micro.Ctx) error {
+		_, err := jobs.Enqueue(tx, "stuck", nil)
+		return err
+	}))
+	<-started
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.NotNil(t, jobs.Stop(stopCtx))
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the running job was not cancelled")
+	}
+}
+
+func TestJobLeaseRenewal(t *testing.T) {
+	env := newTestEnv(t, &micro.QueuedJob{})
+	defer env.Close()
+	jobs := micro.NewJobs(micro.JobsConfig{Lease: 30 * time.Millisecond}, env.TenantLoader)
+	env.Jobs = jobs
+
+	var runs int32
+	jobs.Handle("slow", func(ctx micro.Ctx, job *micro.QueuedJob) error {
+		atomic.AddInt32(&runs, 1)
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	ctx := micro.NewCtx(micro.DefaultTenantId)
+	id, err := jobs.Enqueue(ctx, "slow", nil)
+	assert.Nil(t, err)
+
+	worked := make(chan struct{})
+	go func() {
+		defer close(worked)
+		ok, err := jobs.Work(micro.DefaultTenantId)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}()
+	// longer than the lease, which is renewed while the job runs
+	time.Sleep(60 * time.Millisecond)
+	ok, err := jobs.Work(micro.DefaultTenantId)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	<-worked
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&runs))
+	list, _ := jobs.List(ctx, micro.JobsFilter{Status: micro.JobDone})
+	assert.Len(t, list, 1)
+	assert.Equal(t, id, list[0].Id)
+}
@@ -0,0 +1,90 @@
+package adapters
+
+import (
+	"context"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/segmentio/kafka-go"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+type kafkaTransport struct {
+	micro.EventTransport
+	brokers []string
+	groups  *micro.SubscriptionGroups
+	writer  *kafka.Writer
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// NewKafkaTransport publishes events on kafka topics, keyed by Event.Subject so that events of the same
+// aggregate land in the same partition and keep their order. Groups are kafka consumer groups.
+func NewKafkaTransport(brokers []string, group string) micro.EventTransport {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &kafkaTransport{
+		brokers: brokers,
+		groups:  micro.NewSubscriptionGroups(group),
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Balancer:               &kafka.Hash{},
+			AllowAutoTopicCreation: true,
+			// Publish is synchronous, the default (1s) would delay each event waiting for a batch
+			BatchTimeout: 5 * time.Millisecond,
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (t *kafkaTransport) Publish(ctx micro.Ctx, topic string, payload micro.Event) error {
+	data, err := micro.EncodeEvent(ctx, topic, payload)
+	if err != nil {
+		return err
+	}
+	return t.writer.WriteMessages(t.ctx, kafka.Message{
+		Topic: topic,
+		Key:   []byte(payload.Subject),
+		Value: data,
+	})
+}
+
+func (t *kafkaTransport) Subscribe(topic string, opts micro.SubscribeOptions, handle micro.SubscribeFunc) error {
+	group := t.groups.Group(topic, opts)
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: t.brokers,
+		GroupID: group,
+		Topic:   topic,
+	})
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer reader.Close()
+		for {
+			msg, err := reader.FetchMessage(t.ctx)
+			if err != nil {
+				if t.ctx.Err() == nil {
+					log.Errorf("unable to read kafka topic %s: %v", topic, err)
+				}
+				return
+			}
+			if err = micro.HandleMessage(msg.Value, handle); err != nil {
+				log.Errorf("error handling event %s: %v", topic, err)
+			}
+			if err = reader.CommitMessages(t.ctx, msg); err != nil && t.ctx.Err() == nil {
+				log.Errorf("unable to commit kafka message %s/%d: %v", topic, msg.Offset, err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (t *kafkaTransport) WaitAsync() {
+}
+
+func (t *kafkaTransport) Close() error {
+	t.cancel()
+	t.wg.Wait()
+	return t.writer.Close()
+}
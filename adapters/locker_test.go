@@ -0,0 +1,110 @@
+package adapters
+
+import (
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDbLocker(t *testing.T) {
+	env := newTestEnv(t, &micro.DistributedLock{})
+	defer env.Close()
+	db := env.DB[micro.DefaultTenantId]
+	first := micro.NewDbLocker(db, "node-1")
+	second := micro.NewDbLocker(db, "node-2")
+
+	lock, ok, err := first.TryLock("reports", 50*time.Millisecond)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, ok, err = second.TryLock("reports", time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// the lease is renewed, then expires
+	time.Sleep(30 * time.Millisecond)
+	assert.Nil(t, lock.Refresh(50*time.Millisecond))
+	time.Sleep(30 * time.Millisecond)
+	_, ok, _ = second.TryLock("reports", time.Minute)
+	assert.False(t, ok)
+	time.Sleep(30 * time.Millisecond)
+	taken, ok, err := second.TryLock("reports", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.NotNil(t, lock.Refresh(time.Minute))
+
+	// releasing a lost lock does not release the new owner's one
+	assert.Nil(t, lock.Release())
+	_, ok, _ = first.TryLock("reports", time.Minute)
+	assert.False(t, ok)
+	assert.Nil(t, taken.Release())
+	_, ok, _ = first.TryLock("reports", time.Minute)
+	assert.True(t, ok)
+}
+
+func TestMemoryLocker(t *testing.T) {
+	locker := micro.NewMemoryLocker()
+	lock, ok, err := locker.TryLock("reports", 20*time.Millisecond)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, lock.Refresh(20*time.Millisecond))
+
+	time.Sleep(30 * time.Millisecond)
+	taken, ok, _ := locker.TryLock("reports", time.Minute)
+	assert.True(t, ok)
+	// the expired lease can neither be renewed nor release the new owner's one
+	assert.NotNil(t, lock.Refresh(time.Minute))
+	assert.Nil(t, lock.Release())
+	_, ok, _ = locker.TryLock("reports", time.Minute)
+	assert.False(t, ok)
+	assert.Nil(t, taken.Release())
+	_, ok, _ = locker.TryLock("reports", time.Minute)
+	assert.True(t, ok)
+}
+
+func TestSingletonJobs(t *testing.T) {
+	locker := micro.NewMemoryLocker()
+	tenants := micro.NewFixedTenantLoader([]string{"t1", "t2"})
+	instances := []micro.Scheduler{
+		NewGoCronAdapterWithLocker(tenants, locker),
+		NewGoCronAdapterWithLocker(tenants, locker),
+	}
+
+	var singleton, everywhere int32
+	for _, scheduler := range instances {
+		assert.Nil(t, scheduler.Schedule(micro.JobOptions{
+			Name: "billing", Every: "1h", PerTenant: true, LockTTL: 30 * time.Millisecond,
+		}, func(ctx micro.Ctx) error {
+			atomic.AddInt32(&singleton, 1)
+			// longer than the lease, which is renewed
+			time.Sleep(100 * time.Millisecond)
+			return nil
+		}))
+		assert.Nil(t, scheduler.Schedule(micro.JobOptions{
+			Name: "cache", Every: "1h", Mode: micro.JobEveryInstance,
+		}, func(ctx micro.Ctx) error {
+			atomic.AddInt32(&everywhere, 1)
+			return nil
+		}))
+	}
+	assert.Equal(t, micro.JobSingleton, instances[0].Jobs()[0].Mode)
+
+	assert.Nil(t, instances[0].Trigger("billing"))
+	time.Sleep(60 * time.Millisecond)
+	assert.Nil(t, instances[1].Trigger("billing"))
+	for _, scheduler := range instances {
+		assert.Nil(t, scheduler.Trigger("cache"))
+	}
+
+	assert.Eventually(t, func() bool {
+		return !instances[0].Jobs()[0].Running && !instances[1].Jobs()[0].Running
+	}, time.Second, 10*time.Millisecond)
+	// each tenant is locked separately: the second instance takes the tenant not locked by the first one
+	assert.Equal(t, int32(2), atomic.LoadInt32(&singleton))
+	assert.Equal(t, 1, instances[0].Jobs()[0].RunCount)
+	assert.Equal(t, 1, instances[1].Jobs()[0].RunCount)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&everywhere) == 2 }, time.Second, 10*time.Millisecond)
+
+	assert.NotNil(t, NewGoCronAdapter(tenants).Schedule(micro.JobOptions{Every: "1h", Mode: micro.JobSingleton}, nil))
+}
@@ -0,0 +1,48 @@
+package adapters
+
+import (
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+)
+
+type natsTransport struct {
+	micro.EventTransport
+	conn   *nats.Conn
+	groups *micro.SubscriptionGroups
+}
+
+// NewNatsTransport publishes events on NATS subjects (one per topic), groups are NATS queue groups.
+func NewNatsTransport(url string, group string) (micro.EventTransport, error) {
+	conn, err := nats.Connect(url, nats.Name(group))
+	if err != nil {
+		return nil, err
+	}
+	return &natsTransport{conn: conn, groups: micro.NewSubscriptionGroups(group)}, nil
+}
+
+func (t *natsTransport) Publish(ctx micro.Ctx, topic string, payload micro.Event) error {
+	data, err := micro.EncodeEvent(ctx, topic, payload)
+	if err != nil {
+		return err
+	}
+	return t.conn.Publish(topic, data)
+}
+
+func (t *natsTransport) Subscribe(topic string, opts micro.SubscribeOptions, handle micro.SubscribeFunc) error {
+	group := t.groups.Group(topic, opts)
+	_, err := t.conn.QueueSubscribe(topic, group, func(msg *nats.Msg) {
+		if err := micro.HandleMessage(msg.Data, handle); err != nil {
+			log.Errorf("error handling event %s: %v", topic, err)
+		}
+	})
+	return err
+}
+
+func (t *natsTransport) WaitAsync() {
+	_ = t.conn.Flush()
+}
+
+func (t *natsTransport) Close() error {
+	return t.conn.Drain()
+}
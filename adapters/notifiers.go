@@ -0,0 +1,194 @@
+package adapters
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/util/h"
+	"github.com/go-resty/resty/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var severityColors = map[micro.Severity]string{
+	micro.SeverityInfo:     "#2f81f7",
+	micro.SeverityWarning:  "#d29922",
+	micro.SeverityError:    "#f85149",
+	micro.SeverityCritical: "#8b0000",
+}
+
+func severityColor(severity micro.Severity) string {
+	if color, ok := severityColors[severity]; ok {
+		return color
+	}
+	return severityColors[micro.SeverityInfo]
+}
+
+// postNotification posts a JSON body to a webhook, a 429 response returns a *micro.RateLimitError
+func postNotification(client *resty.Client, url string, body any, headers map[string]string, channel string) error {
+	out, err := client.R().
+		SetHeaders(headers).
+		SetBody(body).
+		SetContentLength(true).
+		Post(url)
+	if err != nil {
+		return fmt.Errorf("failed to send %s message -- %v", channel, err)
+	}
+	if out.StatusCode() == http.StatusTooManyRequests {
+		return &micro.RateLimitError{Channel: channel, RetryAfter: retryAfter(out)}
+	}
+	if out.IsError() {
+		return fmt.Errorf("failed to send %s message -- %s: %s", channel, out.Status(), out.Body())
+	}
+	return nil
+}
+
+// retryAfter reads the delay of a 429 response: retry_after of the JSON body (Discord, seconds) or the
+// Retry-After header, 1 second by default
+func retryAfter(out *resty.Response) time.Duration {
+	var limited struct {
+		RetryAfter float64 `json:"retry_after"`
+	}
+	if err := json.Unmarshal(out.Body(), &limited); err == nil && limited.RetryAfter > 0 {
+		return time.Duration(limited.RetryAfter * float64(time.Second))
+	}
+	if seconds, err := strconv.Atoi(out.Header().Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return time.Second
+}
+
+// =================================================================================
+// SLACK
+// =================================================================================
+
+type slackClient struct {
+	micro.NotificationService
+	webHookUrl string
+	client     *resty.Client
+}
+
+// NewSlackClient posts the notifications to a Slack incoming webhook, as an attachment colored by severity.
+func NewSlackClient(webhook string) micro.NotificationService {
+	return &slackClient{webHookUrl: webhook, client: resty.New()}
+}
+
+func (s *slackClient) Send(_ micro.Ctx, message micro.Notification) error {
+	fields := make([]h.Map, 0, len(message.Fields))
+	for _, field := range message.Fields {
+		fields = append(fields, h.Map{"title": field.Name, "value": field.Value, "short": field.Inline})
+	}
+	text := message.Message
+	for _, link := range message.Links {
+		text += fmt.Sprintf("\n<%s|%s>", link.Url, link.Title)
+	}
+	return postNotification(s.client, s.webHookUrl, h.Map{
+		"text": message.Title,
+		"attachments": []h.Map{{
+			"color":  severityColor(message.Severity),
+			"text":   text,
+			"fields": fields,
+		}},
+	}, nil, "slack")
+}
+
+// =================================================================================
+// MICROSOFT TEAMS
+// =================================================================================
+
+type teamsClient struct {
+	micro.NotificationService
+	webHookUrl string
+	client     *resty.Client
+}
+
+// NewTeamsClient posts the notifications to a Microsoft Teams incoming webhook (MessageCard).
+func NewTeamsClient(webhook string) micro.NotificationService {
+	return &teamsClient{webHookUrl: webhook, client: resty.New()}
+}
+
+func (s *teamsClient) Send(_ micro.Ctx, message micro.Notification) error {
+	facts := make([]h.Map, 0, len(message.Fields))
+	for _, field := range message.Fields {
+		facts = append(facts, h.Map{"name": field.Name, "value": field.Value})
+	}
+	actions := make([]h.Map, 0, len(message.Links))
+	for _, link := range message.Links {
+		actions = append(actions, h.Map{
+			"@type":   "OpenUri",
+			"name":    link.Title,
+			"targets": []h.Map{{"os": "default", "uri": link.Url}},
+		})
+	}
+	summary := message.Title
+	if summary == "" {
+		summary = message.Message
+	}
+	return postNotification(s.client, s.webHookUrl, h.Map{
+		"@type":           "MessageCard",
+		"@context":        "https://schema.org/extensions",
+		"summary":         summary,
+		"themeColor":      strings.TrimPrefix(severityColor(message.Severity), "#"),
+		"title":           message.Title,
+		"text":            message.Message,
+		"sections":        []h.Map{{"facts": facts}},
+		"potentialAction": actions,
+	}, nil, "teams")
+}
+
+// =================================================================================
+// GENERIC WEBHOOK
+// =================================================================================
+
+type WebhookNotifierConfig struct {
+	Url string
+	// BodyTemplate renders the JSON body from the micro.Notification (ex: {"text": {{json .Message}}}), the
+	// notification is posted as is when empty
+	BodyTemplate string
+	// Headers are added to each request (ex: Authorization)
+	Headers map[string]string
+}
+
+type webhookNotifier struct {
+	micro.NotificationService
+	cfg    WebhookNotifierConfig
+	body   *template.Template
+	client *resty.Client
+}
+
+// NewWebhookNotifier posts the notifications as JSON to any url.
+func NewWebhookNotifier(cfg WebhookNotifierConfig) (micro.NotificationService, error) {
+	n := &webhookNotifier{cfg: cfg, client: resty.New()}
+	if cfg.BodyTemplate != "" {
+		body, err := template.New("webhook").Funcs(template.FuncMap{
+			"json": func(v any) (string, error) {
+				out, err := json.Marshal(v)
+				return string(out), err
+			},
+		}).Parse(cfg.BodyTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook body template -- %v", err)
+		}
+		n.body = body
+	}
+	return n, nil
+}
+
+func (n *webhookNotifier) Send(_ micro.Ctx, message micro.Notification) error {
+	if n.body == nil {
+		return postNotification(n.client, n.cfg.Url, message, n.cfg.Headers, "webhook")
+	}
+	var body bytes.Buffer
+	if err := n.body.Execute(&body, message); err != nil {
+		return fmt.Errorf("failed to render webhook body -- %v", err)
+	}
+	headers := map[string]string{"Content-Type": "application/json"}
+	for k, v := range n.cfg.Headers {
+		headers[k] = v
+	}
+	return postNotification(n.client, n.cfg.Url, body.Bytes(), headers, "webhook")
+}
@@ -0,0 +1,153 @@
+package adapters
+
+import (
+	"encoding/json"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type capturedRequest struct {
+	header http.Header
+	body   map[string]any
+	form   map[string]string
+}
+
+// notificationStandIn records the requests received by the webhooks, per path
+func notificationStandIn(t *testing.T) (*httptest.Server, func(path string) []capturedRequest) {
+	var mu sync.Mutex
+	received := map[string][]capturedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		captured := capturedRequest{header: r.Header, form: map[string]string{}}
+		if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+			assert.Nil(t, r.ParseForm())
+			for k := range r.PostForm {
+				captured.form[k] = r.PostForm.Get(k)
+			}
+		} else {
+			data, _ := io.ReadAll(r.Body)
+			assert.Nil(t, json.Unmarshal(data, &captured.body))
+		}
+		mu.Lock()
+		received[r.URL.Path] = append(received[r.URL.Path], captured)
+		count := len(received[r.URL.Path])
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/limited":
+			// the first request is rate limited, the others have to wait for an hour
+			w.WriteHeader(http.StatusTooManyRequests)
+			retryAfter := 3600.0
+			if count == 1 {
+				retryAfter = 0.01
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"message": "You are being rate limited.", "retry_after": retryAfter})
+		}
+	}))
+	return server, func(path string) []capturedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return received[path]
+	}
+}
+
+func TestNotificationChannels(t *testing.T) {
+	server, received := notificationStandIn(t)
+	defer server.Close()
+	ctx := micro.NewCtx(micro.DefaultTenantId)
+	alert := micro.Notification{
+		Title:    "Payments failing",
+		Message:  "12 payments failed in the last 5 minutes",
+		Severity: micro.SeverityError,
+		Fields:   []micro.NotificationField{{Name: "Provider", Value: "stripe", Inline: true}},
+		Links:    []micro.NotificationLink{{Title: "Dashboard", Url: "https://acme.com/payments"}},
+	}
+
+	assert.Nil(t, NewSlackClient(server.URL+"/slack").Send(ctx, alert))
+	slack := received("/slack")[0].body
+	assert.Equal(t, "Payments failing", slack["text"])
+	attachment := slack["attachments"].([]any)[0].(map[string]any)
+	assert.Equal(t, "#f85149", attachment["color"])
+	assert.Equal(t, "12 payments failed in the last 5 minutes\n<https://acme.com/payments|Dashboard>", attachment["text"])
+
+	assert.Nil(t, NewTeamsClient(server.URL+"/teams").Send(ctx, alert))
+	teams := received("/teams")[0].body
+	assert.Equal(t, "MessageCard", teams["@type"])
+	assert.Equal(t, "f85149", teams["themeColor"])
+	assert.Equal(t, "Provider", teams["sections"].([]any)[0].(map[string]any)["facts"].([]any)[0].(map[string]any)["name"])
+
+	assert.Nil(t, NewDiscordClient(server.URL+"/discord").Send(ctx, micro.Notification{Message: "deployed"}))
+	assert.Equal(t, "deployed", received("/discord")[0].body["content"])
+	// a short retry_after is waited for, a long one is returned
+	err := NewDiscordClient(server.URL+"/limited").Send(ctx, micro.Notification{Message: "deployed"})
+	limited, ok := err.(*micro.RateLimitError)
+	assert.True(t, ok)
+	assert.Equal(t, time.Hour, limited.RetryAfter)
+	assert.Len(t, received("/limited"), 2)
+
+	_, err = NewWebhookNotifier(WebhookNotifierConfig{Url: server.URL, BodyTemplate: "{{.Unknown"})
+	assert.NotNil(t, err)
+	webhook, err := NewWebhookNotifier(WebhookNotifierConfig{
+		Url:          server.URL + "/webhook",
+		BodyTemplate: `{"summary": {{json .Title}}, "level": "{{.Severity}}"}`,
+		Headers:      map[string]string{"Authorization": "Bearer s3cr3t"},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, webhook.Send(ctx, alert))
+	hook := received("/webhook")[0]
+	assert.Equal(t, map[string]any{"summary": "Payments failing", "level": "error"}, hook.body)
+	assert.Equal(t, "Bearer s3cr3t", hook.header.Get("Authorization"))
+
+	sms, to, err := ParseTwilioUrl("twilio://AC123:token@+15550000000?to=+15551111111,+15552222222")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"+15551111111", "+15552222222"}, to)
+	sms.(*twilioSender).baseUrl = server.URL
+	_, _, err = ParseTwilioUrl("twilio://AC123@+15550000000")
+	assert.NotNil(t, err)
+	from, recipients, err := parseMailtoChannel("mailto:ops@acme.com, cto@acme.com?from=alerts@acme.com&from_name=Alerts")
+	assert.Nil(t, err)
+	assert.Equal(t, &micro.EmailAddress{Address: "alerts@acme.com", Name: "Alerts"}, from)
+	assert.Equal(t, []micro.EmailAddress{{Address: "ops@acme.com"}, {Address: "cto@acme.com"}}, recipients)
+	_, _, err = parseMailtoChannel("mailto:?from=alerts@acme.com")
+	assert.NotNil(t, err)
+
+	// warnings go to slack only, errors to slack, email and sms
+	mailer := NewFakeEmailSender()
+	router := micro.NewNotificationRouter(
+		micro.NotificationRule{Channel: "slack", MinSeverity: micro.SeverityWarning},
+		micro.NotificationRule{Channel: "email", Severities: []micro.Severity{micro.SeverityError, micro.SeverityCritical}},
+		micro.NotificationRule{Channel: "sms", MinSeverity: micro.SeverityError},
+		micro.NotificationRule{Channel: "down", MinSeverity: micro.SeverityCritical},
+	).
+		Channel("slack", NewSlackClient(server.URL+"/routed")).
+		Channel("email", micro.NewEmailNotifier(mailer, &micro.EmailAddress{Address: "alerts@acme.com"}, micro.EmailAddress{Address: "ops@acme.com"})).
+		Channel("sms", micro.NewSmsNotifier(sms, to...)).
+		Channel("down", NewSlackClient(server.URL+"/down"))
+
+	assert.Nil(t, router.Send(ctx, micro.Notification{Message: "cache warming"}))
+	assert.Nil(t, router.Send(ctx, micro.Notification{Message: "slow queries", Severity: micro.SeverityWarning}))
+	assert.Nil(t, router.Send(ctx, alert))
+	assert.Len(t, received("/routed"), 2)
+	assert.Len(t, mailer.Sent(), 1)
+	email, _ := mailer.LastTo("ops@acme.com")
+	assert.Equal(t, "[ERROR] Payments failing", email.Subject)
+	assert.Equal(t, "alerts@acme.com", email.From.Address)
+	assert.Contains(t, email.Body, "Provider: stripe\nDashboard: https://acme.com/payments")
+	texts := received("/2010-04-01/Accounts/AC123/Messages.json")
+	assert.Len(t, texts, 2)
+	assert.Equal(t, "+15552222222", texts[1].form["To"])
+	assert.Equal(t, "+15550000000", texts[1].form["From"])
+	assert.Equal(t, "Payments failing: 12 payments failed in the last 5 minutes", texts[1].form["Body"])
+
+	// a failing channel doesn't stop the others
+	alert.Severity = micro.SeverityCritical
+	assert.NotNil(t, router.Send(ctx, alert))
+	assert.Len(t, received("/down"), 1)
+	assert.Len(t, mailer.Sent(), 2)
+}
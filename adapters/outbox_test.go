@@ -0,0 +1,100 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	micro.Reset()
+	env := newTestEnv(t, &micro.OutboxEvent{})
+	defer env.Close()
+	outbox := micro.NewOutbox(micro.OutboxConfig{Backoff: time.Millisecond}, env.TenantLoader)
+	env.Outbox = outbox
+
+	var received []string
+	failures := 1
+	_ = micro.Subscribe("orders", func(ctx micro.Ctx, payload micro.Event) error {
+		if payload.Event == "order.updated" && failures > 0 {
+			failures--
+			return fmt.Errorf("boom")
+		}
+		received = append(received, payload.Event)
+		return nil
+	})
+
+	ctx := micro.NewCtx(micro.DefaultTenantId)
+	err := ctx.Tx(func(tx micro.Ctx) error {
+		micro.Publish(tx, "orders", micro.Event{Subject: "o1", Event: "order.cancelled"})
+		return fmt.Errorf("rollback")
+	})
+	assert.NotNil(t, err)
+
+	err = ctx.Tx(func(tx micro.Ctx) error {
+		micro.Publish(tx, "orders", micro.Event{Subject: "o1", Event: "order.created"})
+		micro.Publish(tx, "orders", micro.Event{Subject: "o1", Event: "order.updated"})
+		micro.Publish(tx, "orders", micro.Event{Subject: "o1", Event: "order.shipped"})
+		micro.Publish(tx, "orders", micro.Event{Subject: "o2", Event: "order.created"})
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Empty(t, received)
+
+	// the transaction fails when an event can't be stored
+	err = ctx.Tx(func(tx micro.Ctx) error {
+		micro.Publish(tx, "orders", micro.Event{Subject: "o3", Event: "order.created", Data: make(chan int)})
+		return nil
+	})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unable to store event orders in outbox")
+
+	delivered, err := outbox.Dispatch(micro.DefaultTenantId)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, delivered)
+	// order.shipped is held back until order.updated is delivered
+	assert.Equal(t, []string{"order.created", "order.created"}, received)
+
+	time.Sleep(5 * time.Millisecond)
+	delivered, err = outbox.Dispatch(micro.DefaultTenantId)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, delivered)
+	assert.Equal(t, []string{"order.created", "order.created", "order.updated", "order.shipped"}, received)
+
+	var events []*micro.OutboxEvent
+	assert.Nil(t, env.DB[micro.DefaultTenantId].Find(&events, micro.Query{W: "status = ?", Args: []any{micro.OutboxProcessed}}))
+	assert.Len(t, events, 4)
+}
+
+func TestOutboxStopTimeout(t *testing.T) {
+	micro.Reset()
+	env := newTestEnv(t, &micro.OutboxEvent{})
+	defer env.Close()
+	outbox := micro.NewOutbox(micro.OutboxConfig{Interval: time.Hour}, env.TenantLoader)
+	env.Outbox = outbox
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	_ = micro.Subscribe("orders", func(ctx micro.Ctx, payload micro.Event) error {
+		close(started)
+		<-release
+		return nil
+	})
+	outbox.Start()
+
+	ctx := micro.NewCtx(micro.DefaultTenantId)
+	assert.Nil(t, ctx.Tx(func(tx micro.Ctx) error {
+		micro.Publish(tx, "orders", micro.Event{Subject: "o1", Event: "order.created"})
+		return nil
+	}))
+	<-started
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.NotNil(t, outbox.Stop(stopCtx))
+	close(release)
+	assert.Nil(t, outbox.Stop(context.Background()))
+}
@@ -0,0 +1,86 @@
+package adapters
+
+import (
+	"context"
+	"github.com/fabriqs/go-micro/micro"
+	amqp "github.com/rabbitmq/amqp091-go"
+	log "github.com/sirupsen/logrus"
+	"sync"
+)
+
+const rabbitExchange = "micro.events"
+
+type rabbitTransport struct {
+	micro.EventTransport
+	conn   *amqp.Connection
+	ch     *amqp.Channel
+	mu     sync.Mutex
+	groups *micro.SubscriptionGroups
+}
+
+// NewRabbitTransport publishes events on a topic exchange (routing key = topic).
+// Each group gets a durable queue <group>.<topic> bound to the exchange.
+func NewRabbitTransport(url string, group string) (micro.EventTransport, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err == nil {
+		err = ch.ExchangeDeclare(rabbitExchange, amqp.ExchangeTopic, true, false, false, false, nil)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &rabbitTransport{conn: conn, ch: ch, groups: micro.NewSubscriptionGroups(group)}, nil
+}
+
+func (t *rabbitTransport) Publish(ctx micro.Ctx, topic string, payload micro.Event) error {
+	data, err := micro.EncodeEvent(ctx, topic, payload)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ch.PublishWithContext(context.Background(), rabbitExchange, topic, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Body:         data,
+	})
+}
+
+func (t *rabbitTransport) Subscribe(topic string, opts micro.SubscribeOptions, handle micro.SubscribeFunc) error {
+	group := t.groups.Group(topic, opts)
+	ch, err := t.conn.Channel()
+	if err != nil {
+		return err
+	}
+	queue, err := ch.QueueDeclare(group+"."+topic, true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	if err = ch.QueueBind(queue.Name, topic, rabbitExchange, false, nil); err != nil {
+		return err
+	}
+	deliveries, err := ch.Consume(queue.Name, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for d := range deliveries {
+			if err := micro.HandleMessage(d.Body, handle); err != nil {
+				log.Errorf("error handling event %s: %v", topic, err)
+			}
+			_ = d.Ack(false)
+		}
+	}()
+	return nil
+}
+
+func (t *rabbitTransport) WaitAsync() {
+}
+
+func (t *rabbitTransport) Close() error {
+	return t.conn.Close()
+}
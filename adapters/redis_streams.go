@@ -0,0 +1,95 @@
+package adapters
+
+import (
+	"context"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/util/ids"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+type redisStreamsTransport struct {
+	micro.EventTransport
+	client *redis.Client
+	groups *micro.SubscriptionGroups
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRedisStreamsTransport appends events to a redis stream per topic, groups are redis consumer groups.
+func NewRedisStreamsTransport(url string, group string) (micro.EventTransport, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &redisStreamsTransport{
+		client: redis.NewClient(options),
+		groups: micro.NewSubscriptionGroups(group),
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+func (t *redisStreamsTransport) Publish(ctx micro.Ctx, topic string, payload micro.Event) error {
+	data, err := micro.EncodeEvent(ctx, topic, payload)
+	if err != nil {
+		return err
+	}
+	return t.client.XAdd(t.ctx, &redis.XAddArgs{
+		Stream: topic,
+		Values: map[string]interface{}{"data": data},
+	}).Err()
+}
+
+func (t *redisStreamsTransport) Subscribe(topic string, opts micro.SubscribeOptions, handle micro.SubscribeFunc) error {
+	group := t.groups.Group(topic, opts)
+	err := t.client.XGroupCreateMkStream(t.ctx, topic, group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	consumer := ids.NewId(group)
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		for t.ctx.Err() == nil {
+			streams, err := t.client.XReadGroup(t.ctx, &redis.XReadGroupArgs{
+				Group:    group,
+				Consumer: consumer,
+				Streams:  []string{topic, ">"},
+				Count:    10,
+				Block:    time.Second,
+			}).Result()
+			if err != nil {
+				if err != redis.Nil && t.ctx.Err() == nil {
+					log.Errorf("unable to read redis stream %s: %v", topic, err)
+					time.Sleep(time.Second)
+				}
+				continue
+			}
+			for _, stream := range streams {
+				for _, msg := range stream.Messages {
+					data, _ := msg.Values["data"].(string)
+					if err = micro.HandleMessage([]byte(data), handle); err != nil {
+						log.Errorf("error handling event %s: %v", topic, err)
+					}
+					t.client.XAck(t.ctx, topic, group, msg.ID)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (t *redisStreamsTransport) WaitAsync() {
+}
+
+func (t *redisStreamsTransport) Close() error {
+	t.cancel()
+	t.wg.Wait()
+	return t.client.Close()
+}
@@ -1,14 +1,26 @@
 package adapters
 
 import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/util/errors"
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
 	log "github.com/sirupsen/logrus"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
 )
 
+// sendGridMaxPersonalizations is the maximum number of personalizations of a request
+const sendGridMaxPersonalizations = 1000
+
 type SendGridEmailSender struct {
 	micro.Mailer
 	apiKey string
@@ -28,52 +40,209 @@ func NewSendGridEmailSender(apikey string) micro.Mailer {
 	return SendGridEmailSender{apiKey: apikey}
 }
 
-func (s SendGridEmailSender) Send(message micro.Email) error {
+func (s SendGridEmailSender) Send(message micro.Email) (string, error) {
+	m, err := newSendGridMail(message)
+	if err != nil {
+		return "", err
+	}
+	m.AddPersonalizations(newSendGridPersonalization(message))
+	return s.post(m, message.Recipients())
+}
+
+// SendBatch sends the messages with the same template and sender in a single request (a personalization per
+// message), the other messages are sent one by one.
+func (s SendGridEmailSender) SendBatch(messages []micro.Email) ([]string, error) {
+	ids := make([]string, 0, len(messages))
+	for start := 0; start < len(messages); {
+		end := start + 1
+		if messages[start].TemplateId != "" {
+			for end < len(messages) && end-start < sendGridMaxPersonalizations && sameSendGridMail(messages[start], messages[end]) {
+				end++
+			}
+		}
+		m, err := newSendGridMail(messages[start])
+		if err != nil {
+			return ids, err
+		}
+		var recipients []micro.EmailAddress
+		for _, message := range messages[start:end] {
+			m.AddPersonalizations(newSendGridPersonalization(message))
+			recipients = append(recipients, message.Recipients()...)
+		}
+		id, err := s.post(m, recipients)
+		if err != nil {
+			log.Error(err)
+			return ids, err
+		}
+		for i := start; i < end; i++ {
+			ids = append(ids, id)
+		}
+		start = end
+	}
+	return ids, nil
+}
+
+// sameSendGridMail tells whether b can be a personalization of the mail of a: same template, sender and options
+func sameSendGridMail(a micro.Email, b micro.Email) bool {
+	return a.TemplateId == b.TemplateId && a.Subject == b.Subject && len(a.Attachments) == 0 && len(b.Attachments) == 0 &&
+		reflect.DeepEqual([]any{a.From, a.ReplyTo, a.Headers, a.Categories, a.SendAt}, []any{b.From, b.ReplyTo, b.Headers, b.Categories, b.SendAt})
+}
+
+func newSendGridMail(message micro.Email) (*mail.SGMailV3, error) {
+	if message.From == nil || message.From.Address == "" {
+		return nil, errors.Functional("missing_sender")
+	}
 	m := mail.NewV3Mail()
 	m.SetFrom(mail.NewEmail(message.From.Name, message.From.Address))
 	m.Subject = message.Subject
-	p := mail.NewPersonalization()
-	recipients := make([]string, 0)
-	for _, to := range message.To {
-		p.AddTos(mail.NewEmail(to.Name, to.Address))
-		recipients = append(recipients, to.Address)
+	if message.ReplyTo != nil {
+		m.SetReplyTo(mail.NewEmail(message.ReplyTo.Name, message.ReplyTo.Address))
 	}
 	if message.TemplateId != "" {
 		m.SetTemplateID(message.TemplateId)
-		for k, v := range message.TemplateData {
-			p.SetDynamicTemplateData(k, v)
+	} else {
+		if message.Body != "" {
+			m.AddContent(mail.NewContent("text/plain", message.Body))
+		}
+		if message.Html != "" {
+			m.AddContent(mail.NewContent("text/html", message.Html))
 		}
 	}
-	m.AddPersonalizations(p)
+	for _, attachment := range message.Attachments {
+		a := mail.NewAttachment()
+		a.SetFilename(attachment.Filename)
+		a.SetContent(base64.StdEncoding.EncodeToString(attachment.Content))
+		if attachment.ContentType != "" {
+			a.SetType(attachment.ContentType)
+		}
+		if attachment.ContentId != "" {
+			a.SetDisposition("inline")
+			a.SetContentID(attachment.ContentId)
+		} else {
+			a.SetDisposition("attachment")
+		}
+		m.AddAttachment(a)
+	}
+	for name, value := range message.Headers {
+		m.SetHeader(name, value)
+	}
+	m.AddCategories(message.Categories...)
+	if message.SendAt != nil {
+		m.SetSendAt(int(message.SendAt.Unix()))
+	}
+	return m, nil
+}
+
+func newSendGridPersonalization(message micro.Email) *mail.Personalization {
+	p := mail.NewPersonalization()
+	for _, to := range message.To {
+		p.AddTos(mail.NewEmail(to.Name, to.Address))
+	}
+	for _, cc := range message.Cc {
+		p.AddCCs(mail.NewEmail(cc.Name, cc.Address))
+	}
+	for _, bcc := range message.Bcc {
+		p.AddBCCs(mail.NewEmail(bcc.Name, bcc.Address))
+	}
+	for k, v := range message.TemplateData {
+		p.SetDynamicTemplateData(k, v)
+	}
+	return p
+}
 
+// post sends the mail and returns the X-Message-Id of the response
+func (s SendGridEmailSender) post(m *mail.SGMailV3, recipients []micro.EmailAddress) (string, error) {
 	request := sendgrid.GetRequest(s.apiKey, "/v3/mail/send", "https://api.sendgrid.com")
 	request.Method = "POST"
-	var Body = mail.GetRequestBody(m)
-	request.Body = Body
+	request.Body = mail.GetRequestBody(m)
 	res, err := sendgrid.API(request)
 	if err != nil {
 		log.Errorf(err.Error())
-		return err
-	} else if res.StatusCode >= 300 {
+		return "", err
+	}
+	if res.StatusCode >= 300 {
 		var resultErr sengridErrorResponse
 		_ = json.Unmarshal([]byte(res.Body), &resultErr)
-		return fmt.Errorf("%s", resultErr.Errors[0].Message)
-	} else {
-		log.Infof("Email sent to %v", recipients)
-		/*model := ProjectInvitation{Id: invite.Id}
-		  if err = ctx.Env.DB.UpdateColumn(&model, "status", StatusSent); err != nil {
-		    log.Error(err)
-		  }*/
+		if len(resultErr.Errors) == 0 {
+			return "", fmt.Errorf("sendgrid request failed with status %d", res.StatusCode)
+		}
+		return "", fmt.Errorf("%s", resultErr.Errors[0].Message)
+	}
+	log.Infof("Email sent to %d recipient(s)", len(recipients))
+	var id string
+	if values := res.Headers["X-Message-Id"]; len(values) > 0 {
+		id = values[0]
 	}
-	return nil
+	return id, nil
 }
 
-func (s SendGridEmailSender) SendBatch(message []micro.Email) error {
-	for _, msg := range message {
-		if err := s.Send(msg); err != nil {
-			log.Error(err)
-			return err
+type sendGridEvent struct {
+	Event       string `json:"event"`
+	SgMessageId string `json:"sg_message_id"`
+	Timestamp   int64  `json:"timestamp"`
+	Reason      string `json:"reason"`
+}
+
+var sendGridEvents = map[string]string{
+	"delivered": micro.EmailEventDelivered,
+	"open":      micro.EmailEventOpened,
+	"bounce":    micro.EmailEventBounced,
+	"dropped":   micro.EmailEventDropped,
+}
+
+// ParseSendGridEvents reads the requests of the SendGrid event webhook, the other events are ignored.
+func ParseSendGridEvents(body []byte, _ http.Header) ([]micro.EmailEvent, error) {
+	var received []sendGridEvent
+	if err := json.Unmarshal(body, &received); err != nil {
+		return nil, err
+	}
+	events := make([]micro.EmailEvent, 0, len(received))
+	for _, e := range received {
+		event, ok := sendGridEvents[e.Event]
+		if !ok {
+			continue
 		}
+		// sg_message_id is the X-Message-Id of the request followed by the id of the recipient
+		providerId, _, _ := strings.Cut(e.SgMessageId, ".")
+		events = append(events, micro.EmailEvent{
+			ProviderId: providerId,
+			Event:      event,
+			Timestamp:  time.Unix(e.Timestamp, 0).UTC(),
+			Reason:     e.Reason,
+		})
+	}
+	return events, nil
+}
+
+const (
+	sendGridSignatureHeader = "X-Twilio-Email-Event-Webhook-Signature"
+	sendGridTimestampHeader = "X-Twilio-Email-Event-Webhook-Timestamp"
+)
+
+// NewSendGridWebhookVerifier checks the signature of the SendGrid signed event webhook, publicKey is the
+// verification key shown by SendGrid (base64).
+func NewSendGridWebhookVerifier(publicKey string) (micro.EmailWebhookVerifier, error) {
+	der, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sendgrid webhook key: %w", err)
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sendgrid webhook key: %w", err)
 	}
-	return nil
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid sendgrid webhook key: not an ecdsa key")
+	}
+	return func(body []byte, header http.Header) error {
+		signature, err := base64.StdEncoding.DecodeString(header.Get(sendGridSignatureHeader))
+		if err != nil || len(signature) == 0 {
+			return errors.Unauthorized("invalid_webhook_signature")
+		}
+		hash := sha256.Sum256(append([]byte(header.Get(sendGridTimestampHeader)), body...))
+		if !ecdsa.VerifyASN1(ecKey, hash[:], signature) {
+			return errors.Unauthorized("invalid_webhook_signature")
+		}
+		return nil
+	}, nil
 }
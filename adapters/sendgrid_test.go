@@ -11,7 +11,7 @@ import (
 
 func Test(t *testing.T) {
 	sender := NewSendGridEmailSender("foo")
-	err := sender.Send(micro.Email{
+	_, err := sender.Send(micro.Email{
 		From: &micro.EmailAddress{
 			Name:    gofakeit.Name(),
 			Address: gofakeit.Email(),
@@ -32,3 +32,10 @@ func Test(t *testing.T) {
 	assert.True(t, strings.Contains(err.Error(), "The provided authorization grant is invalid"))
 	//assert.Nil(t, err)
 }
+
+func TestSendGridMissingSender(t *testing.T) {
+	sender := NewSendGridEmailSender("foo")
+	_, err := sender.Send(micro.Email{To: []micro.EmailAddress{{Address: gofakeit.Email()}}, Subject: "Hello world"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "missing_sender")
+}
@@ -0,0 +1,70 @@
+package adapters
+
+import (
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/middleware"
+	"github.com/fabriqs/go-micro/tests"
+	"github.com/fabriqs/go-micro/util/h"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSessionAuthentication(t *testing.T) {
+	env := newTestEnv(t, &micro.Session{})
+	// the test server is plain HTTP
+	sessions := micro.NewSessionManager(micro.SessionConfig{
+		Secret:   "secret",
+		Insecure: true,
+		Store:    micro.NewDbSessionStore(env.DB[micro.DefaultTenantId]),
+	})
+	router := NewEchoAdapter(micro.RouterConfig{Sessions: sessions})
+	router.POST("/login", func(ctx micro.Ctx) (any, error) {
+		err := ctx.Login(&micro.Authentication{UserId: "u1", Roles: []string{"admin"}})
+		return h.Map{"csrf": ctx.CsrfToken()}, err
+	})
+	router.POST("/logout", func(ctx micro.Ctx) (any, error) {
+		return nil, ctx.Logout()
+	}, middleware.Authenticated())
+	router.GET("/me", func(ctx micro.Ctx) (any, error) {
+		return h.Map{"id": ctx.Auth.UserId, "scheme": ctx.Auth.Scheme}, nil
+	}, middleware.AuthenticatedWithRole("admin"))
+
+	server := tests.HttpTest(t, router.Handler(), env.Close)
+	defer server.Teardown()
+
+	server.GET("/me").Expect().IsUnauthorized()
+
+	// the http client keeps the cookies it receives
+	login := server.POST("/login").Expect().IsOK()
+	sid := login.Cookie("sid").NotEmpty().Raw()
+	csrf := login.JSON().Path("$.csrf").String().NotEmpty().Raw()
+
+	me := server.GET("/me").Expect().IsOK().JSON().Object()
+	me.Path("$.id").String().IsEqual("u1")
+	me.Path("$.scheme").String().IsEqual(micro.AuthSchemeSession)
+
+	server.POST("/logout").Expect().IsForbidden()
+	server.POST("/logout").Header(micro.HeaderCsrfToken, csrf).Expect().IsOK()
+	server.GET("/me").Expect().IsUnauthorized()
+	server.GET("/me").Header("Cookie", "sid="+sid).Expect().IsUnauthorized()
+}
+
+func TestSessionCookieSecureByDefault(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.Close()
+	sessions := micro.NewSessionManager(micro.SessionConfig{Secret: "secret"})
+	router := NewEchoAdapter(micro.RouterConfig{Sessions: sessions})
+	router.POST("/login", func(ctx micro.Ctx) (any, error) {
+		return nil, ctx.Login(&micro.Authentication{UserId: "u1"})
+	})
+
+	rec := httptest.NewRecorder()
+	router.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	cookies := rec.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.True(t, cookies[0].Secure)
+	}
+}
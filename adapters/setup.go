@@ -3,12 +3,15 @@ package adapters
 import (
 	"fmt"
 	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/middleware"
 	"github.com/fabriqs/go-micro/util/h"
 	"github.com/joho/godotenv"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 	"github.com/pelletier/go-toml/v2"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/text/language"
+	"net/http"
+	"net/url"
 	"os"
 	"strings"
 )
@@ -28,17 +31,29 @@ func NewApp(name string, version string, cfg micro.Cfg) *micro.App {
 	setupLocales(env, cfg)
 	prepareMultiTenancy(env, cfg)
 	setupDatabase(env, cfg)
-	setupScheduler(env)
-	setupMailer(env)
-	setupNotifications(env)
+	setupLocker(env, cfg)
+	setupScheduler(env, cfg)
+	setupEventTransport(env, name)
+	setupOutbox(env, cfg)
+	setupDeadLetters(env, cfg)
+	setupJobs(env, cfg)
+	setupMailer(env, cfg)
+	setupEmailOutbox(env, cfg)
+	setupNotifications(env, cfg)
+	setupInbox(env, cfg)
 	setupTokenProvider(env)
+	setupApiAuth(env, cfg)
+	setupAuthorization(env, cfg)
+	setupSessions(env, cfg)
 	setupRouter(env, cfg)
 
 	// configure locales if any
 	return &micro.App{
-		Name:    name,
-		Version: version,
-		Env:     env,
+		Name:             name,
+		Version:          version,
+		Env:              env,
+		ShutdownGrace:    cfg.ShutdownGrace,
+		DisabledFeatures: cfg.DisabledFeatures,
 	}
 
 }
@@ -62,6 +77,7 @@ func setupLocales(env *micro.Env, cfg micro.Cfg) {
 	localizer := i18n.NewLocalizer(bundle, locales...)
 	log.Infof("%d locales loaded", len(cfg.Locales))
 	env.Localizer = localizer
+	env.I18n = bundle
 
 }
 
@@ -110,11 +126,105 @@ func setupDatabase(env *micro.Env, cfg micro.Cfg) {
 	env.DB = links
 }
 
-func setupScheduler(env *micro.Env) {
-	env.Scheduler = NewGoCronAdapter(env.TenantLoader)
+func setupLocker(env *micro.Env, cfg micro.Cfg) {
+	if !cfg.DistributedLocks {
+		return
+	}
+	db, ok := env.DB[micro.DefaultTenantId]
+	if !ok {
+		log.Fatal("distributed locks require a default DataSource")
+	}
+	if err := db.AutoMigrate(&micro.DistributedLock{}); err != nil {
+		log.Fatalf("unable to create locks table: %v", err)
+	}
+	env.Locker = micro.NewDbLocker(db, "")
+}
+
+func setupScheduler(env *micro.Env, cfg micro.Cfg) {
+	scheduler := NewGoCronAdapter(env.TenantLoader).(*GoCronSchedulingAdapter)
+	scheduler.locker = env.Locker
+	env.Scheduler = scheduler
+	if !cfg.JobHistory {
+		return
+	}
+	db, ok := env.DB[micro.DefaultTenantId]
+	if !ok {
+		log.Fatal("job history requires a default DataSource")
+	}
+	if err := db.AutoMigrate(&micro.JobRun{}); err != nil {
+		log.Fatalf("unable to create job runs table: %v", err)
+	}
+	scheduler.UseHistory(micro.NewDbJobHistory(db))
+}
+
+func setupEventTransport(env *micro.Env, group string) {
+	config := h.GetEnv(micro.EventTransportUrl)
+	if config == "" || config == "memory" {
+		return
+	}
+	var transport micro.EventTransport
+	var err error
+	scheme := strings.Split(config, "://")[0]
+	switch scheme {
+	case "nats":
+		transport, err = NewNatsTransport(config, group)
+	case "kafka":
+		transport = NewKafkaTransport(strings.Split(strings.TrimPrefix(config, "kafka://"), ","), group)
+	case "redis", "rediss":
+		transport, err = NewRedisStreamsTransport(config, group)
+	case "amqp", "amqps":
+		transport, err = NewRabbitTransport(config, group)
+	case "http", "https":
+		transport = NewHttpPushTransport(HttpPushConfig{
+			Endpoints: map[string][]string{"*": {config}},
+			Source:    group,
+		})
+	default:
+		log.Fatalf("event transport not supported: %s", scheme)
+	}
+	if err != nil {
+		log.Fatalf("unable to connect event transport %s: %v", scheme, err)
+	}
+	env.Events = transport
+}
+
+func setupOutbox(env *micro.Env, cfg micro.Cfg) {
+	if cfg.Outbox == nil {
+		return
+	}
+	for tenant, db := range env.DB {
+		if err := db.AutoMigrate(&micro.OutboxEvent{}); err != nil {
+			log.Fatalf("unable to create outbox table for tenant %s: %v", tenant, err)
+		}
+	}
+	env.Outbox = micro.NewOutbox(*cfg.Outbox, env.TenantLoader)
+}
+
+func setupJobs(env *micro.Env, cfg micro.Cfg) {
+	if cfg.Jobs == nil {
+		return
+	}
+	for tenant, db := range env.DB {
+		if err := db.AutoMigrate(&micro.QueuedJob{}); err != nil {
+			log.Fatalf("unable to create jobs table for tenant %s: %v", tenant, err)
+		}
+	}
+	env.Jobs = micro.NewJobs(*cfg.Jobs, env.TenantLoader)
+}
+
+func setupDeadLetters(env *micro.Env, cfg micro.Cfg) {
+	if !cfg.DeadLetters {
+		return
+	}
+	for tenant, db := range env.DB {
+		if err := db.AutoMigrate(&micro.DeadLetter{}); err != nil {
+			log.Fatalf("unable to create dead letters table for tenant %s: %v", tenant, err)
+		}
+	}
+	env.DeadLetters = true
 }
 
-func setupMailer(env *micro.Env) {
+func setupMailer(env *micro.Env, cfg micro.Cfg) {
 	config := h.GetEnv(micro.EmailSender, "MAILER")
 	if config == "" {
 		log.Infof("env.%s is empty, skipping mailer setup", micro.EmailSender)
@@ -126,32 +236,161 @@ func setupMailer(env *micro.Env) {
 		mailer = NewSendGridEmailSender(mailerConfig[1])
 	} else if mailerConfig[0] == "fake" {
 		mailer = NewFakeEmailSender()
+	} else if mailerConfig[0] == "smtp" || mailerConfig[0] == "smtps" {
+		smtpConfig, err := ParseSmtpUrl(config)
+		if err != nil {
+			log.Fatalf("invalid smtp mailer config: %v", err)
+		}
+		mailer = NewSmtpMailer(smtpConfig)
 	} else {
 		log.Fatalf("mailer provider not supported: %s", mailerConfig)
 	}
+	if exists, templatesFS := h.CheckFsFolder(cfg.FS, micro.EmailTemplatesDir); exists {
+		env.EmailTemplates = micro.NewEmailTemplates(templatesFS, env.I18n, cfg.DefaultLocale)
+		mailer = micro.NewTemplateMailer(mailer, env.EmailTemplates)
+	}
 	env.Mailer = mailer
 }
 
-func setupNotifications(env *micro.Env) {
+// fakeInbox returns the fake mailer behind the templates, if any
+func fakeInbox(mailer micro.Mailer) (*FakeEmailSender, bool) {
+	if wrapper, ok := mailer.(interface{ Unwrap() micro.Mailer }); ok {
+		mailer = wrapper.Unwrap()
+	}
+	inbox, ok := mailer.(*FakeEmailSender)
+	return inbox, ok
+}
+
+func setupEmailOutbox(env *micro.Env, cfg micro.Cfg) {
+	if cfg.EmailOutbox == nil {
+		return
+	}
+	if env.Jobs == nil || env.Mailer == nil {
+		log.Fatal("the email outbox requires Jobs and a mailer")
+	}
+	for tenant, db := range env.DB {
+		if err := db.AutoMigrate(&micro.EmailMessage{}); err != nil {
+			log.Fatalf("unable to create emails table for tenant %s: %v", tenant, err)
+		}
+	}
+	env.Emails = micro.NewEmailOutbox(env.Mailer, env.Jobs)
+}
+
+// setupNotifications reads the channels of NOTIFICATION_SENDER, separated by ";" (ex:
+// https://hooks.slack.com/services/...;mailto:ops@acme.com,cto@acme.com?from=alerts@acme.com;twilio://SID:TOKEN@+15550000000?to=+15551111111)
+func setupNotifications(env *micro.Env, cfg micro.Cfg) {
 
 	config := h.GetEnv(micro.NotificationSender, "NOTIFIER")
 	if config == "" {
 		log.Info("env.NOTIFICATION_SENDER is empty, skipping notifications setup")
 		return
 	}
+	notifications := cfg.Notifications
+	if notifications == nil {
+		notifications = &micro.NotificationConfig{}
+	}
+
+	router := micro.NewNotificationRouter(notifications.Rules...)
+	for _, channel := range strings.Split(config, ";") {
+		channel = strings.TrimSpace(channel)
+		if channel == "" {
+			continue
+		}
+		name, service := newNotificationChannel(env, notifications, channel)
+		if notifications.Throttle != nil {
+			service = micro.NewThrottledNotifier(service, *notifications.Throttle)
+		}
+		if h.Contains(router.Channels(), name) {
+			name = fmt.Sprintf("%s-%d", name, len(router.Channels())+1)
+		}
+		router.Channel(name, service)
+	}
+	log.Infof("notification channels: %s", strings.Join(router.Channels(), ", "))
 
-	var service micro.NotificationService
+	env.Notifier = router
 
-	if strings.Contains(config, "discord.com") {
-		service = NewDiscordClient(config)
-	} else if strings.Contains(config, "noop") {
-		service = micro.NewNoopNotificationService()
-	} else {
-		log.Fatalf("notifications manager provider not supported: %s", config)
+}
+
+// newNotificationChannel returns the name (kind) and the service of a channel of NOTIFICATION_SENDER
+func newNotificationChannel(env *micro.Env, cfg *micro.NotificationConfig, channel string) (string, micro.NotificationService) {
+	switch {
+	case strings.Contains(channel, "discord.com"):
+		return "discord", NewDiscordClient(channel)
+	case strings.Contains(channel, "hooks.slack.com"):
+		return "slack", NewSlackClient(channel)
+	case strings.Contains(channel, "webhook.office.com") || strings.Contains(channel, "logic.azure.com"):
+		return "teams", NewTeamsClient(channel)
+	case strings.Contains(channel, "noop"):
+		return "noop", micro.NewNoopNotificationService()
+	case strings.HasPrefix(channel, "mailto:"):
+		if env.Mailer == nil {
+			log.Fatalf("email notifications require a mailer (%s)", micro.EmailSender)
+		}
+		from, to, err := parseMailtoChannel(channel)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return "email", micro.NewEmailNotifier(env.Mailer, from, to...)
+	case strings.HasPrefix(channel, "twilio://"):
+		sender, to, err := ParseTwilioUrl(channel)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return "sms", micro.NewSmsNotifier(sender, to...)
+	case strings.HasPrefix(channel, "http://") || strings.HasPrefix(channel, "https://"):
+		service, err := NewWebhookNotifier(WebhookNotifierConfig{
+			Url:          channel,
+			BodyTemplate: cfg.WebhookTemplate,
+			Headers:      cfg.WebhookHeaders,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		return "webhook", service
 	}
+	log.Fatalf("notifications manager provider not supported: %s", channel)
+	return "", nil
+}
 
-	env.Notifier = service
+// parseMailtoChannel reads mailto:ops@acme.com,cto@acme.com?from=alerts@acme.com&from_name=Alerts, the sender is
+// optional with a mailer having a default one (SMTP_URL ?from=)
+func parseMailtoChannel(channel string) (*micro.EmailAddress, []micro.EmailAddress, error) {
+	addresses, rawQuery, _ := strings.Cut(strings.TrimPrefix(channel, "mailto:"), "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid mailto channel %s: %w", channel, err)
+	}
+	var to []micro.EmailAddress
+	for _, address := range strings.Split(addresses, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			to = append(to, micro.EmailAddress{Address: address})
+		}
+	}
+	if len(to) == 0 {
+		return nil, nil, fmt.Errorf("invalid mailto channel %s: no recipient", channel)
+	}
+	var from *micro.EmailAddress
+	if address := query.Get("from"); address != "" {
+		from = &micro.EmailAddress{Address: address, Name: query.Get("from_name")}
+	}
+	return from, to, nil
+}
 
+func setupInbox(env *micro.Env, cfg micro.Cfg) {
+	if cfg.Inbox == nil {
+		return
+	}
+	for tenant, db := range env.DB {
+		if err := db.AutoMigrate(&micro.UserNotification{}, &micro.NotificationPreferences{}); err != nil {
+			log.Fatalf("unable to create notifications tables for tenant %s: %v", tenant, err)
+		}
+	}
+	inboxConfig := *cfg.Inbox
+	if inboxConfig.Emails == nil && inboxConfig.Mailer == nil {
+		inboxConfig.Emails = env.Emails
+		inboxConfig.Mailer = env.Mailer
+	}
+	env.Inbox = micro.NewInbox(inboxConfig)
 }
 
 func setupTokenProvider(env *micro.Env) {
@@ -163,7 +402,64 @@ func setupTokenProvider(env *micro.Env) {
 	env.TokenProvider = micro.NewTokenProvider(secret)
 }
 
+func setupApiAuth(env *micro.Env, cfg micro.Cfg) {
+	if cfg.ApiKeys {
+		for tenant, db := range env.DB {
+			if err := db.AutoMigrate(&micro.ApiKey{}); err != nil {
+				log.Fatalf("unable to create api keys table for tenant %s: %v", tenant, err)
+			}
+		}
+		env.ApiKeys = micro.NewApiKeyService()
+	}
+	if len(cfg.HmacCredentials) > 0 {
+		env.HmacVerifier = micro.NewHmacVerifier(micro.NewFixedHmacCredentialStore(cfg.HmacCredentials...), 0)
+		// the replays are only detected by the instance which saw the signature without distributed locks
+		if env.Locker != nil {
+			env.HmacVerifier.UseLocker(env.Locker)
+		}
+	}
+}
+
+func setupAuthorization(env *micro.Env, cfg micro.Cfg) {
+	if _, ok := cfg.RolePermissions.(*micro.DbRolePermissions); ok {
+		for tenant, db := range env.DB {
+			if err := db.AutoMigrate(&micro.RolePermission{}); err != nil {
+				log.Fatalf("unable to create role permissions table for tenant %s: %v", tenant, err)
+			}
+		}
+	}
+	env.Authorizer = micro.NewAuthorizer(cfg.RolePermissions, cfg.Policies...)
+}
+
+func setupSessions(env *micro.Env, cfg micro.Cfg) {
+	if cfg.Sessions == nil {
+		return
+	}
+	sessionCfg := *cfg.Sessions
+	if sessionCfg.Secret == "" {
+		sessionCfg.Secret = h.RequireEnv(micro.ServerToken)
+	}
+	if sessionCfg.Store == nil {
+		if db, ok := env.DB[micro.DefaultTenantId]; ok {
+			if err := db.AutoMigrate(&micro.Session{}); err != nil {
+				log.Fatalf("unable to create sessions table: %v", err)
+			}
+			sessionCfg.Store = micro.NewDbSessionStore(db)
+		}
+	}
+	env.Sessions = micro.NewSessionManager(sessionCfg)
+}
+
 func setupRouter(env *micro.Env, cfg micro.Cfg) {
+	tenancy := cfg.Tenancy
+	if tenancy == nil {
+		tenancy = micro.NewTenantResolution(env.TenantLoader)
+	} else if tenancy.Loader == nil {
+		tenancy.Loader = env.TenantLoader
+	}
+	if err := tenancy.Validate(); err != nil {
+		log.Fatal(err)
+	}
 	router := NewEchoAdapter(
 		micro.RouterConfig{
 			Cors:             true,
@@ -172,7 +468,81 @@ func setupRouter(env *micro.Env, cfg micro.Cfg) {
 			BodyLimit:        "2M",
 			Swagger:          true,
 			TokenProvider:    env.TokenProvider,
+			ApiKeys:          env.ApiKeys,
+			HmacVerifier:     env.HmacVerifier,
+			Tenancy:          tenancy,
+			Sessions:         env.Sessions,
 			MultiTenant:      cfg.MultiTenant,
+			Health:           env.Health,
 		})
 	env.Router = router
+	if env.Emails != nil && cfg.EmailOutbox.WebhookPath != "" {
+		parser := micro.ParseEmailEvents
+		if strings.HasPrefix(h.GetEnv(micro.EmailSender, "MAILER"), "sendgrid://") {
+			parser = ParseSendGridEvents
+		}
+		verify, err := emailWebhookVerifier(cfg.EmailOutbox)
+		if err != nil {
+			log.Fatal(err)
+		}
+		micro.RegisterEmailWebhookRoute(router, cfg.EmailOutbox.WebhookPath, env.Emails, parser, verify)
+	}
+	if env.Inbox != nil {
+		micro.RegisterInboxRoutes(router, env.Inbox, middleware.Authenticated())
+	}
+	if !env.Production && cfg.DevInbox != "" {
+		if inbox, ok := fakeInbox(env.Mailer); ok {
+			RegisterDevInboxRoutes(router.Group(cfg.DevInbox), inbox)
+		}
+	}
+	setupAdminRoutes(env, cfg)
+}
+
+// emailWebhookVerifier checks the SendGrid signature and/or the shared secret, the webhook is never left open
+func emailWebhookVerifier(cfg *micro.EmailOutboxConfig) (micro.EmailWebhookVerifier, error) {
+	var verifiers []micro.EmailWebhookVerifier
+	if cfg.WebhookPublicKey != "" {
+		verify, err := NewSendGridWebhookVerifier(cfg.WebhookPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		verifiers = append(verifiers, verify)
+	}
+	if cfg.WebhookSecret != "" {
+		verifiers = append(verifiers, micro.VerifyEmailWebhookSecret(cfg.WebhookSecret))
+	}
+	if len(verifiers) == 0 {
+		return nil, fmt.Errorf("the email webhook %s requires WebhookSecret or WebhookPublicKey", cfg.WebhookPath)
+	}
+	return func(body []byte, header http.Header) error {
+		for _, verify := range verifiers {
+			if err := verify(body, header); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+func setupAdminRoutes(env *micro.Env, cfg micro.Cfg) {
+	if cfg.Admin == nil {
+		return
+	}
+	role := cfg.Admin.Role
+	if role == "" {
+		role = "admin"
+	}
+	admin := env.Router.Group(cfg.Admin.Path, middleware.AuthenticatedWithRole(role))
+	if env.Scheduler != nil {
+		micro.RegisterSchedulerRoutes(admin, env.Scheduler)
+	}
+	if env.Jobs != nil {
+		micro.RegisterJobRoutes(admin, env.Jobs)
+	}
+	if env.DeadLetters {
+		micro.RegisterDeadLetterRoutes(admin)
+	}
+	if env.Emails != nil {
+		micro.RegisterEmailRoutes(admin, env.Emails)
+	}
 }
@@ -0,0 +1,77 @@
+package adapters
+
+import (
+	"fmt"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/tests"
+	"github.com/fabriqs/go-micro/util/errors"
+	"github.com/fabriqs/go-micro/util/h"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTenantResolution(t *testing.T) {
+	tenants := []string{micro.DefaultTenantId, "acme"}
+	env := &micro.Env{DB: map[string]micro.DataSource{}, TenantLoader: micro.NewFixedTenantLoader(tenants)}
+	for _, tenant := range tenants {
+		env.DB[tenant] = NewGormAdapter(fmt.Sprintf("file:%s_%s?mode=memory&cache=shared", t.Name(), tenant), "")
+	}
+	_, err := (&micro.App{Env: env}).Init(nil)
+	assert.Nil(t, err)
+
+	provider := micro.NewTokenProvider("secret")
+	router := NewEchoAdapter(micro.RouterConfig{
+		MultiTenant:   true,
+		TokenProvider: provider,
+		Tenancy: &micro.TenantResolution{
+			Strategies: []micro.TenantStrategy{micro.TenantFromPath, micro.TenantFromHeader, micro.TenantFromClaim},
+			PathPrefix: "/t",
+			Loader:     env.TenantLoader,
+		},
+	})
+	router.GET("/whoami", func(ctx micro.Ctx) (any, error) {
+		return h.Map{"tenant": ctx.TenantId}, nil
+	})
+
+	server := tests.HttpTest(t, router.Handler(), env.Close)
+	defer server.Teardown()
+
+	server.GET("/whoami").Expect().IsOK().JSON().Path("$.tenant").String().IsEqual(micro.DefaultTenantId)
+	server.GET("/t/acme/whoami").Expect().IsOK().JSON().Path("$.tenant").String().IsEqual("acme")
+	server.GET("/t/ghost/whoami").Expect().Status(404)
+	server.GET("/whoami").Header("X-TenantId", "acme").Expect().IsOK().JSON().Path("$.tenant").String().IsEqual("acme")
+	server.GET("/whoami").Header("X-TenantId", "ghost").Expect().Status(404)
+
+	acmeToken, _ := provider.CreateJwt("u1", "acme", "", nil, nil)
+	ghostToken, _ := provider.CreateJwt("u1", "ghost", "", nil, nil)
+
+	server.GET("/whoami").BearerAuth(acmeToken).Expect().IsOK().JSON().Path("$.tenant").String().IsEqual("acme")
+	server.GET("/t/acme/whoami").BearerAuth(acmeToken).Expect().IsOK()
+	server.GET("/whoami").BearerAuth(acmeToken).Header("X-TenantId", micro.DefaultTenantId).Expect().IsForbidden()
+	server.GET("/whoami").BearerAuth(ghostToken).Expect().IsForbidden()
+
+	assert.NotNil(t, micro.NewTenantResolution(nil, micro.TenantFromPath).Validate())
+	assert.NotNil(t, (&micro.TenantResolution{Strategies: []micro.TenantStrategy{micro.TenantFromPath}, PathPrefix: "/"}).Validate())
+	assert.Nil(t, (&micro.TenantResolution{Strategies: []micro.TenantStrategy{micro.TenantFromPath}, PathPrefix: "/t"}).Validate())
+}
+
+func TestTenantResolutionWithoutLoader(t *testing.T) {
+	env := newTestEnv(t)
+	router := NewEchoAdapter(micro.RouterConfig{MultiTenant: true})
+	api := router.Group("/api")
+	api.GET("/whoami", func(ctx micro.Ctx) (any, error) {
+		return h.Map{"tenant": ctx.TenantId}, nil
+	})
+	api.GET("/forbidden", func(ctx micro.Ctx) (any, error) {
+		return nil, errors.Forbidden("not_allowed", "not allowed")
+	})
+
+	server := tests.HttpTest(t, router.Handler(), env.Close)
+	defer server.Teardown()
+
+	// the tenants are checked against Env.TenantLoader
+	server.GET("/api/whoami").Expect().IsOK().JSON().Path("$.tenant").String().IsEqual(micro.DefaultTenantId)
+	server.GET("/api/whoami").Header("X-TenantId", "ghost").Expect().Status(404)
+	// the errors of the group routes are mapped like the ones of the router
+	server.GET("/api/forbidden").Expect().IsForbidden()
+}
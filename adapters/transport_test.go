@@ -0,0 +1,96 @@
+package adapters
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/fabriqs/go-micro/micro"
+	"github.com/fabriqs/go-micro/util/h"
+	"github.com/fabriqs/go-micro/util/ids"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTransport checks the contract shared by the broker transports: serialization of the tenant, the
+// authentication and the data, and consumer groups (one delivery per group, every subscriber without a group gets
+// the event).
+func testTransport(t *testing.T, transport micro.EventTransport) {
+	defer transport.Close()
+	topic := ids.NewId("topic")
+
+	var mu sync.Mutex
+	received := map[string][]micro.Event{}
+	var tenants []string
+	subscriber := func(name string) micro.SubscribeFunc {
+		return func(ctx micro.Ctx, payload micro.Event) error {
+			mu.Lock()
+			defer mu.Unlock()
+			received[name] = append(received[name], payload)
+			tenants = append(tenants, ctx.TenantId+"/"+ctx.Auth.UserId)
+			return nil
+		}
+	}
+	assert.Nil(t, transport.Subscribe(topic, micro.SubscribeOptions{Group: "billing"}, subscriber("billing-1")))
+	assert.Nil(t, transport.Subscribe(topic, micro.SubscribeOptions{Group: "billing"}, subscriber("billing-2")))
+	assert.Nil(t, transport.Subscribe(topic, micro.SubscribeOptions{Group: "audit"}, subscriber("audit")))
+	assert.Nil(t, transport.Subscribe(topic, micro.SubscribeOptions{}, subscriber("search")))
+	assert.Nil(t, transport.Subscribe(topic, micro.SubscribeOptions{}, subscriber("cache")))
+
+	ctx := micro.NewAuthCtx(&micro.Authentication{Authenticated: true, UserId: "u1", TenantId: "acme"})
+	assert.Nil(t, transport.Publish(ctx, topic, micro.Event{Subject: "o1", Event: "order.created", Data: h.Map{"total": 10}}))
+	transport.WaitAsync()
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received["audit"]) == 1 && len(received["billing-1"])+len(received["billing-2"]) == 1 &&
+			len(received["search"]) == 1 && len(received["cache"]) == 1
+	}, 10*time.Second, 20*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	evt := received["audit"][0]
+	assert.Equal(t, "o1", evt.Subject)
+	assert.Equal(t, "order.created", evt.Event)
+	assert.Equal(t, float64(10), evt.Data.(map[string]interface{})["total"])
+	assert.Equal(t, []string{"acme/u1", "acme/u1", "acme/u1", "acme/u1"}, tenants)
+}
+
+func TestNatsTransport(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	server := natsserver.RunServer(&opts)
+	defer server.Shutdown()
+
+	transport, err := NewNatsTransport(server.ClientURL(), "test")
+	assert.Nil(t, err)
+	testTransport(t, transport)
+}
+
+func TestRedisStreamsTransport(t *testing.T) {
+	server := miniredis.RunT(t)
+	transport, err := NewRedisStreamsTransport("redis://"+server.Addr(), "test")
+	assert.Nil(t, err)
+	testTransport(t, transport)
+}
+
+func TestKafkaTransport(t *testing.T) {
+	brokers := os.Getenv("TEST_KAFKA_BROKERS")
+	if brokers == "" {
+		t.Skip("TEST_KAFKA_BROKERS is not set")
+	}
+	testTransport(t, NewKafkaTransport(strings.Split(brokers, ","), "test"))
+}
+
+func TestRabbitTransport(t *testing.T) {
+	url := os.Getenv("TEST_AMQP_URL")
+	if url == "" {
+		t.Skip("TEST_AMQP_URL is not set")
+	}
+	transport, err := NewRabbitTransport(url, "test")
+	assert.Nil(t, err)
+	testTransport(t, transport)
+}
@@ -1,60 +1,472 @@
 package di
 
 import (
+	"context"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"reflect"
+	"strings"
+	"sync"
 )
 
-/*
-var c = dig.New()
+type Component interface {
+}
+
+type Lifetime int
+
+const (
+	// Singleton instances are created once per container
+	Singleton Lifetime = iota
+	// Transient instances are created each time they are resolved
+	Transient
+	// Scoped instances are created once per Scope (ex: per request)
+	Scoped
+)
+
+func (l Lifetime) String() string {
+	switch l {
+	case Transient:
+		return "transient"
+	case Scoped:
+		return "scoped"
+	}
+	return "singleton"
+}
+
+// Lifecycle is injected in the constructors registering start and stop hooks, called by Container.Start and
+// Container.Stop (App.Run).
+type Lifecycle interface {
+	OnStart(hook func(ctx context.Context) error)
+	OnStop(hook func(ctx context.Context) error)
+}
+
+var (
+	errorType     = reflect.TypeOf((*error)(nil)).Elem()
+	lifecycleType = reflect.TypeOf((*Lifecycle)(nil)).Elem()
+	scopeType     = reflect.TypeOf((*Scope)(nil))
+)
+
+type key struct {
+	typ  reflect.Type
+	name string
+}
+
+func (k key) String() string {
+	if k.name == "" {
+		return k.typ.String()
+	}
+	return fmt.Sprintf("%s[%s]", k.typ, k.name)
+}
+
+type provider struct {
+	key  key
+	ctor reflect.Value
+	// instance is set for the supplied instances, built for the singletons once created
+	instance *reflect.Value
+	once     sync.Once
+	built    reflect.Value
+	err      error
+	lifetime Lifetime
+	params   []key
+	// as are the interfaces the provider is also bound to
+	as []reflect.Type
+}
+
+type Option func(p *provider)
+
+// Named registers the instance under a name, resolved with the same name (see Params and Resolve).
+func Named(name string) Option {
+	return func(p *provider) {
+		p.key.name = name
+	}
+}
+
+// As binds the instance to the interface I as well (ex: As[micro.Mailer]()).
+func As[I any]() Option {
+	return func(p *provider) {
+		p.as = append(p.as, reflect.TypeOf((*I)(nil)).Elem())
+	}
+}
+
+func WithLifetime(lifetime Lifetime) Option {
+	return func(p *provider) {
+		p.lifetime = lifetime
+	}
+}
 
-func Provide(provider interface{}) error {
-	return c.Provide(provider)
+// Params qualifies the parameters of the constructor by position with the names of the instances to inject,
+// "" for the default instance.
+func Params(names ...string) Option {
+	return func(p *provider) {
+		for i, name := range names {
+			if i < len(p.params) {
+				p.params[i].name = name
+			}
+		}
+	}
+}
+
+// Container creates the instances with their constructors (providers), resolving the parameters of the
+// constructors from the container.
+type Container struct {
+	mu        sync.Mutex
+	providers map[key]*provider
+	order     []*provider
+	// components are the lifecycles in the order they were injected, started in that order
+	components []*lifecycle
+	started    int
 }
 
-func Overwrite(decorator interface{}) error {
-	return c.Decorate(decorator)
+func New() *Container {
+	return &Container{providers: map[key]*provider{}}
+}
+
+// Provide registers a constructor: a function returning the instance, or the instance and an error. Its
+// parameters are resolved from the container, Lifecycle and *Scope are injected as well.
+func (c *Container) Provide(constructor any, opts ...Option) error {
+	ctor := reflect.ValueOf(constructor)
+	t := ctor.Type()
+	if t.Kind() != reflect.Func || t.NumOut() == 0 || t.NumOut() > 2 || (t.NumOut() == 2 && t.Out(1) != errorType) {
+		return fmt.Errorf("invalid provider %s: expected func(...) T or func(...) (T, error)", t)
+	}
+	p := &provider{key: key{typ: t.Out(0)}, ctor: ctor}
+	for i := 0; i < t.NumIn(); i++ {
+		p.params = append(p.params, key{typ: t.In(i)})
+	}
+	return c.add(p, opts)
 }
 
-func Inject(function interface{}) error {
-	return c.Invoke(func(env *micro.Env) error {
-		_, err := micro.Invoke(env, func() (interface{}, error) {
-			err := c.Invoke(function)
-			return nil, err
-		})
-		return err
+// Supply registers an existing instance under the type T (ex: Supply[micro.Mailer](c, mailer)).
+func Supply[T any](c *Container, instance T, opts ...Option) error {
+	value := reflect.ValueOf(&instance).Elem()
+	return c.add(&provider{key: key{typ: value.Type()}, instance: &value}, opts)
+}
+
+// Register adds an instance under its own type and a name (ex: the component of a feature).
+func (c *Container) Register(name string, instance any) error {
+	value := reflect.ValueOf(instance)
+	return c.add(&provider{key: key{typ: value.Type(), name: name}, instance: &value}, nil)
+}
+
+func (c *Container) add(p *provider, opts []Option) error {
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.instance != nil && p.lifetime != Singleton {
+		return fmt.Errorf("%s: supplied instances are singletons", p.key)
+	}
+	keys := []key{p.key}
+	for _, iface := range p.as {
+		if iface.Kind() != reflect.Interface || !p.key.typ.Implements(iface) {
+			return fmt.Errorf("%s does not implement %s", p.key.typ, iface)
+		}
+		keys = append(keys, key{typ: iface, name: p.key.name})
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range keys {
+		if _, exists := c.providers[k]; exists {
+			return fmt.Errorf("%s is already provided", k)
+		}
+	}
+	for _, k := range keys {
+		c.providers[k] = p
+	}
+	c.order = append(c.order, p)
+	return nil
+}
+
+// ResolveFrom returns the instance of type T from the container, the instance with the given name if any.
+// Scoped instances require a Scope (see ResolveIn).
+func ResolveFrom[T any](c *Container, name ...string) (T, error) {
+	return ResolveIn[T](&Scope{container: c}, name...)
+}
+
+// MustResolveFrom panics when the instance can't be resolved, for the wiring done on startup.
+func MustResolveFrom[T any](c *Container, name ...string) T {
+	instance, err := ResolveFrom[T](c, name...)
+	if err != nil {
+		panic(err)
+	}
+	return instance
+}
+
+// ResolveIn returns the instance of type T, the scoped instances are shared within the scope.
+func ResolveIn[T any](scope *Scope, name ...string) (T, error) {
+	var zero T
+	k := key{typ: reflect.TypeOf((*T)(nil)).Elem()}
+	if len(name) > 0 {
+		k.name = name[0]
+	}
+	value, err := scope.resolve(k, nil)
+	if err != nil {
+		return zero, err
+	}
+	return value.Interface().(T), nil
+}
+
+// Scope holds the scoped instances, ex: the instances of a request.
+type Scope struct {
+	container *Container
+	mu        sync.Mutex
+	instances map[*provider]reflect.Value
+	values    map[reflect.Type]reflect.Value
+}
+
+// Bind adds an instance of type T to the scope only, injected in the scoped and transient instances (ex: the
+// micro.Ctx of the request).
+func Bind[T any](s *Scope, instance T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = map[reflect.Type]reflect.Value{}
+	}
+	s.values[reflect.TypeOf((*T)(nil)).Elem()] = reflect.ValueOf(&instance).Elem()
+}
+
+func (c *Container) NewScope() *Scope {
+	return &Scope{container: c, instances: map[*provider]reflect.Value{}}
+}
+
+func (s *Scope) resolve(k key, path []key) (reflect.Value, error) {
+	switch k.typ {
+	case lifecycleType:
+		return reflect.ValueOf((Lifecycle)(s.container.newLifecycle())), nil
+	case scopeType:
+		return reflect.ValueOf(s), nil
+	}
+	if k.name == "" {
+		s.mu.Lock()
+		value, bound := s.values[k.typ]
+		s.mu.Unlock()
+		if bound {
+			return value, nil
+		}
+	}
+	c := s.container
+	c.mu.Lock()
+	p, ok := c.providers[k]
+	// the path is compared by provider, an interface and its implementation are the same step
+	cycleStart := -1
+	for i, visited := range path {
+		if ok && c.providers[visited] == p {
+			cycleStart = i
+			break
+		}
+	}
+	c.mu.Unlock()
+	if !ok {
+		if len(path) > 0 {
+			return reflect.Value{}, fmt.Errorf("no provider for %s (required by %s)", k, path[len(path)-1])
+		}
+		return reflect.Value{}, fmt.Errorf("no provider for %s", k)
+	}
+	if cycleStart >= 0 {
+		cycle := make([]string, 0, len(path)-cycleStart+1)
+		for _, step := range append(path[cycleStart:], k) {
+			cycle = append(cycle, step.String())
+		}
+		return reflect.Value{}, fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+	if p.instance != nil {
+		return *p.instance, nil
+	}
+	switch p.lifetime {
+	case Singleton:
+		// the singletons only depend on singletons, they are shared by all the scopes
+		return c.singleton(p, append(path, k))
+	case Scoped:
+		if s.instances == nil {
+			return reflect.Value{}, fmt.Errorf("%s is scoped, it must be resolved within a scope", k)
+		}
+		s.mu.Lock()
+		instance, exists := s.instances[p]
+		s.mu.Unlock()
+		if exists {
+			return instance, nil
+		}
+		instance, err := s.create(p, append(path, k))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if existing, exists := s.instances[p]; exists {
+			return existing, nil
+		}
+		s.instances[p] = instance
+		return instance, nil
+	}
+	return s.create(p, append(path, k))
+}
+
+func (c *Container) singleton(p *provider, path []key) (reflect.Value, error) {
+	p.once.Do(func() {
+		p.built, p.err = (&Scope{container: c}).create(p, path)
 	})
+	return p.built, p.err
 }
 
-func Reset() {
-	c = dig.New()
+func (s *Scope) create(p *provider, path []key) (reflect.Value, error) {
+	args := make([]reflect.Value, len(p.params))
+	for i, param := range p.params {
+		if p.lifetime == Singleton && param.typ == scopeType {
+			return reflect.Value{}, fmt.Errorf("%s is a singleton, it can't depend on a scope", p.key)
+		}
+		arg, err := s.resolve(param, path)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		args[i] = arg
+	}
+	out := p.ctor.Call(args)
+	if len(out) == 2 && !out[1].IsNil() {
+		return reflect.Value{}, fmt.Errorf("unable to create %s: %w", p.key, out[1].Interface().(error))
+	}
+	return out[0], nil
 }
-*/
 
-type Component interface {
+// lifecycle holds the hooks of a component, its OnStop hooks only run once its OnStart hooks succeeded.
+type lifecycle struct {
+	container *Container
+	onStart   []func(ctx context.Context) error
+	onStop    []func(ctx context.Context) error
+	started   bool
+}
+
+func (c *Container) newLifecycle() *lifecycle {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := &lifecycle{container: c}
+	c.components = append(c.components, l)
+	return l
+}
+
+func (l *lifecycle) OnStart(hook func(ctx context.Context) error) {
+	l.container.mu.Lock()
+	defer l.container.mu.Unlock()
+	l.onStart = append(l.onStart, hook)
+}
+
+func (l *lifecycle) OnStop(hook func(ctx context.Context) error) {
+	l.container.mu.Lock()
+	defer l.container.mu.Unlock()
+	l.onStop = append(l.onStop, hook)
+}
+
+// Start creates the singletons, in the order they were provided, then calls the OnStart hooks of the
+// components. It fails on the first error, Stop then only stops the components started before.
+func (c *Container) Start(ctx context.Context) error {
+	c.mu.Lock()
+	providers := append([]*provider{}, c.order...)
+	c.mu.Unlock()
+	for _, p := range providers {
+		if p.lifetime == Singleton && p.instance == nil {
+			if _, err := c.singleton(p, []key{p.key}); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		c.mu.Lock()
+		if c.started >= len(c.components) {
+			c.mu.Unlock()
+			return nil
+		}
+		component := c.components[c.started]
+		c.started++
+		c.mu.Unlock()
+		// the hooks may register more hooks or components
+		for i := 0; ; i++ {
+			c.mu.Lock()
+			if i >= len(component.onStart) {
+				component.started = true
+				c.mu.Unlock()
+				break
+			}
+			hook := component.onStart[i]
+			c.mu.Unlock()
+			if err := hook(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Stop calls the OnStop hooks of the started components in the reverse order, it returns the errors of all
+// the hooks.
+func (c *Container) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	var hooks []func(ctx context.Context) error
+	for _, component := range c.components {
+		if component.started {
+			hooks = append(hooks, component.onStop...)
+			component.started = false
+		}
+	}
+	c.mu.Unlock()
+	var errs []string
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i](ctx); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
 }
 
-var registry = make(map[string]any)
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// DEFAULT CONTAINER
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
 
+var defaultContainer = New()
+
+// Register adds a component to the default container, replacing the component with the same name and type.
+//
+// Deprecated: use a Container (Env.Container) and Container.Register or Container.Provide.
 func Register(name string, provider interface{}) {
-	registry[name] = provider
+	value := reflect.ValueOf(provider)
+	k := key{typ: value.Type(), name: name}
+	defaultContainer.mu.Lock()
+	if existing, ok := defaultContainer.providers[k]; ok {
+		delete(defaultContainer.providers, k)
+		for i, p := range defaultContainer.order {
+			if p == existing {
+				defaultContainer.order = append(defaultContainer.order[:i:i], defaultContainer.order[i+1:]...)
+				break
+			}
+		}
+	}
+	defaultContainer.mu.Unlock()
+	if err := defaultContainer.Register(name, provider); err != nil {
+		log.Fatalf("failed to register component %s: %v", name, err)
+	}
 }
 
+// Resolve returns the component of type *T registered in the default container, whatever its name.
+//
+// Deprecated: use ResolveFrom with a Container (Env.Container).
 func Resolve[T Component](typ T) *T {
-	rtype := reflect.TypeOf(typ)
-	for _, component := range registry {
-		cr := reflect.TypeOf(component)
-		if cr == rtype {
-			return component.(*T)
-		}
-		if cr.Kind() == reflect.Ptr && cr.Elem() == rtype {
-			return component.(*T)
+	target := reflect.TypeOf((*T)(nil))
+	defaultContainer.mu.Lock()
+	defer defaultContainer.mu.Unlock()
+	for _, p := range defaultContainer.order {
+		if p.key.typ == target && p.instance != nil {
+			return p.instance.Interface().(*T)
 		}
 	}
-	log.Fatalf("failed to resolve component %s", typ)
+	log.Fatalf("failed to resolve component %s", target)
 	return nil
 }
 
+// Clear removes the components of the default container.
+//
+// Deprecated: use a new Container.
 func Clear() {
-	registry = make(map[string]any)
+	defaultContainer.mu.Lock()
+	defer defaultContainer.mu.Unlock()
+	defaultContainer.providers = map[key]*provider{}
+	defaultContainer.order = nil
 }
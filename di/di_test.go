@@ -0,0 +1,155 @@
+package di
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type greeter interface {
+	Greet(name string) string
+}
+
+type config struct {
+	greeting string
+}
+
+type englishGreeter struct {
+	cfg *config
+}
+
+func (g *englishGreeter) Greet(name string) string {
+	return g.cfg.greeting + " " + name
+}
+
+type request struct {
+	id int
+}
+
+type handler struct {
+	greeter greeter
+	request *request
+	replica *config
+}
+
+func TestContainer(t *testing.T) {
+	c := New()
+	var events []string
+	assert.Nil(t, Supply(c, &config{greeting: "Hello"}))
+	assert.Nil(t, Supply(c, &config{greeting: "Hi"}, Named("replica")))
+	assert.Nil(t, c.Provide(func(cfg *config, lc Lifecycle) *englishGreeter {
+		lc.OnStart(func(ctx context.Context) error {
+			events = append(events, "greeter started")
+			return nil
+		})
+		lc.OnStop(func(ctx context.Context) error {
+			events = append(events, "greeter stopped")
+			return nil
+		})
+		return &englishGreeter{cfg: cfg}
+	}, As[greeter]()))
+	requests := 0
+	assert.Nil(t, c.Provide(func() *request {
+		requests++
+		return &request{id: requests}
+	}, WithLifetime(Scoped)))
+	assert.Nil(t, c.Provide(func(g greeter, r *request, replica *config) *handler {
+		return &handler{greeter: g, request: r, replica: replica}
+	}, WithLifetime(Transient), Params("", "", "replica")))
+
+	assert.NotNil(t, c.Provide(func() *config { return nil }))
+	assert.NotNil(t, c.Provide("not a function"))
+	assert.NotNil(t, c.Provide(func() (*request, int) { return nil, 0 }))
+	assert.NotNil(t, c.Provide(func() *request { return nil }, Named("other"), As[greeter]()))
+
+	assert.Nil(t, c.Start(context.Background()))
+	assert.Equal(t, []string{"greeter started"}, events)
+
+	g, err := ResolveFrom[greeter](c)
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello Jane", g.Greet("Jane"))
+	concrete := MustResolveFrom[*englishGreeter](c)
+	assert.Same(t, concrete, g)
+	replica, err := ResolveFrom[*config](c, "replica")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hi", replica.greeting)
+
+	_, err = ResolveFrom[*handler](c)
+	assert.EqualError(t, err, "*di.request is scoped, it must be resolved within a scope")
+	first := c.NewScope()
+	h1, err := ResolveIn[*handler](first)
+	assert.Nil(t, err)
+	h2, _ := ResolveIn[*handler](first)
+	assert.NotSame(t, h1, h2)
+	assert.Same(t, h1.request, h2.request)
+	assert.Equal(t, "Hi", h1.replica.greeting)
+	h3, _ := ResolveIn[*handler](c.NewScope())
+	assert.Equal(t, 2, h3.request.id)
+	_, err = ResolveFrom[fmt.Stringer](c)
+	assert.EqualError(t, err, "no provider for fmt.Stringer")
+
+	assert.Nil(t, c.Stop(context.Background()))
+	assert.Equal(t, []string{"greeter started", "greeter stopped"}, events)
+}
+
+type a struct{}
+type b struct{}
+
+func TestContainerErrors(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.Provide(func(_ greeter) *a { return &a{} }))
+	assert.Nil(t, c.Provide(func(_ *a) *b { return &b{} }))
+	assert.Nil(t, c.Provide(func(_ *b) *englishGreeter { return &englishGreeter{} }, As[greeter]()))
+	_, err := ResolveFrom[*b](c)
+	assert.EqualError(t, err, "dependency cycle: *di.b -> *di.a -> di.greeter -> *di.b")
+
+	c = New()
+	assert.Nil(t, c.Provide(func(_ *config) *a { return &a{} }))
+	_, err = ResolveFrom[*a](c)
+	assert.EqualError(t, err, "no provider for *di.config (required by *di.a)")
+	assert.NotNil(t, c.Start(context.Background()))
+
+	c = New()
+	assert.Nil(t, c.Provide(func() (*config, error) { return nil, fmt.Errorf("missing DATABASE_URL") }))
+	_, err = ResolveFrom[*config](c)
+	assert.EqualError(t, err, "unable to create *di.config: missing DATABASE_URL")
+
+	c = New()
+	assert.Nil(t, c.Provide(func() *request { return &request{} }, WithLifetime(Scoped)))
+	assert.Nil(t, c.Provide(func(r *request) *a { return &a{} }))
+	_, err = ResolveIn[*a](c.NewScope())
+	assert.EqualError(t, err, "*di.request is scoped, it must be resolved within a scope")
+}
+
+func TestContainerStopOnlyStarted(t *testing.T) {
+	c := New()
+	var stopped []string
+	assert.Nil(t, c.Provide(func(lc Lifecycle) *config {
+		lc.OnStop(func(ctx context.Context) error {
+			stopped = append(stopped, "config")
+			return nil
+		})
+		return &config{}
+	}))
+	assert.Nil(t, c.Provide(func(_ *config, lc Lifecycle) *englishGreeter {
+		lc.OnStart(func(ctx context.Context) error { return fmt.Errorf("port in use") })
+		lc.OnStop(func(ctx context.Context) error {
+			stopped = append(stopped, "greeter")
+			return nil
+		})
+		return &englishGreeter{}
+	}))
+	assert.EqualError(t, c.Start(context.Background()), "port in use")
+	assert.Nil(t, c.Stop(context.Background()))
+	assert.Equal(t, []string{"config"}, stopped)
+	assert.Nil(t, c.Stop(context.Background()))
+	assert.Equal(t, []string{"config"}, stopped)
+}
+
+func TestDefaultContainer(t *testing.T) {
+	defer Clear()
+	Register("config", &config{greeting: "Hello"})
+	Register("config", &config{greeting: "Hi"})
+	assert.Equal(t, "Hi", Resolve(config{}).greeting)
+}
@@ -0,0 +1,210 @@
+package micro
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"github.com/fabriqs/go-micro/schema"
+	"github.com/fabriqs/go-micro/util/dates"
+	"github.com/fabriqs/go-micro/util/errors"
+	"github.com/fabriqs/go-micro/util/ids"
+	"strings"
+	"time"
+)
+
+// ApiKey is the persisted (hashed) form of an api key. The plain key is only returned once, when issued.
+type ApiKey struct {
+	Id         string     `json:"id" gorm:"primaryKey"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"-"`
+	Roles      string     `json:"roles,omitempty"`
+	Scopes     string     `json:"scopes,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (ApiKey) TableName() string {
+	return "z_api_keys"
+}
+
+func (k *ApiKey) IsActive() bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || k.ExpiresAt.After(dates.Now())
+}
+
+type ApiKeyRequest struct {
+	Name   string     `json:"name" validate:"required"`
+	Roles  []string   `json:"roles"`
+	Scopes []string   `json:"scopes"`
+	TTL    string     `json:"ttl"`
+	Expiry *time.Time `json:"expires_at"`
+}
+
+type ApiKeyRef struct {
+	Id string `param:"id" json:"id" validate:"required"`
+}
+
+type IssuedApiKey struct {
+	ApiKey
+	Key string `json:"key"`
+}
+
+type ApiKeyService interface {
+	Issue(ctx Ctx, req ApiKeyRequest) (*IssuedApiKey, error)
+	Revoke(ctx Ctx, id string) error
+	List(ctx Ctx) ([]*ApiKey, error)
+	Authenticate(key string) (*Authentication, error)
+}
+
+type dbApiKeyService struct {
+	repo EntityRepoImpl[ApiKey]
+}
+
+// NewApiKeyService returns an ApiKeyService storing keys in the tenant DataSource (table z_api_keys).
+// Keys have the form <tenant>.<id>.<secret>, only the sha256 of the secret is stored.
+func NewApiKeyService() ApiKeyService {
+	return &dbApiKeyService{
+		repo: NewRepoImpl[ApiKey](func(e *ApiKey) {
+			if e.Id == "" {
+				e.Id = ids.NewId("ak")
+			}
+			e.CreatedAt = dates.Now()
+		}),
+	}
+}
+
+func (s *dbApiKeyService) Issue(ctx Ctx, req ApiKeyRequest) (*IssuedApiKey, error) {
+	secret, err := randomSecret()
+	if err != nil {
+		return nil, err
+	}
+	key := &ApiKey{
+		Name:      req.Name,
+		Hash:      hashSecret(secret),
+		Roles:     strings.Join(req.Roles, ","),
+		Scopes:    strings.Join(req.Scopes, ","),
+		ExpiresAt: req.Expiry,
+	}
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			return nil, errors.Functional("invalid_ttl", req.TTL)
+		}
+		key.ExpiresAt = dates.NowPtrPlus(ttl)
+	}
+	if ctx.Auth != nil {
+		key.CreatedBy = ctx.Auth.UserId
+	}
+	if err = s.repo.Create(ctx, key); err != nil {
+		return nil, err
+	}
+	return &IssuedApiKey{
+		ApiKey: *key,
+		Key:    fmt.Sprintf("%s.%s.%s", ctx.TenantId, key.Id, secret),
+	}, nil
+}
+
+func (s *dbApiKeyService) Revoke(ctx Ctx, id string) error {
+	key, err := s.repo.FindById(ctx, id)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return errors.ResourceNotFound("api_key_not_found")
+	}
+	return s.repo.Patch(ctx, id, map[string]interface{}{"revoked_at": dates.Now()})
+}
+
+func (s *dbApiKeyService) List(ctx Ctx) ([]*ApiKey, error) {
+	return s.repo.FindAllSorted(ctx, "created_at desc")
+}
+
+func (s *dbApiKeyService) Authenticate(value string) (*Authentication, error) {
+	tenantId, id, secret, ok := splitApiKey(value)
+	if !ok {
+		return nil, errors.Unauthorized("invalid_api_key")
+	}
+	ctx := NewCtx(tenantId)
+	if ctx.db == nil {
+		return nil, errors.Unauthorized("invalid_api_key")
+	}
+	key, err := s.repo.FindById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashSecret(secret))) != 1 {
+		return nil, errors.Unauthorized("invalid_api_key")
+	}
+	if !key.IsActive() {
+		return nil, errors.Unauthorized("api_key_expired")
+	}
+	_ = s.repo.Patch(ctx, id, map[string]interface{}{"last_used_at": dates.Now()})
+
+	return &Authentication{
+		Authenticated: true,
+		Scheme:        AuthSchemeApiKey,
+		UserId:        key.Id,
+		Name:          key.Name,
+		TenantId:      tenantId,
+		Roles:         splitList(key.Roles),
+		Permissions:   splitList(key.Scopes),
+	}, nil
+}
+
+// RegisterApiKeyRoutes exposes the admin api (list, issue, revoke) of the given service.
+func RegisterApiKeyRoutes(r BaseRouter, service ApiKeyService, filters ...MiddlewareFunc) {
+	r.GET("/api-keys", func(ctx Ctx) (any, error) {
+		return service.List(ctx)
+	}, filters...)
+	r.POST("/api-keys", func(ctx Ctx, input ApiKeyRequest) (any, error) {
+		return service.Issue(ctx, input)
+	}, filters...)
+	r.DELETE("/api-keys/:id", func(ctx Ctx, input ApiKeyRef) (any, error) {
+		if err := service.Revoke(ctx, input.Id); err != nil {
+			return nil, err
+		}
+		return schema.Ack{Value: "revoked"}, nil
+	}, filters...)
+}
+
+// splitApiKey splits <tenant>.<id>.<secret> from the right: the id and the secret never contain a dot, the tenant
+// id may
+func splitApiKey(value string) (tenantId string, id string, secret string, ok bool) {
+	last := strings.LastIndex(value, ".")
+	if last < 0 {
+		return "", "", "", false
+	}
+	middle := strings.LastIndex(value[:last], ".")
+	if middle <= 0 {
+		return "", "", "", false
+	}
+	tenantId, id, secret = value[:middle], value[middle+1:last], value[last+1:]
+	return tenantId, id, secret, id != "" && secret != ""
+}
+
+func randomSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
@@ -0,0 +1,17 @@
+package micro
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSplitApiKey(t *testing.T) {
+	tenantId, id, secret, ok := splitApiKey("acme.eu.ak_c1.f00d")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"acme.eu", "ak_c1", "f00d"}, []string{tenantId, id, secret})
+
+	for _, invalid := range []string{"", "f00d", "ak_c1.f00d", ".ak_c1.f00d", "acme..f00d", "acme.ak_c1."} {
+		_, _, _, ok = splitApiKey(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
@@ -0,0 +1,424 @@
+package micro
+
+import (
+	"fmt"
+	"github.com/fabriqs/go-micro/util/dates"
+	"github.com/fabriqs/go-micro/util/errors"
+	"github.com/fabriqs/go-micro/util/h"
+	log "github.com/sirupsen/logrus"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// PERMISSIONS
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// MatchPermission checks a granted permission against a required one. Permissions are colon separated segments,
+// a "*" segment matches any value and a trailing "*" matches all remaining segments (orders:* grants orders:read:own).
+func MatchPermission(granted string, required string) bool {
+	if granted == required || granted == "*" {
+		return true
+	}
+	g := strings.Split(granted, ":")
+	r := strings.Split(required, ":")
+	for i, segment := range g {
+		if segment == "*" && i == len(g)-1 {
+			return len(r) >= len(g)
+		}
+		if i >= len(r) || (segment != "*" && segment != r[i]) {
+			return false
+		}
+	}
+	return len(g) == len(r)
+}
+
+// RolePermissions maps the roles of an authenticated user to the permissions they grant.
+type RolePermissions interface {
+	PermissionsFor(ctx Ctx, roles []string) []string
+}
+
+type StaticRolePermissions struct {
+	RolePermissions
+	roles map[string][]string
+}
+
+func NewStaticRolePermissions(roles map[string][]string) *StaticRolePermissions {
+	return &StaticRolePermissions{roles: roles}
+}
+
+func (s *StaticRolePermissions) PermissionsFor(_ Ctx, roles []string) []string {
+	var out []string
+	for _, role := range roles {
+		out = append(out, s.roles[role]...)
+	}
+	return out
+}
+
+// RolePermission is a role -> permission grant stored in the tenant DataSource.
+type RolePermission struct {
+	Role       string `json:"role" gorm:"primaryKey"`
+	Permission string `json:"permission" gorm:"primaryKey"`
+}
+
+func (RolePermission) TableName() string {
+	return "z_role_permissions"
+}
+
+// DbRolePermissions caches the permissions of the roles per tenant (30s by default, see UseCacheTTL). Grant and
+// Revoke clear the cache of this instance, the other instances see the changes once their cache expires.
+type DbRolePermissions struct {
+	RolePermissions
+	repo  EntityRepoImpl[RolePermission]
+	ttl   time.Duration
+	mu    sync.Mutex
+	cache map[string]cachedPermissions
+}
+
+type cachedPermissions struct {
+	permissions []string
+	expiresAt   time.Time
+}
+
+func NewDbRolePermissions() *DbRolePermissions {
+	return &DbRolePermissions{
+		repo:  NewRepoImpl[RolePermission](func(e *RolePermission) {}),
+		ttl:   30 * time.Second,
+		cache: map[string]cachedPermissions{},
+	}
+}
+
+// UseCacheTTL sets how long the permissions are cached, 0 disables the cache.
+func (s *DbRolePermissions) UseCacheTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ttl = ttl
+	s.cache = map[string]cachedPermissions{}
+}
+
+func (s *DbRolePermissions) PermissionsFor(ctx Ctx, roles []string) []string {
+	if len(roles) == 0 || ctx.db == nil {
+		return nil
+	}
+	sorted := append([]string{}, roles...)
+	sort.Strings(sorted)
+	key := ctx.TenantId + "/" + strings.Join(sorted, ",")
+	now := dates.Now()
+	s.mu.Lock()
+	cached, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.permissions
+	}
+	grants, err := s.repo.FindBy(ctx, "role in (?)", roles)
+	if err != nil {
+		log.Errorf("unable to load role permissions: %v", err)
+		return nil
+	}
+	out := make([]string, 0, len(grants))
+	for _, grant := range grants {
+		out = append(out, grant.Permission)
+	}
+	s.mu.Lock()
+	if s.ttl > 0 {
+		s.cache[key] = cachedPermissions{permissions: out, expiresAt: now.Add(s.ttl)}
+	}
+	s.mu.Unlock()
+	return out
+}
+
+func (s *DbRolePermissions) Grant(ctx Ctx, role string, permissions ...string) error {
+	defer s.invalidate(ctx)
+	for _, permission := range permissions {
+		if err := ctx.db.Save(&RolePermission{Role: role, Permission: permission}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DbRolePermissions) Revoke(ctx Ctx, role string, permissions ...string) error {
+	defer s.invalidate(ctx)
+	return s.repo.DeleteBy(ctx, "role = ? and permission in (?)", role, permissions)
+}
+
+// invalidate clears the cache now and once the transaction of ctx is committed, the permissions read in between
+// may not be committed yet
+func (s *DbRolePermissions) invalidate(ctx Ctx) {
+	reset := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.cache = map[string]cachedPermissions{}
+	}
+	reset()
+	ctx.AfterCommit(reset)
+}
+
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// POLICIES
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+type PolicyEffect string
+
+const (
+	Allow PolicyEffect = "allow"
+	Deny  PolicyEffect = "deny"
+)
+
+// Resource can be implemented by the entities passed to Ctx.Can, otherwise the snake cased type name is used.
+type Resource interface {
+	ResourceType() string
+}
+
+// AccessRequest is what a policy condition is evaluated against.
+type AccessRequest struct {
+	Auth     *Authentication
+	TenantId string
+	Action   string
+	Resource any
+	Time     time.Time
+}
+
+// Attr returns a resource attribute, looked up by map key, json tag or field name.
+func (r AccessRequest) Attr(name string) any {
+	return resourceAttr(r.Resource, name)
+}
+
+type PolicyCondition func(req AccessRequest) bool
+
+// Policy is an ABAC rule. Actions and Resources support the same wildcards as permissions, empty means any.
+// Deny policies always win over permissions and allow policies.
+type Policy struct {
+	Name      string
+	Effect    PolicyEffect
+	Actions   []string
+	Resources []string
+	When      []PolicyCondition
+}
+
+func (p Policy) applies(req AccessRequest) bool {
+	if len(p.Actions) > 0 && !matchAny(p.Actions, req.Action) {
+		return false
+	}
+	if len(p.Resources) > 0 && !matchAny(p.Resources, resourceType(req.Resource)) {
+		return false
+	}
+	for _, cond := range p.When {
+		if !cond(req) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsOwner matches when the given resource attribute equals the current user id.
+func IsOwner(attr string) PolicyCondition {
+	return func(req AccessRequest) bool {
+		if req.Auth == nil || req.Auth.UserId == "" {
+			return false
+		}
+		value := toStringPtr(req.Attr(attr))
+		return value != nil && *value == req.Auth.UserId
+	}
+}
+
+// SameTenant matches when the resource tenant attribute (if any) equals the current tenant.
+func SameTenant(attr string) PolicyCondition {
+	return func(req AccessRequest) bool {
+		value := toStringPtr(req.Attr(attr))
+		return value == nil || *value == req.TenantId
+	}
+}
+
+// HasRole matches when the current user has one of the given roles.
+func HasRole(roles ...string) PolicyCondition {
+	return func(req AccessRequest) bool {
+		return req.Auth != nil && containsAny(req.Auth.Roles, roles)
+	}
+}
+
+// Between matches when the request time (UTC) is within the daily window from-to (HH:MM, 7:00 is accepted), the
+// window crosses midnight when to is before from (22:00-06:00). It panics on an invalid time.
+func Between(from string, to string) PolicyCondition {
+	start, okStart := clockMinutes(from)
+	end, okEnd := clockMinutes(to)
+	if !okStart || !okEnd {
+		panic(fmt.Sprintf("invalid policy window %s-%s", from, to))
+	}
+	return func(req AccessRequest) bool {
+		at := req.Time.UTC()
+		now := at.Hour()*60 + at.Minute()
+		if start <= end {
+			return now >= start && now < end
+		}
+		return now >= start || now < end
+	}
+}
+
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// AUTHORIZER
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+type Authorizer struct {
+	roles    RolePermissions
+	policies []Policy
+}
+
+func NewAuthorizer(roles RolePermissions, policies ...Policy) *Authorizer {
+	return &Authorizer{roles: roles, policies: policies}
+}
+
+var defaultAuthorizer = NewAuthorizer(nil)
+
+// Permissions returns the permissions carried by the authentication plus the ones granted by its roles.
+func (a *Authorizer) Permissions(ctx Ctx) []string {
+	if !ctx.IsAuthenticated() {
+		return nil
+	}
+	permissions := append([]string{}, ctx.Auth.Permissions...)
+	if a.roles != nil {
+		permissions = append(permissions, a.roles.PermissionsFor(ctx, ctx.Auth.Roles)...)
+	}
+	return permissions
+}
+
+// HasPermission returns true if every required permission is granted.
+func (a *Authorizer) HasPermission(ctx Ctx, required ...string) bool {
+	granted := a.Permissions(ctx)
+	for _, permission := range required {
+		if !matchAny(granted, permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// Can evaluates deny policies first, then the permissions of the user and finally the allow policies.
+func (a *Authorizer) Can(ctx Ctx, action string, resource any) bool {
+	req := AccessRequest{
+		Auth:     ctx.Auth,
+		TenantId: ctx.TenantId,
+		Action:   action,
+		Resource: resource,
+		Time:     dates.Now(),
+	}
+	for _, p := range a.policies {
+		if p.Effect == Deny && p.applies(req) {
+			return false
+		}
+	}
+	if a.HasPermission(ctx, action) {
+		return true
+	}
+	for _, p := range a.policies {
+		if p.Effect != Deny && p.applies(req) {
+			return true
+		}
+	}
+	return false
+}
+
+func currentAuthorizer() *Authorizer {
+	if globalEnv != nil && globalEnv.Authorizer != nil {
+		return globalEnv.Authorizer
+	}
+	return defaultAuthorizer
+}
+
+func (ctx Ctx) HasPermission(permissions ...string) bool {
+	return currentAuthorizer().HasPermission(ctx, permissions...)
+}
+
+// Can checks if the current user may perform action on resource (resource can be nil).
+func (ctx Ctx) Can(action string, resource any) bool {
+	return currentAuthorizer().Can(ctx, action, resource)
+}
+
+// Authorize is the error returning variant of Can, meant to be used from handlers.
+func (ctx Ctx) Authorize(action string, resource any) error {
+	if !ctx.IsAuthenticated() {
+		return errors.Unauthorized("Unauthorized")
+	}
+	if !ctx.Can(action, resource) {
+		return errors.Forbidden("access_denied", action)
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func matchAny(granted []string, required string) bool {
+	for _, g := range granted {
+		if MatchPermission(g, required) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values []string, candidates []string) bool {
+	for _, c := range candidates {
+		if h.Contains(values, c) {
+			return true
+		}
+	}
+	return false
+}
+
+func resourceType(resource any) string {
+	if resource == nil {
+		return ""
+	}
+	if r, ok := resource.(Resource); ok {
+		return r.ResourceType()
+	}
+	t := reflect.TypeOf(resource)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return h.ToSnakeCase(t.Name())
+}
+
+func resourceAttr(resource any, name string) any {
+	if resource == nil {
+		return nil
+	}
+	switch r := resource.(type) {
+	case map[string]any:
+		return r[name]
+	case h.Map:
+		return r[name]
+	}
+	v := reflect.ValueOf(resource)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if field.IsExported() && (tag == name || field.Name == name || h.ToSnakeCase(field.Name) == name) {
+			return v.Field(i).Interface()
+		}
+	}
+	return nil
+}
+
+func toStringPtr(value any) *string {
+	switch v := value.(type) {
+	case string:
+		return &v
+	case *string:
+		return v
+	}
+	return nil
+}
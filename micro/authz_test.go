@@ -0,0 +1,66 @@
+package micro
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type order struct {
+	Id      string `json:"id"`
+	OwnerId string `json:"owner_id"`
+}
+
+func TestMatchPermission(t *testing.T) {
+	assert.True(t, MatchPermission("orders:read", "orders:read"))
+	assert.True(t, MatchPermission("orders:*", "orders:read"))
+	assert.True(t, MatchPermission("orders:*", "orders:read:own"))
+	assert.True(t, MatchPermission("*:read", "orders:read"))
+	assert.True(t, MatchPermission("*", "orders:delete"))
+	assert.False(t, MatchPermission("orders:read", "orders:write"))
+	assert.False(t, MatchPermission("orders:*", "orders"))
+	assert.False(t, MatchPermission("*:read", "orders:read:own"))
+	assert.False(t, MatchPermission("invoices:*", "orders:read"))
+}
+
+func TestAuthorizer(t *testing.T) {
+	authz := NewAuthorizer(
+		NewStaticRolePermissions(map[string][]string{"manager": {"orders:*"}}),
+		Policy{Name: "owner can edit own order", Effect: Allow, Actions: []string{"orders:edit"}, When: []PolicyCondition{IsOwner("owner_id")}},
+		Policy{Name: "no deletes for interns", Effect: Deny, Actions: []string{"*:delete"}, When: []PolicyCondition{HasRole("intern")}},
+	)
+	user := Ctx{TenantId: DefaultTenantId, Auth: &Authentication{Authenticated: true, UserId: "u1", Permissions: []string{"orders:read"}}}
+	manager := Ctx{TenantId: DefaultTenantId, Auth: &Authentication{Authenticated: true, UserId: "m1", Roles: []string{"manager", "intern"}}}
+	mine := &order{Id: "o1", OwnerId: "u1"}
+	other := &order{Id: "o2", OwnerId: "u2"}
+
+	assert.True(t, authz.HasPermission(user, "orders:read"))
+	assert.False(t, authz.HasPermission(user, "orders:read", "orders:edit"))
+	assert.True(t, authz.Can(user, "orders:edit", mine))
+	assert.False(t, authz.Can(user, "orders:edit", other))
+	assert.True(t, authz.Can(manager, "orders:edit", other))
+	assert.False(t, authz.Can(manager, "orders:delete", other))
+	assert.False(t, authz.Can(Ctx{TenantId: DefaultTenantId}, "orders:read", nil))
+	assert.Equal(t, "order", resourceType(mine))
+}
+
+func TestBetween(t *testing.T) {
+	at := func(clock string) AccessRequest {
+		parsed, _ := time.Parse("15:04", clock)
+		return AccessRequest{Time: parsed}
+	}
+	office := Between("9:00", "17:30")
+	assert.True(t, office(at("09:00")))
+	assert.True(t, office(at("17:29")))
+	assert.False(t, office(at("17:30")))
+	assert.False(t, office(at("08:59")))
+
+	night := Between("22:00", "06:00")
+	assert.True(t, night(at("23:15")))
+	assert.True(t, night(at("00:00")))
+	assert.True(t, night(at("05:59")))
+	assert.False(t, night(at("06:00")))
+	assert.False(t, night(at("12:00")))
+
+	assert.Panics(t, func() { Between("25:00", "06:00") })
+}
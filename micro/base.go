@@ -1,21 +1,26 @@
 package micro
 
 import (
+	"context"
 	"github.com/fabriqs/go-micro/di"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"sync"
+	"time"
 )
 
 var DefaultTenantId = "public"
 
-type Feature struct {
-	Name string
-	Init func(app *App) (di.Component, error)
-}
-
 type App struct {
 	Name    string
 	Version string
 	Env     *Env
+	// ShutdownGrace bounds the whole shutdown of Run on SIGTERM (default 30s), see Cfg.ShutdownGrace
+	ShutdownGrace time.Duration
+	// DisabledFeatures are not initialized, along with the ones listed in FEATURES_DISABLED
+	DisabledFeatures []string
+	readyHooks       []func() error
+	featuresMu       sync.Mutex
+	features         []*FeatureStatus
 }
 
 type AuthToken struct {
@@ -23,9 +28,17 @@ type AuthToken struct {
 	Audience string `json:"audience"`
 }
 
+const (
+	AuthSchemeBearer  = "bearer"
+	AuthSchemeApiKey  = "api_key"
+	AuthSchemeHmac    = "hmac"
+	AuthSchemeSession = "session"
+)
+
 type Authentication struct {
 	Token         *AuthToken
 	Authenticated bool
+	Scheme        string
 	Name          string
 	Email         string
 	UserId        string
@@ -51,9 +64,24 @@ type TenantLoader interface {
 }
 
 type Ctx struct {
-	TenantId string
-	Auth     *Authentication
-	db       DataSource
+	TenantId      string
+	Auth          *Authentication
+	CorrelationId string
+	db            DataSource
+	session       SessionControl
+	tx            bool
+	bus           *Bus
+	context       context.Context
+	scope         *di.Scope
+	// txState is shared by the nested transactions
+	txState *txState
+}
+
+// txState holds the callbacks of AfterCommit and the error failing the transaction, set by the writes that can't
+// return one (ex: Publish storing its event in the outbox)
+type txState struct {
+	afterCommit []func()
+	err         error
 }
 
 type Env struct {
@@ -68,6 +96,23 @@ type Env struct {
 	Production    bool
 	TenantLoader  TenantLoader
 	Localizer     *i18n.Localizer
+	// I18n is the bundle of the locales of the app, Localizer uses all of them
+	I18n           *i18n.Bundle
+	EmailTemplates *EmailTemplates
+	ApiKeys        ApiKeyService
+	HmacVerifier   *HmacVerifier
+	Authorizer     *Authorizer
+	Sessions       *SessionManager
+	Outbox         *Outbox
+	Events         EventTransport
+	Locker         Locker
+	Jobs           *Jobs
+	Emails         *EmailOutbox
+	// Container creates the components of the app and their dependencies, see Inject
+	Container    *di.Container
+	Inbox        *Inbox
+	DeadLetters  bool
+	healthChecks []healthCheck
 }
 
 type AppCfg struct {
@@ -117,21 +162,84 @@ func NewAuthCtx(auth *Authentication) Ctx {
 	return ctx
 }
 
+// Context is the cancellation context of ctx: the request context in handlers, the timeout of scheduled jobs.
+func (ctx Ctx) Context() context.Context {
+	if ctx.context == nil {
+		return context.Background()
+	}
+	return ctx.context
+}
+
+func (ctx Ctx) WithContext(c context.Context) Ctx {
+	ctx.context = c
+	return ctx
+}
+
+// detach returns a fresh Ctx for the tenant and the authentication of ctx, without its transaction and session.
+func (ctx Ctx) detach() Ctx {
+	fresh := NewCtx(ctx.TenantId)
+	if ctx.Auth != nil {
+		auth := *ctx.Auth
+		fresh.Auth = &auth
+	}
+	fresh.CorrelationId = ctx.CorrelationId
+	fresh.bus = ctx.bus
+	return fresh
+}
+
 func (ctx Ctx) Tx(cb func(tx Ctx) error) error {
 	db := ctx.db
 	if db == nil {
 		db = globalEnv.DB[ctx.TenantId]
 	}
-	return db.Transaction(func(tx DataSource) error {
-		return cb(Ctx{
-			TenantId: ctx.TenantId,
-			Auth:     ctx.Auth,
-			db:       tx,
-		})
+	state := &txState{}
+	err := db.Transaction(func(tx DataSource) error {
+		txCtx := ctx
+		txCtx.db = tx
+		txCtx.tx = true
+		if !ctx.tx || txCtx.txState == nil {
+			txCtx.txState = state
+		}
+		if err := cb(txCtx); err != nil {
+			return err
+		}
+		return txCtx.txState.err
 	})
+	if err == nil && !ctx.tx {
+		if globalEnv != nil && globalEnv.Outbox != nil {
+			globalEnv.Outbox.Notify()
+		}
+		if globalEnv != nil && globalEnv.Jobs != nil {
+			globalEnv.Jobs.Notify()
+		}
+		for _, fn := range state.afterCommit {
+			fn()
+		}
+	}
+	return err
+}
+
+// AfterCommit calls fn once the transaction of ctx is committed, never when it is rolled back. fn is called right
+// away outside of Ctx.Tx.
+func (ctx Ctx) AfterCommit(fn func()) {
+	if !ctx.tx || ctx.txState == nil {
+		fn()
+		return
+	}
+	ctx.txState.afterCommit = append(ctx.txState.afterCommit, fn)
+}
+
+// fail rolls back the transaction of ctx once its callback returns, it is ignored outside of Ctx.Tx.
+func (ctx Ctx) fail(err error) {
+	if ctx.tx && ctx.txState != nil && ctx.txState.err == nil {
+		ctx.txState.err = err
+	}
 }
 
 func (e Env) Close() {
+	if e.Events != nil {
+		_ = e.Events.Close()
+	}
 	for _, db := range e.DB {
 		db.Close()
 	}
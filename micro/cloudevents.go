@@ -0,0 +1,330 @@
+package micro
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/fabriqs/go-micro/schema"
+	"github.com/fabriqs/go-micro/util/dates"
+	"github.com/fabriqs/go-micro/util/errors"
+	"github.com/fabriqs/go-micro/util/ids"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const CloudEventsSpecVersion = "1.0"
+const CloudEventsContentType = "application/cloudevents+json"
+
+// extensions set by ToCloudEvent
+const (
+	CloudEventTopic         = "topic"
+	CloudEventTenant        = "tenantid"
+	CloudEventCorrelationId = "correlationid"
+	CloudEventError         = "error"
+)
+
+// CloudEvent is a CloudEvents 1.0 envelope. Extensions are serialized as top level attributes (structured mode)
+// or ce-* headers (binary mode).
+type CloudEvent struct {
+	SpecVersion     string
+	Id              string
+	Source          string
+	Type            string
+	Subject         string
+	Time            time.Time
+	DataContentType string
+	DataSchema      string
+	Data            []byte
+	Extensions      map[string]string
+}
+
+// RequestBinder is implemented by handler inputs which read the request themselves.
+type RequestBinder interface {
+	BindRequest(r *http.Request) error
+}
+
+var cloudEventAttributes = map[string]bool{
+	"specversion": true, "id": true, "source": true, "type": true, "subject": true, "time": true,
+	"datacontenttype": true, "dataschema": true, "data": true, "data_base64": true,
+}
+
+func (ce CloudEvent) isJson() bool {
+	return ce.DataContentType == "" || strings.Contains(ce.DataContentType, "json")
+}
+
+func (ce CloudEvent) Validate() error {
+	if ce.SpecVersion != CloudEventsSpecVersion {
+		return errors.Functional("invalid_cloudevent", fmt.Sprintf("unsupported specversion %q", ce.SpecVersion))
+	}
+	if ce.Id == "" || ce.Source == "" || ce.Type == "" {
+		return errors.Functional("invalid_cloudevent", "id, source and type are required")
+	}
+	return nil
+}
+
+func (ce CloudEvent) MarshalJSON() ([]byte, error) {
+	out := map[string]interface{}{
+		"specversion": ce.SpecVersion,
+		"id":          ce.Id,
+		"source":      ce.Source,
+		"type":        ce.Type,
+	}
+	if ce.Subject != "" {
+		out["subject"] = ce.Subject
+	}
+	if !ce.Time.IsZero() {
+		out["time"] = ce.Time.Format(time.RFC3339Nano)
+	}
+	if ce.DataContentType != "" {
+		out["datacontenttype"] = ce.DataContentType
+	}
+	if ce.DataSchema != "" {
+		out["dataschema"] = ce.DataSchema
+	}
+	if len(ce.Data) > 0 {
+		if ce.isJson() {
+			out["data"] = json.RawMessage(ce.Data)
+		} else {
+			out["data_base64"] = base64.StdEncoding.EncodeToString(ce.Data)
+		}
+	}
+	for name, value := range ce.Extensions {
+		out[name] = value
+	}
+	return json.Marshal(out)
+}
+
+func (ce *CloudEvent) UnmarshalJSON(data []byte) error {
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return err
+	}
+	str := func(name string) string {
+		var value string
+		_ = json.Unmarshal(attributes[name], &value)
+		return value
+	}
+	*ce = CloudEvent{
+		SpecVersion:     str("specversion"),
+		Id:              str("id"),
+		Source:          str("source"),
+		Type:            str("type"),
+		Subject:         str("subject"),
+		DataContentType: str("datacontenttype"),
+		DataSchema:      str("dataschema"),
+		Extensions:      map[string]string{},
+	}
+	if value := str("time"); value != "" {
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return err
+		}
+		ce.Time = t
+	}
+	if value := str("data_base64"); value != "" {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return err
+		}
+		ce.Data = decoded
+	} else if raw, ok := attributes["data"]; ok {
+		ce.Data = raw
+		if !ce.isJson() {
+			// non json data carried as a json string
+			var text string
+			if json.Unmarshal(raw, &text) == nil {
+				ce.Data = []byte(text)
+			}
+		}
+	}
+	for name, raw := range attributes {
+		if cloudEventAttributes[name] {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err == nil {
+			ce.Extensions[name] = fmt.Sprint(value)
+		}
+	}
+	return nil
+}
+
+// BindRequest reads a CloudEvent from an HTTP request, in structured or binary mode.
+func (ce *CloudEvent) BindRequest(r *http.Request) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	contentType := r.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, CloudEventsContentType) {
+		if err = json.Unmarshal(body, ce); err != nil {
+			return errors.Functional("invalid_cloudevent", err.Error())
+		}
+		return ce.Validate()
+	}
+	if r.Header.Get("ce-specversion") == "" {
+		return errors.Functional("invalid_cloudevent", "missing ce-specversion header")
+	}
+	*ce = CloudEvent{
+		SpecVersion:     r.Header.Get("ce-specversion"),
+		Id:              r.Header.Get("ce-id"),
+		Source:          r.Header.Get("ce-source"),
+		Type:            r.Header.Get("ce-type"),
+		Subject:         r.Header.Get("ce-subject"),
+		DataSchema:      r.Header.Get("ce-dataschema"),
+		DataContentType: contentType,
+		Data:            body,
+		Extensions:      map[string]string{},
+	}
+	if value := r.Header.Get("ce-time"); value != "" {
+		if ce.Time, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return errors.Functional("invalid_cloudevent", err.Error())
+		}
+	}
+	for name := range r.Header {
+		lower := strings.ToLower(name)
+		if !strings.HasPrefix(lower, "ce-") || cloudEventAttributes[lower[3:]] {
+			continue
+		}
+		ce.Extensions[lower[3:]] = r.Header.Get(name)
+	}
+	return ce.Validate()
+}
+
+// NewCloudEventRequest builds the POST request of a CloudEvent, in binary (ce-* headers) or structured mode.
+func NewCloudEventRequest(url string, ce CloudEvent, binary bool) (*http.Request, error) {
+	if !binary {
+		body, err := json.Marshal(ce)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", CloudEventsContentType+"; charset=utf-8")
+		return req, nil
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(ce.Data))
+	if err != nil {
+		return nil, err
+	}
+	set := func(name string, value string) {
+		if value != "" {
+			req.Header.Set("ce-"+name, value)
+		}
+	}
+	set("specversion", ce.SpecVersion)
+	set("id", ce.Id)
+	set("source", ce.Source)
+	set("type", ce.Type)
+	set("subject", ce.Subject)
+	set("dataschema", ce.DataSchema)
+	if !ce.Time.IsZero() {
+		set("time", ce.Time.Format(time.RFC3339Nano))
+	}
+	for name, value := range ce.Extensions {
+		set(name, value)
+	}
+	if ce.DataContentType != "" {
+		req.Header.Set("Content-Type", ce.DataContentType)
+	}
+	return req, nil
+}
+
+// ToCloudEvent converts an event published on topic; the type is the event name (the topic if empty), the topic,
+// tenant and correlation id are carried as extensions.
+func ToCloudEvent(ctx Ctx, source string, topic string, payload Event) (CloudEvent, error) {
+	data, err := json.Marshal(payload.Data)
+	if err != nil {
+		return CloudEvent{}, err
+	}
+	eventType := payload.Event
+	if eventType == "" {
+		eventType = topic
+	}
+	ce := CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		Id:              ids.NewId("evt"),
+		Source:          source,
+		Type:            eventType,
+		Subject:         payload.Subject,
+		Time:            dates.Now(),
+		DataContentType: "application/json",
+		Data:            data,
+		Extensions:      map[string]string{CloudEventTopic: topic},
+	}
+	if ctx.TenantId != "" {
+		ce.Extensions[CloudEventTenant] = ctx.TenantId
+	}
+	if ctx.CorrelationId != "" {
+		ce.Extensions[CloudEventCorrelationId] = ctx.CorrelationId
+	}
+	if payload.Error != "" {
+		ce.Extensions[CloudEventError] = payload.Error
+	}
+	return ce, nil
+}
+
+// FromCloudEvent returns the topic and the Event of a CloudEvent: the topic extension, the type otherwise.
+// Json data is decoded as generic json, other content types are passed as a string.
+func FromCloudEvent(ce CloudEvent) (string, Event, error) {
+	topic := ce.Extensions[CloudEventTopic]
+	if topic == "" {
+		topic = ce.Type
+	}
+	payload := Event{
+		Subject: ce.Subject,
+		Event:   ce.Type,
+		Error:   ce.Extensions[CloudEventError],
+	}
+	if len(ce.Data) == 0 {
+		return topic, payload, nil
+	}
+	if !ce.isJson() {
+		payload.Data = string(ce.Data)
+		return topic, payload, nil
+	}
+	if err := json.Unmarshal(ce.Data, &payload.Data); err != nil {
+		return "", Event{}, err
+	}
+	return topic, payload, nil
+}
+
+// localTransport is implemented by transports forwarding events out of the process: ingested events are only
+// delivered to the local handlers, not forwarded again.
+type localTransport interface {
+	PublishLocal(ctx Ctx, topic string, payload Event) error
+}
+
+// Ingest delivers an event received from another service to the handlers of the bus.
+func (b *Bus) Ingest(ctx Ctx, topic string, payload Event) error {
+	ctx.bus = b
+	if local, ok := b.current().(localTransport); ok {
+		return local.PublishLocal(ctx, topic, payload)
+	}
+	return b.current().Publish(ctx, topic, payload)
+}
+
+// RegisterCloudEventRoute accepts CloudEvents (structured or binary mode) on path and dispatches them to the
+// subscribed handlers, with the tenant and the authentication of the caller. A failing synchronous handler
+// fails the request, so that the sender retries.
+func RegisterCloudEventRoute(r BaseRouter, path string, filters ...MiddlewareFunc) {
+	r.POST(path, func(ctx Ctx, ce CloudEvent) (any, error) {
+		topic, payload, err := FromCloudEvent(ce)
+		if err != nil {
+			return nil, errors.Functional("invalid_cloudevent", err.Error())
+		}
+		ctx.CorrelationId = ce.Extensions[CloudEventCorrelationId]
+		if ctx.CorrelationId == "" {
+			ctx.CorrelationId = ce.Id
+		}
+		if err = busOf(ctx).Ingest(ctx, topic, payload); err != nil {
+			return nil, errors.Technical(err.Error())
+		}
+		return schema.Ack{Value: ce.Id}, nil
+	}, filters...)
+}
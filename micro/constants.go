@@ -1,9 +1,13 @@
 package micro
 
-const SchedulerService = "scheduler_service"
-const TokenProviderService = "token_provider_service"
-const MailerServer = "mailer_service"
-const Notifications = "notifications_service"
+// Deprecated: the components are registered in Env.Container and resolved by type (see Inject), these names are
+// not used anymore.
+const (
+	SchedulerService     = "scheduler_service"
+	TokenProviderService = "token_provider_service"
+	MailerServer         = "mailer_service"
+	Notifications        = "notifications_service"
+)
 
 const NotificationTopic = "notifications"
 
@@ -12,3 +16,12 @@ const DatabaseInitialTenants = "DATABASE_INITIAL_TENANTS"
 const ServerToken = "SERVER_TOKEN"
 const EmailSender = "EMAIL_SENDER"
 const NotificationSender = "NOTIFICATION_SENDER"
+const EventTransportUrl = "EVENT_TRANSPORT"
+const FeaturesDisabled = "FEATURES_DISABLED"
+
+const HeaderApiKey = "X-Api-Key"
+const HeaderSignature = "X-Signature"
+const HeaderSignatureKey = "X-Signature-Key"
+const HeaderSignatureTimestamp = "X-Signature-Timestamp"
+const HeaderCsrfToken = "X-CSRF-Token"
+const HeaderWebhookSecret = "X-Webhook-Secret"
@@ -14,6 +14,7 @@ import (
 
 type DataSourceMigrations interface {
 	Migrate(fs fs.FS, location string)
+	AutoMigrate(models ...any) error
 }
 
 type DataSource interface {
@@ -22,6 +23,8 @@ type DataSource interface {
 	Close()
 	Save(target any) error
 	Create(target any) error
+	// CreateIfAbsent inserts target unless it conflicts with a unique index, it returns false in that case
+	CreateIfAbsent(target any) (bool, error)
 	Ping() error
 	Delete(any, Query) (int64, error)
 	Exists(any, Query) (bool, error)
@@ -30,6 +33,10 @@ type DataSource interface {
 	Count(any, Query) (int64, error)
 	Execute(any, Query) (int64, error)
 	Patch(model any, id string, data map[string]interface{}) (int64, error)
+	// Update sets data on the rows of model matching q (q.W and q.Args)
+	Update(model any, q Query, data map[string]interface{}) (int64, error)
+	// Dialect is the name of the database ("postgres", "sqlite")
+	Dialect() string
 }
 
 var ErrRecordNotFound = errors.Functional("record not found")
@@ -0,0 +1,276 @@
+package micro
+
+import (
+	serrors "errors"
+	"fmt"
+	"github.com/fabriqs/go-micro/schema"
+	"github.com/fabriqs/go-micro/util/dates"
+	"github.com/fabriqs/go-micro/util/errors"
+	"github.com/fabriqs/go-micro/util/ids"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"time"
+)
+
+// RetryPolicy tells how a subscription handles failures: the handler is retried with an exponential backoff,
+// then the event is parked as a dead letter (z_dead_letters table when enabled, and <topic>.dead topic).
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	// DeadLetterTopic defaults to <topic>.dead
+	DeadLetterTopic string
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = time.Minute
+	}
+	return time.Duration(math.Min(float64(p.Backoff)*math.Pow(2, float64(attempt-1)), float64(maxBackoff)))
+}
+
+// DeadLetter is an event a subscription gave up on, stored in the tenant DataSource (table z_dead_letters).
+type DeadLetter struct {
+	Id           string     `json:"id" gorm:"primaryKey"`
+	Subscription string     `json:"subscription" gorm:"index"`
+	Topic        string     `json:"topic" gorm:"index"`
+	Subject      string     `json:"subject,omitempty"`
+	Event        string     `json:"event,omitempty"`
+	Payload      string     `json:"payload"`
+	Reason       string     `json:"reason"`
+	Attempts     int        `json:"attempts"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ReplayedAt   *time.Time `json:"replayed_at,omitempty"`
+}
+
+func (DeadLetter) TableName() string {
+	return "z_dead_letters"
+}
+
+type DeadLetterRef struct {
+	Id string `param:"id" json:"id" validate:"required"`
+}
+
+// TopicStats are the counters of the handlers subscribed to a topic.
+type TopicStats struct {
+	Handled      int64 `json:"handled"`
+	Failed       int64 `json:"failed"`
+	Retried      int64 `json:"retried"`
+	Panics       int64 `json:"panics"`
+	DeadLettered int64 `json:"dead_lettered"`
+}
+
+func (b *Bus) count(topic string, update func(s *TopicStats)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats, ok := b.stats[topic]
+	if !ok {
+		stats = &TopicStats{}
+		b.stats[topic] = stats
+	}
+	update(stats)
+}
+
+// Stats returns a snapshot of the handler counters, per topic.
+func (b *Bus) Stats() map[string]TopicStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	out := make(map[string]TopicStats, len(b.stats))
+	for topic, stats := range b.stats {
+		out[topic] = *stats
+	}
+	return out
+}
+
+func (b *Bus) subscription(name string) (SubscribeFunc, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	handle, ok := b.subscriptions[name]
+	return handle, ok
+}
+
+// guard wraps a handler with the isolation of async handlers, the transaction option, panic recovery, metrics
+// and the retry policy of the subscription.
+func (b *Bus) guard(topic string, opts SubscribeOptions, handle SubscribeFunc) (SubscribeFunc, error) {
+	if opts.Retry != nil {
+		// the dead letters are replayed by name, a generated one would not survive a restart
+		if opts.Name == "" {
+			return nil, fmt.Errorf("subscription to %s: a retry policy requires a name", topic)
+		}
+		// the retries would block the publisher, and its transaction, during the backoff
+		if !opts.Async {
+			return nil, fmt.Errorf("subscription %s: a retry policy requires an async subscription", opts.Name)
+		}
+	}
+	if opts.Tx {
+		inner := handle
+		handle = func(ctx Ctx, payload Event) error {
+			return ctx.Tx(func(tx Ctx) error {
+				return inner(tx, payload)
+			})
+		}
+	}
+	name := opts.Name
+	if name != "" {
+		b.mu.Lock()
+		_, exists := b.subscriptions[name]
+		if !exists {
+			b.subscriptions[name] = handle
+		}
+		b.mu.Unlock()
+		if exists {
+			return nil, fmt.Errorf("subscription %s already exists", name)
+		}
+	}
+
+	policy := RetryPolicy{MaxAttempts: 1}
+	if opts.Retry != nil {
+		policy = *opts.Retry
+		if policy.MaxAttempts <= 0 {
+			policy.MaxAttempts = 1
+		}
+	}
+
+	return func(ctx Ctx, payload Event) error {
+		ctx.bus = b
+		if opts.Async {
+			ctx = ctx.detach()
+		}
+		var err error
+		for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+			if attempt > 1 {
+				b.count(topic, func(s *TopicStats) { s.Retried++ })
+				time.Sleep(policy.delay(attempt - 1))
+			}
+			if err = b.safeHandle(topic, ctx, payload, handle); err == nil {
+				b.count(topic, func(s *TopicStats) { s.Handled++ })
+				return nil
+			}
+		}
+		b.count(topic, func(s *TopicStats) { s.Failed++ })
+		if opts.Retry == nil {
+			return err
+		}
+		return b.deadLetter(name, topic, policy, ctx, payload, err)
+	}, nil
+}
+
+func (b *Bus) safeHandle(topic string, ctx Ctx, payload Event, handle SubscribeFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.count(topic, func(s *TopicStats) { s.Panics++ })
+			err = fmt.Errorf("panic while handling event %s: %v", topic, r)
+		}
+	}()
+	return handle(ctx, payload)
+}
+
+// deadLetter parks the event; it returns nil once the event is stored, the cause otherwise.
+func (b *Bus) deadLetter(subscription string, topic string, policy RetryPolicy, ctx Ctx, payload Event, cause error) error {
+	b.count(topic, func(s *TopicStats) { s.DeadLettered++ })
+	log.Errorf("event %s given up by %s after %d attempt(s): %v", topic, subscription, policy.MaxAttempts, cause)
+
+	dlqTopic := policy.DeadLetterTopic
+	if dlqTopic == "" {
+		dlqTopic = topic + ".dead"
+	}
+	dead := payload
+	dead.Error = cause.Error()
+	if err := b.deliver(ctx, dlqTopic, dead); err != nil {
+		log.Errorf("unable to publish dead letter on %s: %v", dlqTopic, err)
+	}
+
+	if globalEnv == nil || !globalEnv.DeadLetters {
+		return cause
+	}
+	// not ctx.db, the transaction of the publisher may be rolled back
+	db := NewCtx(ctx.TenantId).db
+	if db == nil {
+		return cause
+	}
+	data, err := EncodeEvent(ctx, topic, payload)
+	if err != nil {
+		return cause
+	}
+	if err = db.Create(&DeadLetter{
+		Id:           ids.NewId("dlq"),
+		Subscription: subscription,
+		Topic:        topic,
+		Subject:      payload.Subject,
+		Event:        payload.Event,
+		Payload:      string(data),
+		Reason:       cause.Error(),
+		Attempts:     policy.MaxAttempts,
+		CreatedAt:    dates.Now(),
+	}); err != nil {
+		log.Errorf("unable to store dead letter: %v", err)
+		return cause
+	}
+	return nil
+}
+
+// ListDeadLetters returns the dead letters of the tenant, most recent first.
+func ListDeadLetters(ctx Ctx, pendingOnly bool) ([]*DeadLetter, error) {
+	var out []*DeadLetter
+	db := NewCtx(ctx.TenantId).db
+	if db == nil {
+		return nil, errors.ResourceNotFound("tenant_not_found")
+	}
+	q := Query{Sort: "created_at desc"}
+	if pendingOnly {
+		q.W = "replayed_at is null"
+	}
+	err := db.Find(&out, q)
+	return out, err
+}
+
+// ReplayDeadLetter calls the handler of the subscription again with the stored event.
+func ReplayDeadLetter(ctx Ctx, id string) error {
+	db := NewCtx(ctx.TenantId).db
+	if db == nil {
+		return errors.ResourceNotFound("tenant_not_found")
+	}
+	var letter DeadLetter
+	if err := db.First(&letter, Query{W: "id = ?", Args: []any{id}}); err != nil {
+		if serrors.Is(err, ErrRecordNotFound) {
+			return errors.ResourceNotFound("dead_letter_not_found")
+		}
+		return err
+	}
+	bus := busOf(ctx)
+	handle, ok := bus.subscription(letter.Subscription)
+	if !ok {
+		return errors.Functional("unknown_subscription", letter.Subscription)
+	}
+	eventCtx, payload, err := DecodeEvent([]byte(letter.Payload))
+	if err != nil {
+		return err
+	}
+	eventCtx.bus = bus
+	if err = bus.safeHandle(letter.Topic, eventCtx, payload, handle); err != nil {
+		_, _ = db.Patch(&DeadLetter{}, id, map[string]interface{}{
+			"attempts": letter.Attempts + 1,
+			"reason":   err.Error(),
+		})
+		return errors.Functional("replay_failed", err.Error())
+	}
+	_, err = db.Patch(&DeadLetter{}, id, map[string]interface{}{"replayed_at": dates.Now()})
+	return err
+}
+
+// RegisterDeadLetterRoutes exposes the dead letters (list, replay) and the event handler stats.
+func RegisterDeadLetterRoutes(r BaseRouter, filters ...MiddlewareFunc) {
+	r.GET("/dead-letters", func(ctx Ctx) (any, error) {
+		return ListDeadLetters(ctx, false)
+	}, filters...)
+	r.POST("/dead-letters/:id/replay", func(ctx Ctx, input DeadLetterRef) (any, error) {
+		if err := ReplayDeadLetter(ctx, input.Id); err != nil {
+			return nil, err
+		}
+		return schema.Ack{Value: "replayed"}, nil
+	}, filters...)
+	r.GET("/events/stats", func(ctx Ctx) (any, error) {
+		return EventStats(), nil
+	}, filters...)
+}
@@ -1,2 +1,61 @@
 package micro
 
+import (
+	"fmt"
+	"github.com/fabriqs/go-micro/di"
+	log "github.com/sirupsen/logrus"
+)
+
+// provideEnv makes the services of env injectable by their interface (ex: Inject[Mailer](ctx)), along with the
+// Env itself
+func provideEnv(c *di.Container, env *Env) {
+	var errs []error
+	errs = append(errs, di.Supply[*Env](c, env))
+	if env.Scheduler != nil {
+		errs = append(errs, di.Supply[Scheduler](c, env.Scheduler))
+	}
+	if env.TokenProvider != nil {
+		errs = append(errs, di.Supply[TokenProvider](c, env.TokenProvider))
+	}
+	if env.Mailer != nil {
+		errs = append(errs, di.Supply[Mailer](c, env.Mailer))
+	}
+	if env.Notifier != nil {
+		errs = append(errs, di.Supply[NotificationService](c, env.Notifier))
+	}
+	if env.Jobs != nil {
+		errs = append(errs, di.Supply[*Jobs](c, env.Jobs))
+	}
+	if env.Locker != nil {
+		errs = append(errs, di.Supply[Locker](c, env.Locker))
+	}
+	for _, err := range errs {
+		if err != nil {
+			log.Errorf("unable to register the services of the env: %v", err)
+		}
+	}
+}
+
+// WithScope returns ctx with a new scope of the container (Env.Container): the scoped instances resolved with
+// Inject are shared by the copies of ctx, ex: during a request. The scoped instances can depend on Ctx, they
+// receive ctx as it is now.
+func (ctx Ctx) WithScope() Ctx {
+	if globalEnv == nil || globalEnv.Container == nil {
+		return ctx
+	}
+	ctx.scope = globalEnv.Container.NewScope()
+	di.Bind[Ctx](ctx.scope, ctx)
+	return ctx
+}
+
+// Inject returns the instance of type T from the container (Env.Container), within the scope of ctx if any.
+func Inject[T any](ctx Ctx, name ...string) (T, error) {
+	if ctx.scope != nil {
+		return di.ResolveIn[T](ctx.scope, name...)
+	}
+	if globalEnv == nil || globalEnv.Container == nil {
+		var zero T
+		return zero, fmt.Errorf("no container, the app is not initialized")
+	}
+	return di.ResolveFrom[T](globalEnv.Container, name...)
+}
@@ -2,6 +2,7 @@ package micro
 
 import (
 	"github.com/fabriqs/go-micro/util/h"
+	"time"
 )
 
 type EmailAddress struct {
@@ -11,14 +12,62 @@ type EmailAddress struct {
 }
 
 type Email struct {
-	From         *EmailAddress
-	To           []EmailAddress
-	Subject      string
-	Body         string
+	From    *EmailAddress
+	To      []EmailAddress
+	Cc      []EmailAddress
+	Bcc     []EmailAddress
+	ReplyTo *EmailAddress
+	Subject string
+	// Body is the plain text version of the message
+	Body string
+	// Html is the HTML version of the message, sent along with Body when both are set
+	Html        string
+	Attachments []EmailAttachment
+	// TemplateId is a local template (see EmailTemplates) or a template of the provider
 	TemplateId   string
 	TemplateData h.Map
+	// Headers are added to the message (ex: List-Unsubscribe)
+	Headers map[string]string
+	// Categories tag the message for the statistics of the provider
+	Categories []string
+	// SendAt delays the delivery, for the providers supporting scheduled sends
+	SendAt *time.Time
+	// Locale and TenantId select the variant of a local template
+	Locale   string
+	TenantId string
+}
+
+// Recipients returns To, Cc and Bcc.
+func (e Email) Recipients() []EmailAddress {
+	return append(append(append([]EmailAddress{}, e.To...), e.Cc...), e.Bcc...)
+}
+
+// EmailAttachment is a file attached to an Email. With a ContentId it is an inline image, referenced in Html as
+// <img src="cid:ContentId">.
+type EmailAttachment struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+	ContentId   string
 }
 
 type Mailer interface {
-	Send(message Email) error
+	// Send returns the id of the message given by the provider
+	Send(message Email) (string, error)
+	// SendBatch sends the messages in as few requests as the provider allows, it returns their ids in order and stops
+	// at the first error
+	SendBatch(messages []Email) ([]string, error)
+}
+
+// SendEach is SendBatch for the providers without batch sends.
+func SendEach(mailer Mailer, messages []Email) ([]string, error) {
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		id, err := mailer.Send(message)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
 }
@@ -0,0 +1,248 @@
+package micro
+
+import (
+	"golang.org/x/net/html"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var cssComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+// simpleSelector matches an element by tag, id and classes, ex: "td", "p.note", "#header", ".btn.primary"
+var simpleSelector = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9]*|\*)?((?:[#.][\w-]+)*)$`)
+
+var selectorPart = regexp.MustCompile(`[#.][\w-]+`)
+
+type cssRule struct {
+	tag          string
+	id           string
+	classes      []string
+	declarations []cssDeclaration
+	specificity  int
+	order        int
+}
+
+type cssDeclaration struct {
+	property string
+	value    string
+}
+
+func (r cssRule) matches(node *html.Node) bool {
+	if r.tag != "" && r.tag != "*" && r.tag != node.Data {
+		return false
+	}
+	if r.id != "" && htmlAttr(node, "id") != r.id {
+		return false
+	}
+	classes := strings.Fields(htmlAttr(node, "class"))
+	for _, class := range r.classes {
+		found := false
+		for _, candidate := range classes {
+			found = found || candidate == class
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// InlineCss moves the rules of the <style> elements of an html document into the style attributes of the elements
+// they match, as most email clients ignore <style>. Only tag, id and class selectors are inlined, the other rules
+// (media queries, pseudo-classes, combinators) are kept in a <style> element.
+func InlineCss(document string) (string, error) {
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return "", err
+	}
+	var rules []cssRule
+	var styles []*html.Node
+	walkHtml(root, func(node *html.Node) {
+		if node.Type == html.ElementNode && node.Data == "style" {
+			styles = append(styles, node)
+		}
+	})
+	for _, style := range styles {
+		var css strings.Builder
+		for child := style.FirstChild; child != nil; child = child.NextSibling {
+			css.WriteString(child.Data)
+		}
+		inlined, kept := parseCss(css.String(), len(rules))
+		rules = append(rules, inlined...)
+		if strings.TrimSpace(kept) == "" {
+			style.Parent.RemoveChild(style)
+		} else {
+			style.FirstChild.Data = kept
+			for child := style.FirstChild.NextSibling; child != nil; child = style.FirstChild.NextSibling {
+				style.RemoveChild(child)
+			}
+		}
+	}
+	if len(rules) == 0 {
+		return document, nil
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].specificity != rules[j].specificity {
+			return rules[i].specificity < rules[j].specificity
+		}
+		return rules[i].order < rules[j].order
+	})
+	walkHtml(root, func(node *html.Node) {
+		if node.Type != html.ElementNode {
+			return
+		}
+		var declarations []cssDeclaration
+		for _, rule := range rules {
+			if rule.matches(node) {
+				declarations = append(declarations, rule.declarations...)
+			}
+		}
+		if len(declarations) == 0 {
+			return
+		}
+		// the style attribute has precedence over the rules
+		declarations = append(declarations, parseDeclarations(htmlAttr(node, "style"))...)
+		setHtmlAttr(node, "style", formatDeclarations(declarations))
+	})
+	var out strings.Builder
+	if err := html.Render(&out, root); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// parseCss returns the rules that can be inlined, and the css of the others
+func parseCss(css string, order int) ([]cssRule, string) {
+	css = cssComment.ReplaceAllString(css, "")
+	var rules []cssRule
+	var kept strings.Builder
+	for {
+		open := strings.Index(css, "{")
+		if open < 0 {
+			break
+		}
+		prelude := strings.TrimSpace(css[:open])
+		// find the end of the block, at-rules have nested blocks
+		depth, end := 0, -1
+		for i := open; i < len(css) && end < 0; i++ {
+			switch css[i] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			end = len(css) - 1
+		}
+		block := css[open+1 : end]
+		raw := css[:end+1]
+		css = css[end+1:]
+
+		var selected []cssRule
+		inlinable := !strings.HasPrefix(prelude, "@")
+		for _, selector := range strings.Split(prelude, ",") {
+			rule, ok := parseSelector(strings.TrimSpace(selector))
+			if !ok {
+				inlinable = false
+				break
+			}
+			selected = append(selected, rule)
+		}
+		if !inlinable {
+			kept.WriteString(strings.TrimSpace(raw) + "\n")
+			continue
+		}
+		declarations := parseDeclarations(block)
+		for _, rule := range selected {
+			rule.declarations = declarations
+			rule.order = order
+			order++
+			rules = append(rules, rule)
+		}
+	}
+	return rules, kept.String()
+}
+
+func parseSelector(selector string) (cssRule, bool) {
+	match := simpleSelector.FindStringSubmatch(selector)
+	if selector == "" || match == nil {
+		return cssRule{}, false
+	}
+	rule := cssRule{tag: strings.ToLower(match[1])}
+	if rule.tag != "" && rule.tag != "*" {
+		rule.specificity = 1
+	}
+	for _, part := range selectorPart.FindAllString(match[2], -1) {
+		if part[0] == '#' {
+			rule.id = part[1:]
+			rule.specificity += 10000
+		} else {
+			rule.classes = append(rule.classes, part[1:])
+			rule.specificity += 100
+		}
+	}
+	return rule, true
+}
+
+func parseDeclarations(block string) []cssDeclaration {
+	var declarations []cssDeclaration
+	for _, declaration := range strings.Split(block, ";") {
+		property, value, found := strings.Cut(declaration, ":")
+		if !found || strings.TrimSpace(property) == "" {
+			continue
+		}
+		declarations = append(declarations, cssDeclaration{
+			property: strings.ToLower(strings.TrimSpace(property)),
+			value:    strings.TrimSpace(value),
+		})
+	}
+	return declarations
+}
+
+// formatDeclarations keeps the last value of each property, at the position of its first declaration
+func formatDeclarations(declarations []cssDeclaration) string {
+	values := map[string]string{}
+	var properties []string
+	for _, declaration := range declarations {
+		if _, ok := values[declaration.property]; !ok {
+			properties = append(properties, declaration.property)
+		}
+		values[declaration.property] = declaration.value
+	}
+	parts := make([]string, 0, len(properties))
+	for _, property := range properties {
+		parts = append(parts, property+": "+values[property])
+	}
+	return strings.Join(parts, "; ")
+}
+
+func walkHtml(node *html.Node, visit func(node *html.Node)) {
+	visit(node)
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		walkHtml(child, visit)
+	}
+}
+
+func htmlAttr(node *html.Node, name string) string {
+	for _, a := range node.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setHtmlAttr(node *html.Node, name string, value string) {
+	for i, a := range node.Attr {
+		if a.Key == name {
+			node.Attr[i].Val = value
+			return
+		}
+	}
+	node.Attr = append(node.Attr, html.Attribute{Key: name, Val: value})
+}
@@ -0,0 +1,359 @@
+package micro
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	serrors "errors"
+	"github.com/fabriqs/go-micro/schema"
+	"github.com/fabriqs/go-micro/util/dates"
+	"github.com/fabriqs/go-micro/util/errors"
+	"github.com/fabriqs/go-micro/util/ids"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	EmailQueued  = "queued"
+	EmailSent    = "sent"
+	EmailFailed  = "failed"
+	EmailBounced = "bounced"
+)
+
+// Delivery events reported by the providers
+const (
+	EmailEventDelivered = "delivered"
+	EmailEventOpened    = "opened"
+	EmailEventBounced   = "bounced"
+	EmailEventDropped   = "dropped"
+)
+
+// EmailJob is the type of the background jobs delivering the queued emails.
+const EmailJob = "email.send"
+
+// EmailMessage is an email of the outbox, stored in the tenant DataSource (table z_emails).
+type EmailMessage struct {
+	Id             string     `json:"id" gorm:"primaryKey"`
+	IdempotencyKey string     `json:"idempotency_key,omitempty" gorm:"index"`
+	Status         string     `json:"status" gorm:"index"`
+	Subject        string     `json:"subject"`
+	Recipients     string     `json:"recipients"`
+	Payload        string     `json:"-"`
+	ProviderId     string     `json:"provider_id,omitempty" gorm:"index"`
+	Attempts       int        `json:"attempts"`
+	LastError      string     `json:"last_error,omitempty"`
+	SentAt         *time.Time `json:"sent_at,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	OpenedAt       *time.Time `json:"opened_at,omitempty"`
+	BouncedAt      *time.Time `json:"bounced_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+func (EmailMessage) TableName() string {
+	return "z_emails"
+}
+
+type EmailOutboxConfig struct {
+	// WebhookPath receives the delivery events of the provider (ex: /webhooks/email), no webhook when empty. It
+	// requires WebhookSecret or WebhookPublicKey.
+	WebhookPath string
+	// WebhookSecret authenticates the webhook requests, sent in the X-Webhook-Secret header or as the basic auth
+	// password (ex: https://sendgrid:<secret>@api.example.com/webhooks/email)
+	WebhookSecret string
+	// WebhookPublicKey is the verification key of the SendGrid signed event webhook (base64)
+	WebhookPublicKey string
+}
+
+type EmailOptions struct {
+	// IdempotencyKey returns the email already queued with the same key instead of queuing it again
+	IdempotencyKey string
+	// MaxAttempts defaults to JobsConfig.MaxAttempts
+	MaxAttempts int
+}
+
+// EmailEvent is a delivery event of a provider webhook.
+type EmailEvent struct {
+	ProviderId string    `json:"provider_id"`
+	Event      string    `json:"event"`
+	Timestamp  time.Time `json:"timestamp"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+// EmailEventParser reads the events of a provider webhook request.
+type EmailEventParser func(body []byte, header http.Header) ([]EmailEvent, error)
+
+// EmailWebhookVerifier authenticates a provider webhook request (shared secret, signature).
+type EmailWebhookVerifier func(body []byte, header http.Header) error
+
+// VerifyEmailWebhookSecret accepts the requests sending secret in the X-Webhook-Secret header or as the basic auth
+// password.
+func VerifyEmailWebhookSecret(secret string) EmailWebhookVerifier {
+	return func(_ []byte, header http.Header) error {
+		value := header.Get(HeaderWebhookSecret)
+		if value == "" {
+			_, value, _ = (&http.Request{Header: header}).BasicAuth()
+		}
+		if secret == "" || subtle.ConstantTimeCompare([]byte(value), []byte(secret)) != 1 {
+			return errors.Unauthorized("invalid_webhook_secret")
+		}
+		return nil
+	}
+}
+
+// EmailOutbox delivers the emails in the background (Jobs), with retries, and tracks their status.
+type EmailOutbox struct {
+	mailer Mailer
+	jobs   *Jobs
+}
+
+type emailJobPayload struct {
+	Id string `json:"id"`
+}
+
+func NewEmailOutbox(mailer Mailer, jobs *Jobs) *EmailOutbox {
+	o := &EmailOutbox{mailer: mailer, jobs: jobs}
+	jobs.Handle(EmailJob, o.deliver)
+	return o
+}
+
+// Queue stores the email in the DataSource of ctx (its transaction when called within Ctx.Tx), it is sent once
+// committed, at message.SendAt if set. It returns the id of the EmailMessage.
+func (o *EmailOutbox) Queue(ctx Ctx, message Email, opts ...EmailOptions) (string, error) {
+	var options EmailOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	db := ctx.db
+	if db == nil {
+		return "", errors.ResourceNotFound("tenant_not_found", ctx.TenantId)
+	}
+	if options.IdempotencyKey != "" {
+		var existing EmailMessage
+		err := db.First(&existing, Query{W: "idempotency_key = ?", Args: []any{options.IdempotencyKey}})
+		if err == nil {
+			return existing.Id, nil
+		}
+		if !serrors.Is(err, ErrRecordNotFound) {
+			return "", err
+		}
+	}
+	if message.TenantId == "" {
+		message.TenantId = ctx.TenantId
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	recipients := make([]string, 0, len(message.To))
+	for _, to := range message.Recipients() {
+		recipients = append(recipients, to.Address)
+	}
+	now := dates.Now()
+	email := &EmailMessage{
+		Id:             ids.NewId("email"),
+		IdempotencyKey: options.IdempotencyKey,
+		Status:         EmailQueued,
+		Subject:        message.Subject,
+		Recipients:     strings.Join(recipients, ","),
+		Payload:        string(payload),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err = db.Create(email); err != nil {
+		return "", err
+	}
+	var delay time.Duration
+	if message.SendAt != nil {
+		delay = message.SendAt.Sub(now)
+	}
+	_, err = o.jobs.Enqueue(ctx, EmailJob, emailJobPayload{Id: email.Id}, EnqueueOptions{
+		Delay:       delay,
+		UniqueKey:   email.Id,
+		MaxAttempts: options.MaxAttempts,
+	})
+	return email.Id, err
+}
+
+func (o *EmailOutbox) deliver(ctx Ctx, job *QueuedJob) error {
+	var payload emailJobPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return err
+	}
+	// the status is kept outside of the transaction of the job, which is rolled back on failure
+	db := ctx.detach().db
+	var email EmailMessage
+	if err := db.First(&email, Query{W: "id = ?", Args: []any{payload.Id}}); err != nil {
+		return err
+	}
+	if email.Status != EmailQueued {
+		return nil
+	}
+	var message Email
+	if err := json.Unmarshal([]byte(email.Payload), &message); err != nil {
+		return err
+	}
+	providerId, err := o.mailer.Send(message)
+	if err != nil {
+		status := EmailQueued
+		if job.Attempts >= job.MaxAttempts {
+			status = EmailFailed
+		}
+		if _, patchErr := db.Patch(&EmailMessage{}, email.Id, map[string]interface{}{
+			"status":     status,
+			"attempts":   job.Attempts,
+			"last_error": err.Error(),
+			"updated_at": dates.Now(),
+		}); patchErr != nil {
+			log.Errorf("unable to update email %s: %v", email.Id, patchErr)
+		}
+		return err
+	}
+	_, err = db.Patch(&EmailMessage{}, email.Id, map[string]interface{}{
+		"status":      EmailSent,
+		"provider_id": providerId,
+		"attempts":    job.Attempts,
+		"last_error":  "",
+		"sent_at":     dates.Now(),
+		"updated_at":  dates.Now(),
+	})
+	return err
+}
+
+// Apply updates the emails of the tenant of ctx with the events of a provider webhook, the events of unknown
+// emails are ignored. It returns the number of updated emails.
+func (o *EmailOutbox) Apply(ctx Ctx, events []EmailEvent) (int, error) {
+	count := 0
+	for _, event := range events {
+		updated, err := applyEmailEvent(ctx.db, event)
+		if err != nil {
+			return count, err
+		}
+		count += int(updated)
+	}
+	return count, nil
+}
+
+// ApplyAll updates the emails of every tenant with the events of a provider webhook, which is not tenant aware:
+// the provider ids are unique, each event is applied to the tenant storing the email.
+func (o *EmailOutbox) ApplyAll(events []EmailEvent) (int, error) {
+	tenants := []string{DefaultTenantId}
+	if o.jobs.tenants != nil {
+		tenants = o.jobs.tenants.GetTenant()
+	}
+	count := 0
+	for _, event := range events {
+		for _, tenantId := range tenants {
+			db := NewCtx(tenantId).db
+			if db == nil {
+				continue
+			}
+			updated, err := applyEmailEvent(db, event)
+			if err != nil {
+				return count, err
+			}
+			if updated > 0 {
+				count += int(updated)
+				break
+			}
+		}
+	}
+	return count, nil
+}
+
+func applyEmailEvent(db DataSource, event EmailEvent) (int64, error) {
+	if event.ProviderId == "" {
+		return 0, nil
+	}
+	at := event.Timestamp
+	if at.IsZero() {
+		at = dates.Now()
+	}
+	changes := map[string]interface{}{"updated_at": dates.Now()}
+	switch event.Event {
+	case EmailEventDelivered:
+		changes["delivered_at"] = at
+	case EmailEventOpened:
+		changes["opened_at"] = at
+	case EmailEventBounced:
+		changes["status"] = EmailBounced
+		changes["bounced_at"] = at
+		changes["last_error"] = event.Reason
+	case EmailEventDropped:
+		changes["status"] = EmailFailed
+		changes["last_error"] = event.Reason
+	default:
+		return 0, nil
+	}
+	return db.Update(&EmailMessage{}, Query{W: "provider_id = ?", Args: []any{event.ProviderId}}, changes)
+}
+
+type EmailsFilter struct {
+	Status string `query:"status" json:"status"`
+	Limit  int64  `query:"limit" json:"limit"`
+}
+
+// List returns the emails of the tenant of ctx, most recent first.
+func (o *EmailOutbox) List(ctx Ctx, filter EmailsFilter) ([]*EmailMessage, error) {
+	q := Query{Sort: "created_at desc", Limit: filter.Limit, W: "1 = 1"}
+	if q.Limit <= 0 || q.Limit > 500 {
+		q.Limit = 100
+	}
+	if filter.Status != "" {
+		q.W += " and status = ?"
+		q.Args = append(q.Args, filter.Status)
+	}
+	var out []*EmailMessage
+	err := ctx.db.Find(&out, q)
+	return out, err
+}
+
+// ParseEmailEvents reads a JSON array of EmailEvent, for providers relayed by a custom integration.
+func ParseEmailEvents(body []byte, _ http.Header) ([]EmailEvent, error) {
+	var events []EmailEvent
+	err := json.Unmarshal(body, &events)
+	return events, err
+}
+
+// emailWebhook keeps the raw request, the parser of the provider reads it
+type emailWebhook struct {
+	body   []byte
+	header http.Header
+}
+
+func (w *emailWebhook) BindRequest(r *http.Request) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	w.body = body
+	w.header = r.Header
+	return nil
+}
+
+// RegisterEmailWebhookRoute receives the delivery events of a provider, authenticated by verify (ex:
+// VerifyEmailWebhookSecret) and read by parser (ex: ParseEmailEvents). The events update the emails of any tenant.
+func RegisterEmailWebhookRoute(r BaseRouter, path string, outbox *EmailOutbox, parser EmailEventParser, verify EmailWebhookVerifier, filters ...MiddlewareFunc) {
+	r.POST(path, func(ctx Ctx, input emailWebhook) (any, error) {
+		if err := verify(input.body, input.header); err != nil {
+			return nil, err
+		}
+		events, err := parser(input.body, input.header)
+		if err != nil {
+			return nil, errors.Functional("invalid_email_events", err.Error())
+		}
+		if _, err = outbox.ApplyAll(events); err != nil {
+			return nil, err
+		}
+		return schema.Ack{Value: "ok"}, nil
+	}, filters...)
+}
+
+// RegisterEmailRoutes exposes the emails of the tenant and their status.
+func RegisterEmailRoutes(r BaseRouter, outbox *EmailOutbox, filters ...MiddlewareFunc) {
+	r.GET("/emails", func(ctx Ctx, input EmailsFilter) (any, error) {
+		return outbox.List(ctx, input)
+	}, filters...)
+}
@@ -0,0 +1,267 @@
+package micro
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fabriqs/go-micro/util/errors"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"html"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+)
+
+// EmailTemplatesDir is the folder of Cfg.FS holding the email templates.
+const EmailTemplatesDir = "templates/email"
+
+var layoutDirective = regexp.MustCompile(`{{\s*/\*\s*layout:\s*([\w-]+)\s*\*/\s*}}`)
+
+// EmailTemplates renders emails from the templates of a folder, independently of the Mailer:
+//
+//	welcome.html, welcome.txt    the message, {{define "subject"}} gives its subject
+//	welcome.fr.html              the message for a locale
+//	layouts/default.html|txt     wraps the messages with {{template "content" .}}, a message selects another
+//	                             layout with {{/* layout: name */}} ("none" for no layout)
+//	partials/footer.html|txt     included with {{template "footer" .}}
+//	tenants/<tenant id>/...      overrides any of the above for a tenant
+//
+// Messages are localized with {{t "message_id"}} or {{t "message_id" .}}, and the CSS of the <style> elements of
+// html messages is inlined.
+type EmailTemplates struct {
+	fs            fs.FS
+	bundle        *i18n.Bundle
+	defaultLocale string
+}
+
+func NewEmailTemplates(fsys fs.FS, bundle *i18n.Bundle, defaultLocale string) *EmailTemplates {
+	return &EmailTemplates{fs: fsys, bundle: bundle, defaultLocale: defaultLocale}
+}
+
+// Has tells whether name is a template, for tenantId when it has overrides.
+func (t *EmailTemplates) Has(name string, tenantId string) bool {
+	return t.find(name+".html", tenantId, "") != "" || t.find(name+".txt", tenantId, "") != ""
+}
+
+// Render fills the Subject, Body and Html of message from its TemplateId and TemplateData, in its Locale and for
+// its TenantId.
+func (t *EmailTemplates) Render(message *Email) error {
+	name := message.TemplateId
+	locale := message.Locale
+	if locale == "" {
+		locale = t.defaultLocale
+	}
+	textFile := t.find(name+".txt", message.TenantId, locale)
+	htmlFile := t.find(name+".html", message.TenantId, locale)
+	if textFile == "" && htmlFile == "" {
+		return errors.ResourceNotFound("email_template_not_found", name)
+	}
+	funcs := map[string]any{"t": t.translator(locale)}
+	var subject string
+	if textFile != "" {
+		tpl, err := t.parse(texttemplate.New(name).Funcs(funcs), textFile, ".txt", message.TenantId)
+		if err != nil {
+			return err
+		}
+		if message.Body, err = executeText(tpl, message.TemplateData); err != nil {
+			return err
+		}
+		if tpl.Lookup("subject") != nil {
+			var buf bytes.Buffer
+			if err := tpl.ExecuteTemplate(&buf, "subject", message.TemplateData); err != nil {
+				return err
+			}
+			subject = buf.String()
+		}
+	}
+	if htmlFile != "" {
+		tpl, err := t.parseHtml(htmltemplate.New(name).Funcs(funcs), htmlFile, message.TenantId)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := tpl.ExecuteTemplate(&buf, "main", message.TemplateData); err != nil {
+			return err
+		}
+		if message.Html, err = InlineCss(buf.String()); err != nil {
+			return err
+		}
+		if subject == "" && tpl.Lookup("subject") != nil {
+			buf.Reset()
+			if err := tpl.ExecuteTemplate(&buf, "subject", message.TemplateData); err != nil {
+				return err
+			}
+			subject = html.UnescapeString(buf.String())
+		}
+	}
+	if subject != "" {
+		message.Subject = strings.TrimSpace(subject)
+	}
+	message.TemplateId = ""
+	return nil
+}
+
+func (t *EmailTemplates) translator(locale string) func(id string, data ...any) string {
+	if t.bundle == nil {
+		return func(id string, _ ...any) string { return id }
+	}
+	localizer := i18n.NewLocalizer(t.bundle, locale, t.defaultLocale)
+	return func(id string, data ...any) string {
+		cfg := &i18n.LocalizeConfig{MessageID: id}
+		if len(data) > 0 {
+			cfg.TemplateData = data[0]
+		}
+		message, err := localizer.Localize(cfg)
+		if err != nil {
+			return id
+		}
+		return message
+	}
+}
+
+// find returns the most specific path of file: tenant and locale, tenant, locale, then default
+func (t *EmailTemplates) find(file string, tenantId string, locale string) string {
+	ext := path.Ext(file)
+	base := strings.TrimSuffix(file, ext)
+	dirs := []string{""}
+	if tenantId != "" {
+		dirs = []string{path.Join("tenants", tenantId), ""}
+	}
+	var candidates []string
+	for _, dir := range dirs {
+		if locale != "" {
+			candidates = append(candidates, path.Join(dir, base+"."+locale+ext))
+		}
+		candidates = append(candidates, path.Join(dir, file))
+	}
+	for _, candidate := range candidates {
+		if _, err := fs.Stat(t.fs, candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// sources returns the main template (the layout wrapping the content, or the content), the content and the partials
+func (t *EmailTemplates) sources(file string, ext string, tenantId string) (map[string]string, error) {
+	content, err := fs.ReadFile(t.fs, file)
+	if err != nil {
+		return nil, err
+	}
+	sources := map[string]string{"content": string(content), "main": `{{template "content" .}}`}
+	layout := "default"
+	if match := layoutDirective.FindSubmatch(content); match != nil {
+		layout = string(match[1])
+	}
+	if layout != "none" {
+		if layoutFile := t.find(path.Join("layouts", layout+ext), tenantId, ""); layoutFile != "" {
+			data, err := fs.ReadFile(t.fs, layoutFile)
+			if err != nil {
+				return nil, err
+			}
+			sources["main"] = string(data)
+		} else if layout != "default" {
+			return nil, errors.ResourceNotFound("email_layout_not_found", layout)
+		}
+	}
+	// tenant partials override the default ones
+	dirs := []string{"partials"}
+	if tenantId != "" {
+		dirs = append(dirs, path.Join("tenants", tenantId, "partials"))
+	}
+	for _, dir := range dirs {
+		entries, err := fs.ReadDir(t.fs, dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || path.Ext(entry.Name()) != ext {
+				continue
+			}
+			data, err := fs.ReadFile(t.fs, path.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			sources[strings.TrimSuffix(entry.Name(), ext)] = string(data)
+		}
+	}
+	return sources, nil
+}
+
+func (t *EmailTemplates) parse(tpl *texttemplate.Template, file string, ext string, tenantId string) (*texttemplate.Template, error) {
+	sources, err := t.sources(file, ext, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	for name, source := range sources {
+		if _, err := tpl.New(name).Parse(source); err != nil {
+			return nil, fmt.Errorf("invalid email template %s -- %v", file, err)
+		}
+	}
+	return tpl, nil
+}
+
+func (t *EmailTemplates) parseHtml(tpl *htmltemplate.Template, file string, tenantId string) (*htmltemplate.Template, error) {
+	sources, err := t.sources(file, ".html", tenantId)
+	if err != nil {
+		return nil, err
+	}
+	for name, source := range sources {
+		if _, err := tpl.New(name).Parse(source); err != nil {
+			return nil, fmt.Errorf("invalid email template %s -- %v", file, err)
+		}
+	}
+	return tpl, nil
+}
+
+func executeText(tpl *texttemplate.Template, data any) (string, error) {
+	var buf bytes.Buffer
+	if err := tpl.ExecuteTemplate(&buf, "main", data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+type templateMailer struct {
+	Mailer
+	mailer    Mailer
+	templates *EmailTemplates
+}
+
+// NewTemplateMailer renders the emails whose TemplateId is a local template before sending them with mailer, the
+// others are sent as is (ex: SendGrid dynamic templates).
+func NewTemplateMailer(mailer Mailer, templates *EmailTemplates) Mailer {
+	return &templateMailer{mailer: mailer, templates: templates}
+}
+
+func (m *templateMailer) Send(message Email) (string, error) {
+	if err := m.render(&message); err != nil {
+		return "", err
+	}
+	return m.mailer.Send(message)
+}
+
+func (m *templateMailer) SendBatch(messages []Email) ([]string, error) {
+	rendered := make([]Email, len(messages))
+	for i, message := range messages {
+		if err := m.render(&message); err != nil {
+			return nil, err
+		}
+		rendered[i] = message
+	}
+	return m.mailer.SendBatch(rendered)
+}
+
+// Unwrap returns the mailer sending the rendered emails.
+func (m *templateMailer) Unwrap() Mailer {
+	return m.mailer
+}
+
+func (m *templateMailer) render(message *Email) error {
+	if message.TemplateId != "" && m.templates.Has(message.TemplateId, message.TenantId) {
+		return m.templates.Render(message)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package micro
+
+import (
+	"github.com/fabriqs/go-micro/util/h"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/language"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+type sentEmails struct {
+	Mailer
+	sent []Email
+}
+
+func (m *sentEmails) Send(message Email) (string, error) {
+	m.sent = append(m.sent, message)
+	return "", nil
+}
+
+func (m *sentEmails) SendBatch(messages []Email) ([]string, error) {
+	return SendEach(m, messages)
+}
+
+func TestEmailTemplates(t *testing.T) {
+	files := fstest.MapFS{
+		"layouts/default.html": {Data: []byte(`<html><head><style>
+p { color: #333; margin: 0 }
+.cta { color: white; background: #0a7 }
+a:hover { color: red }
+</style></head><body>{{template "content" .}}{{template "footer" .}}</body></html>`)},
+		"layouts/default.txt": {Data: []byte(`{{template "content" .}}
+--
+{{template "footer" .}}`)},
+		"partials/footer.html": {Data: []byte(`<p class="footer">Acme</p>`)},
+		"partials/footer.txt":  {Data: []byte(`Acme`)},
+		"welcome.html": {Data: []byte(`{{define "subject"}}{{t "welcome_subject" .}}{{end}}` +
+			`<p>{{t "hello" .}}</p><a class="cta" style="color: yellow" href="{{.url}}">Start</a>`)},
+		"welcome.txt":                         {Data: []byte(`{{define "subject"}}{{t "welcome_subject" .}}{{end}}{{t "hello" .}} {{.url}}`)},
+		"receipt.html":                        {Data: []byte(`{{/* layout: none */}}{{define "subject"}}Receipt & invoice{{end}}<p>{{.amount}}</p>`)},
+		"tenants/globex/partials/footer.html": {Data: []byte(`<p>Globex</p>`)},
+		"tenants/globex/partials/footer.txt":  {Data: []byte(`Globex`)},
+	}
+	bundle := i18n.NewBundle(language.English)
+	bundle.MustAddMessages(language.English,
+		&i18n.Message{ID: "welcome_subject", Other: "Welcome {{.name}}"},
+		&i18n.Message{ID: "hello", Other: "Hello {{.name}}"})
+	bundle.MustAddMessages(language.French,
+		&i18n.Message{ID: "welcome_subject", Other: "Bienvenue {{.name}}"},
+		&i18n.Message{ID: "hello", Other: "Bonjour {{.name}}"})
+	templates := NewEmailTemplates(files, bundle, "en")
+	mailer := &sentEmails{}
+	templated := NewTemplateMailer(mailer, templates)
+
+	data := h.Map{"name": "Jane", "url": "https://acme.com/start"}
+	_, err := templated.Send(Email{TemplateId: "welcome", TemplateData: data})
+	assert.Nil(t, err)
+	_, err = templated.SendBatch([]Email{
+		{TemplateId: "welcome", TemplateData: data, Locale: "fr", TenantId: "globex"},
+		{TemplateId: "receipt", TemplateData: h.Map{"amount": "12 EUR"}},
+		{TemplateId: "d-provider-template"},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, mailer.sent, 4)
+
+	welcome := mailer.sent[0]
+	assert.Equal(t, "Welcome Jane", welcome.Subject)
+	assert.Equal(t, "Hello Jane https://acme.com/start\n--\nAcme", welcome.Body)
+	assert.Empty(t, welcome.TemplateId)
+	assert.Contains(t, welcome.Html, `<p style="color: #333; margin: 0">Hello Jane</p>`)
+	// the style attribute wins over the rules
+	assert.Contains(t, welcome.Html, `style="color: yellow; background: #0a7"`)
+	assert.Contains(t, welcome.Html, `<style>a:hover { color: red }`)
+
+	localized := mailer.sent[1]
+	assert.Equal(t, "Bienvenue Jane", localized.Subject)
+	assert.True(t, strings.HasSuffix(localized.Body, "Globex"))
+	assert.Contains(t, localized.Html, "Bonjour Jane")
+	assert.Contains(t, localized.Html, ">Globex</p>")
+
+	receipt := mailer.sent[2]
+	assert.Equal(t, "Receipt & invoice", receipt.Subject)
+	assert.Equal(t, "<p>12 EUR</p>", receipt.Html)
+	assert.Empty(t, receipt.Body)
+
+	assert.Equal(t, "d-provider-template", mailer.sent[3].TemplateId)
+	assert.NotNil(t, templates.Render(&Email{TemplateId: "unknown"}))
+}
@@ -1,12 +1,11 @@
 package micro
 
 import (
-	"github.com/asaskevich/EventBus"
+	"fmt"
 	"github.com/google/martian/v3/log"
+	"sync"
 )
 
-var impl = EventBus.New()
-
 type Event struct {
 	Subject string
 	Event   string
@@ -16,22 +15,153 @@ type Event struct {
 
 type SubscribeFunc = func(ctx Ctx, payload Event) error
 
-func Subscribe(topic string, handle SubscribeFunc) error {
-	//ctx := micro.CurrentContext()
-	return impl.Subscribe(topic, func(ctx Ctx, payload Event) {
-		if err := handle(ctx, payload); err != nil {
-			log.Errorf("error handling event: %s", err)
+// Bus is a transport with its subscriptions and handler stats. The package functions (Subscribe, Publish,
+// WaitAsync, ...) use the default bus; tests can create their own bus to run in parallel.
+type Bus struct {
+	mu            sync.RWMutex
+	transport     EventTransport
+	subscriptions map[string]SubscribeFunc
+	stats         map[string]*TopicStats
+}
+
+func NewBus(transport EventTransport) *Bus {
+	if transport == nil {
+		transport = NewMemoryTransport()
+	}
+	return &Bus{
+		transport:     transport,
+		subscriptions: map[string]SubscribeFunc{},
+		stats:         map[string]*TopicStats{},
+	}
+}
+
+var defaultBus = NewBus(nil)
+
+func DefaultBus() *Bus {
+	return defaultBus
+}
+
+// busOf returns the bus the ctx was delivered by, the default bus otherwise
+func busOf(ctx Ctx) *Bus {
+	if ctx.bus != nil {
+		return ctx.bus
+	}
+	return defaultBus
+}
+
+// WithBus returns a copy of ctx whose events are published on b.
+func (ctx Ctx) WithBus(b *Bus) Ctx {
+	ctx.bus = b
+	return ctx
+}
+
+// Use replaces the transport of the bus (in-memory by default), the previous one is closed.
+func (b *Bus) Use(t EventTransport) {
+	b.mu.Lock()
+	previous := b.transport
+	b.transport = t
+	b.mu.Unlock()
+	// not under the lock: the running handlers need it to complete (stats, publish)
+	if previous != nil {
+		_ = previous.Close()
+	}
+}
+
+func (b *Bus) current() EventTransport {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.transport
+}
+
+// SubscribeWith subscribes with explicit options (async, consumer group, transaction, retry policy). Handler
+// panics are recovered and counted in the stats of the bus.
+func (b *Bus) SubscribeWith(topic string, opts SubscribeOptions, handle SubscribeFunc) error {
+	guarded, err := b.guard(topic, opts, handle)
+	if err != nil {
+		return err
+	}
+	return b.current().Subscribe(topic, opts, guarded)
+}
+
+func (b *Bus) Subscribe(topic string, handle SubscribeFunc) error {
+	return b.SubscribeWith(topic, SubscribeOptions{}, handle)
+}
+
+func (b *Bus) SubscribeAsync(topic string, handle SubscribeFunc) error {
+	return b.SubscribeWith(topic, SubscribeOptions{Async: true}, handle)
+}
+
+// Publish delivers the event to the subscribers of topic. When called within Ctx.Tx and an Outbox is configured,
+// the event is stored in the outbox of the transaction and only delivered once it is committed: the transaction
+// fails when it can't be stored. Like with the broker transports, the subscribers then receive Data decoded from
+// JSON (map[string]interface{}, []interface{}, float64...), see PublishTyped and DecodeTyped for typed payloads.
+func (b *Bus) Publish(ctx Ctx, topic string, payload Event) {
+	if payload.Error != "" {
+		log.Errorf(payload.Error)
+	}
+	if ctx.tx && globalEnv != nil && globalEnv.Outbox != nil {
+		if err := globalEnv.Outbox.store(ctx, topic, payload); err != nil {
+			log.Errorf("unable to store event %s in outbox: %v", topic, err)
+			ctx.fail(fmt.Errorf("unable to store event %s in outbox: %w", topic, err))
 		}
-	})
+		return
+	}
+	if err := b.deliver(ctx, topic, payload); err != nil {
+		log.Errorf("error publishing event %s: %v", topic, err)
+	}
+}
+
+// deliver hands the event to the transport and returns the errors of the synchronous handlers
+func (b *Bus) deliver(ctx Ctx, topic string, payload Event) error {
+	ctx.bus = b
+	return b.current().Publish(ctx, topic, payload)
 }
 
+// WaitAsync waits for the async handlers of the in-memory transport.
+func (b *Bus) WaitAsync() {
+	b.current().WaitAsync()
+}
+
+// Reset drops the subscriptions and the stats of the bus.
+func (b *Bus) Reset() {
+	b.mu.Lock()
+	previous := b.transport
+	b.transport = NewMemoryTransport()
+	b.subscriptions = map[string]SubscribeFunc{}
+	b.stats = map[string]*TopicStats{}
+	b.mu.Unlock()
+	// not under the lock: the running handlers need it to complete (stats, publish)
+	previous.WaitAsync()
+}
+
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// DEFAULT BUS
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// UseEventTransport replaces the transport of the default bus (in-memory by default).
+func UseEventTransport(t EventTransport) {
+	defaultBus.Use(t)
+}
+
+func Subscribe(topic string, handle SubscribeFunc) error {
+	return defaultBus.Subscribe(topic, handle)
+}
+
+// SubscribeAsync runs handle in its own goroutine, with a fresh Ctx for the tenant and the authentication of the
+// publisher (the transaction of the publisher may be over when the handler runs).
 func SubscribeAsync(topic string, handle SubscribeFunc) error {
-	//return impl.SubscribeAsync(topic, handle, false)
-	return impl.SubscribeAsync(topic, func(ctx Ctx, payload Event) {
-		if err := handle(ctx, payload); err != nil {
-			log.Errorf("error handling event: %s", err)
-		}
-	}, false)
+	return defaultBus.SubscribeAsync(topic, handle)
+}
+
+// SubscribeGroup subscribes as a member of a consumer group: with a broker transport, each event is handled
+// by a single member of the group.
+func SubscribeGroup(topic string, group string, handle SubscribeFunc) error {
+	return defaultBus.SubscribeWith(topic, SubscribeOptions{Async: true, Group: group}, handle)
+}
+
+// SubscribeWith subscribes to the default bus with explicit options.
+func SubscribeWith(topic string, opts SubscribeOptions, handle SubscribeFunc) error {
+	return defaultBus.SubscribeWith(topic, opts, handle)
 }
 
 func SendNotification(ctx Ctx, event Notification) {
@@ -40,18 +170,24 @@ func SendNotification(ctx Ctx, event Notification) {
 	})
 }
 
+// Publish publishes on the bus of ctx: the bus that delivered the event to a handler, the default bus otherwise.
 func Publish(ctx Ctx, topic string, payload Event) {
-	if payload.Error != "" {
-		log.Errorf(payload.Error)
-	}
-	impl.Publish(topic, ctx, payload)
+	busOf(ctx).Publish(ctx, topic, payload)
+}
+
+func deliver(ctx Ctx, topic string, payload Event) error {
+	return busOf(ctx).deliver(ctx, topic, payload)
 }
 
 func WaitAsync() {
-	impl.WaitAsync()
+	defaultBus.WaitAsync()
 }
 
 func Reset() {
-	impl.WaitAsync()
-	impl = EventBus.New()
+	defaultBus.Reset()
+}
+
+// EventStats returns a snapshot of the handler counters of the default bus, per topic.
+func EventStats() map[string]TopicStats {
+	return defaultBus.Stats()
 }
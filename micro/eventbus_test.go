@@ -0,0 +1,104 @@
+package micro
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAsyncHandlersGetFreshCtx(t *testing.T) {
+	t.Parallel()
+	bus := NewBus(nil)
+
+	var received Ctx
+	assert.Nil(t, bus.SubscribeAsync("orders", func(ctx Ctx, payload Event) error {
+		received = ctx
+		Publish(ctx, "orders.audit", payload)
+		return nil
+	}))
+	audited := 0
+	assert.Nil(t, bus.Subscribe("orders.audit", func(ctx Ctx, payload Event) error {
+		audited++
+		return nil
+	}))
+
+	auth := &Authentication{UserId: "u1", TenantId: "acme"}
+	ctx := NewAuthCtx(auth)
+	ctx.CorrelationId = "req-1"
+	ctx.tx = true
+	bus.Publish(ctx, "orders", Event{Event: "order.created"})
+	bus.WaitAsync()
+
+	assert.Equal(t, "acme", received.TenantId)
+	assert.Equal(t, "u1", received.Auth.UserId)
+	assert.NotSame(t, auth, received.Auth)
+	assert.Equal(t, "req-1", received.CorrelationId)
+	assert.False(t, received.tx)
+	assert.Equal(t, 1, audited)
+	assert.Equal(t, int64(1), bus.Stats()["orders"].Handled)
+}
+
+func TestBusesAreIsolated(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	counts := map[string]int{}
+	buses := map[string]*Bus{"a": NewBus(nil), "b": NewBus(nil)}
+	for name, bus := range buses {
+		name := name
+		assert.Nil(t, bus.SubscribeAsync("ping", func(ctx Ctx, payload Event) error {
+			mu.Lock()
+			defer mu.Unlock()
+			counts[name]++
+			return nil
+		}))
+	}
+	Publish(NewCtx(DefaultTenantId).WithBus(buses["a"]), "ping", Event{})
+	buses["a"].WaitAsync()
+	buses["b"].Reset()
+
+	assert.Equal(t, map[string]int{"a": 1}, counts)
+	assert.Empty(t, buses["b"].Stats())
+}
+
+func TestResetWaitsForRunningHandlers(t *testing.T) {
+	t.Parallel()
+	bus := NewBus(nil)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	assert.Nil(t, bus.SubscribeAsync("orders", func(ctx Ctx, payload Event) error {
+		close(started)
+		<-release
+		return nil
+	}))
+	bus.Publish(NewCtx(DefaultTenantId), "orders", Event{Event: "order.created"})
+	<-started
+
+	reset := make(chan struct{})
+	go func() {
+		bus.Reset()
+		close(reset)
+	}()
+	// the handler completes while Reset waits for it, its stats need the lock of the bus
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	select {
+	case <-reset:
+	case <-time.After(time.Second):
+		t.Fatal("Reset did not return")
+	}
+}
+
+func TestSubscriptionGroups(t *testing.T) {
+	t.Parallel()
+	groups := NewSubscriptionGroups("shop")
+	assert.Equal(t, "shop.orders.0", groups.Group("orders", SubscribeOptions{}))
+	assert.Equal(t, "shop.orders.1", groups.Group("orders", SubscribeOptions{}))
+	assert.Equal(t, "shop.invoices.0", groups.Group("invoices", SubscribeOptions{}))
+	assert.Equal(t, "shop.orders.mailer", groups.Group("orders", SubscribeOptions{Name: "mailer"}))
+	assert.Equal(t, "billing", groups.Group("orders", SubscribeOptions{Group: "billing", Name: "mailer"}))
+
+	// the replicas subscribing in the same order share the groups
+	replica := NewSubscriptionGroups("shop")
+	assert.Equal(t, "shop.orders.0", replica.Group("orders", SubscribeOptions{}))
+}
@@ -0,0 +1,334 @@
+package micro
+
+import (
+	"context"
+	"fmt"
+	"github.com/fabriqs/go-micro/di"
+	"github.com/fabriqs/go-micro/schema"
+	"github.com/fabriqs/go-micro/util/h"
+	log "github.com/sirupsen/logrus"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Feature is a module of the app. The features are initialized after the ones they depend on, each one applies
+// its migrations, registers its job handlers, subscriptions and routes, then is started by App.Run.
+type Feature struct {
+	Name string
+	// DependsOn are the names of the features initialized and started before this one
+	DependsOn []string
+	// Init creates the component of the feature, registered in Env.Container under the name of the feature
+	Init func(app *App) (di.Component, error)
+	// Start is called by App.Run before the server, the jobs and the outbox start, in the dependency order. Stop is
+	// called in the reverse order once they are stopped (only when Start succeeded)
+	Start func(ctx context.Context) error
+	Stop  func(ctx context.Context) error
+	// Health is reported by GET /health under the name of the feature
+	Health func(ctx context.Context) error
+	// Migrations are the goose migrations of the feature, applied to every DataSource before Init like
+	// db/migrations: in shared/ and tenant/ when the feature has a shared/ folder, at the root otherwise. They
+	// share the migrations table, their versions must differ from the ones of the app and the other features.
+	Migrations fs.FS
+	// JobHandlers handle the queued jobs by type (Jobs.Enqueue), they require Cfg.Jobs. They are not scheduled
+	// jobs (Scheduler). Like the subscriptions, they fail until the feature is started and once it is stopped.
+	JobHandlers map[string]JobHandler
+	// Subscriptions are the handlers of the events by topic
+	Subscriptions map[string]SubscribeFunc
+	// Routes registers the endpoints of the feature, after Init. App.Run starts the server after the features and
+	// shuts it down before stopping them.
+	Routes func(r Router)
+	// Disabled skips the feature, as do App.DisabledFeatures and FEATURES_DISABLED (comma separated names)
+	Disabled bool
+}
+
+// FeatureStatus reports how long each feature took to initialize and start.
+type FeatureStatus struct {
+	Name          string        `json:"name"`
+	Enabled       bool          `json:"enabled"`
+	DependsOn     []string      `json:"depends_on,omitempty"`
+	InitDuration  time.Duration `json:"init_duration"`
+	StartDuration time.Duration `json:"start_duration"`
+}
+
+// Features returns the status of the features, in the order they were initialized, then the disabled ones.
+func (app *App) Features() []FeatureStatus {
+	app.featuresMu.Lock()
+	defer app.featuresMu.Unlock()
+	out := make([]FeatureStatus, len(app.features))
+	for i, status := range app.features {
+		out[i] = *status
+	}
+	return out
+}
+
+func (app *App) disabledFeatures() map[string]bool {
+	disabled := map[string]bool{}
+	for _, name := range app.DisabledFeatures {
+		disabled[name] = true
+	}
+	for _, name := range strings.Split(h.GetEnv(FeaturesDisabled), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			disabled[name] = true
+		}
+	}
+	return disabled
+}
+
+// sortFeatures returns the enabled features, each one after its dependencies, in the order of the slice otherwise
+func (app *App) sortFeatures(features []Feature) ([]Feature, []Feature, error) {
+	disabledNames := app.disabledFeatures()
+	byName := map[string]Feature{}
+	var skipped []Feature
+	for _, feat := range features {
+		if feat.Name == "" {
+			continue
+		}
+		if _, exists := byName[feat.Name]; exists {
+			return nil, nil, fmt.Errorf("feature %s is declared twice", feat.Name)
+		}
+		if disabledNames[feat.Name] {
+			feat.Disabled = true
+		}
+		byName[feat.Name] = feat
+	}
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var ordered []Feature
+	var visit func(feat Feature, path []string) error
+	visit = func(feat Feature, path []string) error {
+		switch state[feat.Name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("feature dependency cycle: %s", strings.Join(append(path, feat.Name), " -> "))
+		}
+		if feat.Name != "" {
+			state[feat.Name] = visiting
+		}
+		for _, name := range feat.DependsOn {
+			dependency, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("feature %s depends on unknown feature %s", feat.Name, name)
+			}
+			if dependency.Disabled {
+				return fmt.Errorf("feature %s depends on %s, which is disabled", feat.Name, name)
+			}
+			if err := visit(dependency, append(path, feat.Name)); err != nil {
+				return err
+			}
+		}
+		if feat.Name != "" {
+			state[feat.Name] = visited
+		}
+		ordered = append(ordered, feat)
+		return nil
+	}
+	for _, feat := range features {
+		if feat.Name != "" {
+			feat = byName[feat.Name]
+		}
+		if feat.Disabled {
+			skipped = append(skipped, feat)
+			continue
+		}
+		if err := visit(feat, nil); err != nil {
+			return nil, nil, err
+		}
+	}
+	return ordered, skipped, nil
+}
+
+// initFeatures initializes the enabled features in the dependency order and registers their start and stop hooks
+// in Env.Container
+func (app *App) initFeatures(features []Feature) error {
+	ordered, skipped, err := app.sortFeatures(features)
+	if err != nil {
+		return err
+	}
+	boot := time.Now()
+	for _, feat := range ordered {
+		status := &FeatureStatus{Name: feat.Name, Enabled: true, DependsOn: feat.DependsOn}
+		started := time.Now()
+		if err = app.initFeature(feat, status); err != nil {
+			return fmt.Errorf("failed to init feature %s: %w", feat.Name, err)
+		}
+		status.InitDuration = time.Since(started)
+		app.featuresMu.Lock()
+		app.features = append(app.features, status)
+		app.featuresMu.Unlock()
+		log.Infof("feature %s initialized in %s", feat.Name, status.InitDuration)
+	}
+	for _, feat := range skipped {
+		log.Infof("feature %s is disabled", feat.Name)
+		app.featuresMu.Lock()
+		app.features = append(app.features, &FeatureStatus{Name: feat.Name, DependsOn: feat.DependsOn})
+		app.featuresMu.Unlock()
+	}
+	if len(ordered) > 0 {
+		log.Infof("%d features initialized in %s", len(ordered), time.Since(boot))
+	}
+	return nil
+}
+
+// featureGate is open while the feature is started, its jobs and subscriptions are refused otherwise
+type featureGate struct {
+	name string
+	open atomic.Bool
+}
+
+func (g *featureGate) check() error {
+	if !g.open.Load() {
+		return fmt.Errorf("feature %s is not started", g.name)
+	}
+	return nil
+}
+
+func (app *App) initFeature(feat Feature, status *FeatureStatus) error {
+	env := app.Env
+	gate := &featureGate{name: feat.Name}
+	if feat.Migrations != nil {
+		if len(env.DB) == 0 {
+			return fmt.Errorf("migrations require a DataSource")
+		}
+		migrateFeature(env, feat.Migrations)
+	}
+	if feat.Init != nil {
+		component, err := feat.Init(app)
+		if err != nil {
+			return err
+		}
+		if component != nil {
+			if err = env.Container.Register(feat.Name, component); err != nil {
+				return err
+			}
+		}
+	}
+	if len(feat.JobHandlers) > 0 {
+		if env.Jobs == nil {
+			return fmt.Errorf("job handlers require the job queue (Cfg.Jobs)")
+		}
+		for _, jobType := range sortedKeys(feat.JobHandlers) {
+			handler := feat.JobHandlers[jobType]
+			env.Jobs.Handle(jobType, func(ctx Ctx, job *QueuedJob) error {
+				if err := gate.check(); err != nil {
+					return err
+				}
+				return handler(ctx, job)
+			})
+		}
+	}
+	for _, topic := range sortedKeys(feat.Subscriptions) {
+		handler := feat.Subscriptions[topic]
+		err := Subscribe(topic, func(ctx Ctx, payload Event) error {
+			if err := gate.check(); err != nil {
+				return err
+			}
+			return handler(ctx, payload)
+		})
+		if err != nil {
+			return fmt.Errorf("unable to subscribe to %s: %w", topic, err)
+		}
+	}
+	if feat.Routes != nil {
+		if env.Router == nil {
+			return fmt.Errorf("routes require a router")
+		}
+		feat.Routes(env.Router)
+	}
+	if feat.Health != nil {
+		env.AddHealthCheck(feat.Name, feat.Health)
+	}
+	lifecycle, err := di.ResolveFrom[di.Lifecycle](env.Container)
+	if err != nil {
+		return err
+	}
+	lifecycle.OnStart(func(ctx context.Context) error {
+		started := time.Now()
+		if feat.Start != nil {
+			if err := feat.Start(ctx); err != nil {
+				return fmt.Errorf("failed to start feature %s: %w", feat.Name, err)
+			}
+		}
+		gate.open.Store(true)
+		app.featuresMu.Lock()
+		status.StartDuration = time.Since(started)
+		app.featuresMu.Unlock()
+		log.Infof("feature %s started in %s", feat.Name, status.StartDuration)
+		return nil
+	})
+	// only called once the feature started
+	lifecycle.OnStop(func(ctx context.Context) error {
+		gate.open.Store(false)
+		if feat.Stop == nil {
+			return nil
+		}
+		if err := feat.Stop(ctx); err != nil {
+			return fmt.Errorf("failed to stop feature %s: %w", feat.Name, err)
+		}
+		return nil
+	})
+	return nil
+}
+
+// migrateFeature applies the migrations with the layout of db/migrations, see setupDatabase
+func migrateFeature(env *Env, migrations fs.FS) {
+	if info, err := fs.Stat(migrations, "shared"); err != nil || !info.IsDir() {
+		for _, db := range env.DB {
+			db.Migrate(migrations, ".")
+		}
+		return
+	}
+	for tenant, db := range env.DB {
+		if tenant == DefaultTenantId {
+			db.Migrate(migrations, "shared")
+		} else {
+			db.Migrate(migrations, "tenant")
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// HEALTH
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+var healthMu sync.Mutex
+
+// AddHealthCheck adds a component to the status reported by GET /health (see RouterConfig.Health).
+func (env *Env) AddHealthCheck(name string, check func(ctx context.Context) error) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	env.healthChecks = append(env.healthChecks, healthCheck{name: name, check: check})
+}
+
+// Health runs the health checks, the status is DOWN when one of them fails.
+func (env *Env) Health(ctx context.Context) *schema.HealthStatus {
+	healthMu.Lock()
+	checks := append([]healthCheck{}, env.healthChecks...)
+	healthMu.Unlock()
+	status := schema.NewHealthStatus()
+	for _, check := range checks {
+		status.SetComponentStatus(check.name, check.check(ctx))
+	}
+	return status
+}
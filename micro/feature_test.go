@@ -0,0 +1,129 @@
+package micro
+
+import (
+	"context"
+	"fmt"
+	"github.com/fabriqs/go-micro/di"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newFeatureApp() *App {
+	return &App{Env: &Env{Container: di.New()}}
+}
+
+func TestFeatureLifecycle(t *testing.T) {
+	var events []string
+	feature := func(name string, deps ...string) Feature {
+		return Feature{
+			Name:      name,
+			DependsOn: deps,
+			Init: func(app *App) (di.Component, error) {
+				events = append(events, "init "+name)
+				return nil, nil
+			},
+			Start: func(ctx context.Context) error {
+				events = append(events, "start "+name)
+				return nil
+			},
+			Stop: func(ctx context.Context) error {
+				events = append(events, "stop "+name)
+				return nil
+			},
+		}
+	}
+	app := newFeatureApp()
+	assert.Nil(t, app.initFeatures([]Feature{
+		feature("api", "db", "cache"),
+		feature("cache", "db"),
+		feature("db"),
+		{Name: "search", Disabled: true},
+	}))
+	assert.Equal(t, []string{"init db", "init cache", "init api"}, events)
+
+	events = nil
+	ctx := context.Background()
+	assert.Nil(t, app.Env.Container.Start(ctx))
+	assert.Nil(t, app.Env.Container.Stop(ctx))
+	assert.Equal(t, []string{"start db", "start cache", "start api", "stop api", "stop cache", "stop db"}, events)
+
+	statuses := app.Features()
+	assert.Len(t, statuses, 4)
+	assert.Equal(t, "db", statuses[0].Name)
+	assert.Equal(t, []string{"db", "cache"}, statuses[2].DependsOn)
+	assert.Equal(t, FeatureStatus{Name: "search"}, statuses[3])
+}
+
+func TestFeatureStopOnlyStarted(t *testing.T) {
+	var stopped []string
+	app := newFeatureApp()
+	assert.Nil(t, app.initFeatures([]Feature{
+		{Name: "db", Stop: func(ctx context.Context) error {
+			stopped = append(stopped, "db")
+			return nil
+		}},
+		{Name: "api", DependsOn: []string{"db"},
+			Start: func(ctx context.Context) error { return fmt.Errorf("port in use") },
+			Stop: func(ctx context.Context) error {
+				stopped = append(stopped, "api")
+				return nil
+			}},
+	}))
+	ctx := context.Background()
+	assert.EqualError(t, app.Env.Container.Start(ctx), "failed to start feature api: port in use")
+	assert.Nil(t, app.Env.Container.Stop(ctx))
+	assert.Equal(t, []string{"db"}, stopped)
+}
+
+func TestFeatureErrors(t *testing.T) {
+	cases := map[string][]Feature{
+		"feature a depends on unknown feature x": {{Name: "a", DependsOn: []string{"x"}}},
+		"feature dependency cycle: a -> b -> c -> a": {
+			{Name: "a", DependsOn: []string{"b"}},
+			{Name: "b", DependsOn: []string{"c"}},
+			{Name: "c", DependsOn: []string{"a"}},
+		},
+		"feature a depends on b, which is disabled": {
+			{Name: "a", DependsOn: []string{"b"}},
+			{Name: "b", Disabled: true},
+		},
+		"feature a is declared twice": {{Name: "a"}, {Name: "a"}},
+		"failed to init feature jobs: job handlers require the job queue (Cfg.Jobs)": {
+			{Name: "jobs", JobHandlers: map[string]JobHandler{"report": func(ctx Ctx, job *QueuedJob) error { return nil }}},
+		},
+	}
+	for expected, features := range cases {
+		assert.EqualError(t, newFeatureApp().initFeatures(features), expected)
+	}
+}
+
+func TestFeaturesDisabledByConfig(t *testing.T) {
+	t.Setenv(FeaturesDisabled, "billing, search")
+	var initialized []string
+	feature := func(name string) Feature {
+		return Feature{Name: name, Init: func(app *App) (di.Component, error) {
+			initialized = append(initialized, name)
+			return name, nil
+		}}
+	}
+	app := newFeatureApp()
+	app.DisabledFeatures = []string{"audit"}
+	assert.Nil(t, app.initFeatures([]Feature{feature("audit"), feature("billing"), feature("orders"), feature("search")}))
+	assert.Equal(t, []string{"orders"}, initialized)
+
+	component, err := di.ResolveFrom[string](app.Env.Container, "orders")
+	assert.Nil(t, err)
+	assert.Equal(t, "orders", component)
+}
+
+func TestFeatureHealth(t *testing.T) {
+	app := newFeatureApp()
+	assert.Nil(t, app.initFeatures([]Feature{
+		{Name: "db", Health: func(ctx context.Context) error { return nil }},
+		{Name: "search", Health: func(ctx context.Context) error { return fmt.Errorf("cluster unreachable") }},
+	}))
+	status := app.Env.Health(context.Background())
+	assert.Equal(t, "DOWN", status.Status)
+	assert.Equal(t, "UP", status.Components["db"].Status)
+	assert.Equal(t, "cluster unreachable", status.Components["search"].Details)
+}
@@ -0,0 +1,124 @@
+package micro
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/fabriqs/go-micro/util/dates"
+	"github.com/fabriqs/go-micro/util/errors"
+	"strconv"
+	"time"
+)
+
+// HmacCredential is a shared secret used by a machine client (or a webhook provider) to sign its requests.
+type HmacCredential struct {
+	KeyId       string
+	Secret      string
+	Name        string
+	TenantId    string
+	Roles       []string
+	Permissions []string
+}
+
+type HmacCredentialStore interface {
+	FindHmacCredential(keyId string) (*HmacCredential, error)
+}
+
+type FixedHmacCredentialStore struct {
+	HmacCredentialStore
+	credentials map[string]HmacCredential
+}
+
+func NewFixedHmacCredentialStore(credentials ...HmacCredential) *FixedHmacCredentialStore {
+	store := &FixedHmacCredentialStore{credentials: map[string]HmacCredential{}}
+	for _, c := range credentials {
+		store.credentials[c.KeyId] = c
+	}
+	return store
+}
+
+func (s *FixedHmacCredentialStore) FindHmacCredential(keyId string) (*HmacCredential, error) {
+	if c, ok := s.credentials[keyId]; ok {
+		return &c, nil
+	}
+	return nil, nil
+}
+
+// HmacVerifier checks request signatures produced with SignRequest.
+// Requests older than the tolerance are rejected and a signature can only be used once within that window. The
+// used signatures are held by a Locker, process local unless UseLocker shares one between the instances.
+type HmacVerifier struct {
+	store     HmacCredentialStore
+	tolerance time.Duration
+	locker    Locker
+}
+
+func NewHmacVerifier(store HmacCredentialStore, tolerance time.Duration) *HmacVerifier {
+	if tolerance <= 0 {
+		tolerance = 5 * time.Minute
+	}
+	return &HmacVerifier{
+		store:     store,
+		tolerance: tolerance,
+		locker:    NewMemoryLocker(),
+	}
+}
+
+// UseLocker keeps the used signatures in locker (ex: NewDbLocker), a replay is then detected by every instance.
+func (v *HmacVerifier) UseLocker(locker Locker) {
+	v.locker = locker
+}
+
+// SignRequest computes the hex encoded HMAC-SHA256 of "<timestamp>\n<METHOD>\n<path>\n<sha256(body)>".
+func SignRequest(secret string, timestamp string, method string, path string, body []byte) string {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%s\n%s\n%s\n%s", timestamp, method, path, hex.EncodeToString(digest[:]))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (v *HmacVerifier) Verify(keyId string, timestamp string, signature string, method string, path string, body []byte) (*Authentication, error) {
+	if keyId == "" || timestamp == "" || signature == "" {
+		return nil, errors.Unauthorized("missing_signature")
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.Unauthorized("invalid_signature_timestamp")
+	}
+	now := dates.Now()
+	signedAt := time.Unix(unix, 0)
+	if signedAt.Before(now.Add(-v.tolerance)) || signedAt.After(now.Add(v.tolerance)) {
+		return nil, errors.Unauthorized("signature_expired")
+	}
+	credential, err := v.store.FindHmacCredential(keyId)
+	if err != nil {
+		return nil, err
+	}
+	if credential == nil {
+		return nil, errors.Unauthorized("invalid_signature")
+	}
+	expected := SignRequest(credential.Secret, timestamp, method, path, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, errors.Unauthorized("invalid_signature")
+	}
+	// the signature stays locked as long as its timestamp is accepted
+	if _, ok, err := v.locker.TryLock("hmac:"+keyId+":"+signature, 2*v.tolerance); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errors.Unauthorized("signature_replayed")
+	}
+	tenantId := credential.TenantId
+	if tenantId == "" {
+		tenantId = DefaultTenantId
+	}
+	return &Authentication{
+		Authenticated: true,
+		Scheme:        AuthSchemeHmac,
+		UserId:        credential.KeyId,
+		Name:          credential.Name,
+		TenantId:      tenantId,
+		Roles:         credential.Roles,
+		Permissions:   credential.Permissions,
+	}, nil
+}
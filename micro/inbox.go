@@ -0,0 +1,420 @@
+package micro
+
+import (
+	serrors "errors"
+	"fmt"
+	"github.com/fabriqs/go-micro/util/dates"
+	"github.com/fabriqs/go-micro/util/errors"
+	"github.com/fabriqs/go-micro/util/h"
+	"github.com/fabriqs/go-micro/util/ids"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// Channels of the user notifications, the inbox always receives them
+const (
+	ChannelInbox = "inbox"
+	ChannelEmail = "email"
+	ChannelPush  = "push"
+)
+
+// UserNotification is a notification of the inbox of a user, stored in the tenant DataSource (table
+// z_user_notifications).
+type UserNotification struct {
+	Id        string     `json:"id" gorm:"primaryKey"`
+	UserId    string     `json:"user_id" gorm:"index"`
+	Type      string     `json:"type" gorm:"index"`
+	Title     string     `json:"title"`
+	Body      string     `json:"body,omitempty"`
+	Link      string     `json:"link,omitempty"`
+	Data      h.Map      `json:"data,omitempty" gorm:"serializer:json"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at" gorm:"index"`
+}
+
+func (UserNotification) TableName() string {
+	return "z_user_notifications"
+}
+
+// NotificationPreferences are the choices of a user (table z_notification_preferences), everything is enabled
+// for the users without preferences.
+type NotificationPreferences struct {
+	UserId string `json:"user_id" gorm:"primaryKey"`
+	// DisabledChannels are the channels the user opted out of (email, push)
+	DisabledChannels []string `json:"disabled_channels" gorm:"serializer:json"`
+	// MutedTypes are only kept in the inbox
+	MutedTypes []string `json:"muted_types" gorm:"serializer:json"`
+	// QuietStart and QuietEnd (HH:MM in Timezone, UTC by default, stored zero-padded) stop the email and push
+	// notifications, except the urgent ones. The range can span midnight (22:00 - 07:00).
+	QuietStart string    `json:"quiet_start,omitempty"`
+	QuietEnd   string    `json:"quiet_end,omitempty"`
+	Timezone   string    `json:"timezone,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (NotificationPreferences) TableName() string {
+	return "z_notification_preferences"
+}
+
+// Allows tells whether the user receives a notification of this type on the channel at the given time.
+func (p *NotificationPreferences) Allows(channel string, notificationType string, urgent bool, at time.Time) bool {
+	if channel == ChannelInbox {
+		return true
+	}
+	if h.Contains(p.DisabledChannels, channel) || h.Contains(p.MutedTypes, notificationType) {
+		return false
+	}
+	return urgent || !p.quiet(at)
+}
+
+func (p *NotificationPreferences) quiet(at time.Time) bool {
+	if p.QuietStart == "" || p.QuietEnd == "" {
+		return false
+	}
+	if p.Timezone != "" {
+		if location, err := time.LoadLocation(p.Timezone); err == nil {
+			at = at.In(location)
+		}
+	}
+	start, okStart := clockMinutes(p.QuietStart)
+	end, okEnd := clockMinutes(p.QuietEnd)
+	if !okStart || !okEnd {
+		return false
+	}
+	now := at.Hour()*60 + at.Minute()
+	if start <= end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end
+}
+
+// clockMinutes returns the minutes since midnight of a HH:MM time (7:00 is accepted)
+func clockMinutes(value string) (int, bool) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, false
+	}
+	return parsed.Hour()*60 + parsed.Minute(), true
+}
+
+// validate checks the preferences and zero-pads the quiet hours
+func (p *NotificationPreferences) validate() error {
+	for _, value := range []*string{&p.QuietStart, &p.QuietEnd} {
+		if *value == "" {
+			continue
+		}
+		minutes, ok := clockMinutes(*value)
+		if !ok {
+			return errors.Functional("invalid_quiet_hours", *value)
+		}
+		*value = fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+	}
+	if _, err := time.LoadLocation(p.Timezone); err != nil {
+		return errors.Functional("invalid_timezone", p.Timezone)
+	}
+	return nil
+}
+
+// PushSender delivers the notifications to the devices of a user (ex: FCM, APNs, web push).
+type PushSender interface {
+	Push(ctx Ctx, notification *UserNotification) error
+}
+
+type InboxConfig struct {
+	// Mailer sends the email notifications, or Emails when set (queued with the transaction of Notify)
+	Mailer Mailer
+	Emails *EmailOutbox
+	Push   PushSender
+	// Recipient returns the email address of a user, no email is sent without it
+	Recipient func(ctx Ctx, userId string) (*EmailAddress, error)
+}
+
+type UserNotificationRequest struct {
+	UserId string
+	Type   string
+	Title  string
+	Body   string
+	Link   string
+	Data   h.Map
+	// Urgent notifications are sent during the quiet hours
+	Urgent bool
+	// EmailTemplate renders the email (see EmailTemplates) with Data, title, body and link, a plain text email is
+	// sent otherwise
+	EmailTemplate string
+}
+
+// Inbox stores the notifications of the users and fans them out to email and push according to their
+// preferences. Subscribe (see RegisterInboxRoutes) receives them in real time.
+type Inbox struct {
+	cfg         InboxConfig
+	mu          sync.RWMutex
+	subscribers map[string]map[chan *UserNotification]struct{}
+	hooks       []func(ctx Ctx, notification *UserNotification)
+}
+
+func NewInbox(cfg InboxConfig) *Inbox {
+	return &Inbox{cfg: cfg, subscribers: map[string]map[chan *UserNotification]struct{}{}}
+}
+
+// OnNotification adds a hook called with each new notification (ex: publish it to the other instances).
+func (i *Inbox) OnNotification(hook func(ctx Ctx, notification *UserNotification)) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.hooks = append(i.hooks, hook)
+}
+
+// Notify adds the notification to the inbox of the user, then sends it by email and push when the preferences
+// of the user allow it. Within a transaction, the push, the real time delivery and the emails sent by the Mailer
+// wait for the commit (see Ctx.AfterCommit), the emails of the EmailOutbox are queued with it.
+func (i *Inbox) Notify(ctx Ctx, req UserNotificationRequest) (*UserNotification, error) {
+	if ctx.db == nil {
+		return nil, errors.ResourceNotFound("tenant_not_found", ctx.TenantId)
+	}
+	if req.UserId == "" || req.Title == "" {
+		return nil, errors.Functional("invalid_notification", "user_id and title are required")
+	}
+	notification := &UserNotification{
+		Id:        ids.NewId("notif"),
+		UserId:    req.UserId,
+		Type:      req.Type,
+		Title:     req.Title,
+		Body:      req.Body,
+		Link:      req.Link,
+		Data:      req.Data,
+		CreatedAt: dates.Now(),
+	}
+	if err := ctx.db.Create(notification); err != nil {
+		return nil, err
+	}
+	preferences, err := i.Preferences(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	now := dates.Now()
+	if preferences.Allows(ChannelEmail, req.Type, req.Urgent, now) {
+		if err = i.email(ctx, req, notification); err != nil {
+			return nil, err
+		}
+	}
+	push := i.cfg.Push != nil && preferences.Allows(ChannelPush, req.Type, req.Urgent, now)
+	ctx.AfterCommit(func() {
+		committed := ctx
+		if ctx.tx {
+			committed = ctx.detach()
+		}
+		if push {
+			if err := i.cfg.Push.Push(committed, notification); err != nil {
+				log.Errorf("unable to push notification %s to %s: %v", notification.Id, req.UserId, err)
+			}
+		}
+		i.publish(committed, notification)
+	})
+	return notification, nil
+}
+
+func (i *Inbox) email(ctx Ctx, req UserNotificationRequest, notification *UserNotification) error {
+	if i.cfg.Recipient == nil || (i.cfg.Mailer == nil && i.cfg.Emails == nil) {
+		return nil
+	}
+	to, err := i.cfg.Recipient(ctx, req.UserId)
+	if err != nil || to == nil {
+		return err
+	}
+	message := Email{To: []EmailAddress{*to}, Subject: req.Title, TenantId: ctx.TenantId}
+	if req.EmailTemplate != "" {
+		data := h.Map{"title": req.Title, "body": req.Body, "link": req.Link}
+		for k, v := range req.Data {
+			data[k] = v
+		}
+		message.TemplateId = req.EmailTemplate
+		message.TemplateData = data
+	} else {
+		message.Body = req.Body
+		if req.Link != "" {
+			message.Body += "\n\n" + req.Link
+		}
+	}
+	if i.cfg.Emails != nil {
+		_, err = i.cfg.Emails.Queue(ctx, message, EmailOptions{IdempotencyKey: notification.Id})
+		return err
+	}
+	ctx.AfterCommit(func() {
+		if _, err := i.cfg.Mailer.Send(message); err != nil {
+			log.Errorf("unable to email notification %s to %s: %v", notification.Id, req.UserId, err)
+		}
+	})
+	return nil
+}
+
+// Subscribe receives the new notifications of a user until cancel is called.
+func (i *Inbox) Subscribe(userId string) (<-chan *UserNotification, func()) {
+	ch := make(chan *UserNotification, 16)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.subscribers[userId] == nil {
+		i.subscribers[userId] = map[chan *UserNotification]struct{}{}
+	}
+	i.subscribers[userId][ch] = struct{}{}
+	return ch, func() {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		delete(i.subscribers[userId], ch)
+		if len(i.subscribers[userId]) == 0 {
+			delete(i.subscribers, userId)
+		}
+	}
+}
+
+func (i *Inbox) publish(ctx Ctx, notification *UserNotification) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for ch := range i.subscribers[notification.UserId] {
+		select {
+		case ch <- notification:
+		default:
+			log.Warnf("slow inbox subscriber of %s, notification %s dropped", notification.UserId, notification.Id)
+		}
+	}
+	for _, hook := range i.hooks {
+		hook(ctx, notification)
+	}
+}
+
+type InboxFilter struct {
+	Unread bool  `query:"unread" json:"unread"`
+	Limit  int64 `query:"limit" json:"limit"`
+}
+
+// List returns the notifications of a user, most recent first.
+func (i *Inbox) List(ctx Ctx, userId string, filter InboxFilter) ([]*UserNotification, error) {
+	q := Query{Sort: "created_at desc", Limit: filter.Limit, W: "user_id = ?", Args: []any{userId}}
+	if q.Limit <= 0 || q.Limit > 200 {
+		q.Limit = 50
+	}
+	if filter.Unread {
+		q.W += " and read_at is null"
+	}
+	var out []*UserNotification
+	err := ctx.db.Find(&out, q)
+	return out, err
+}
+
+func (i *Inbox) UnreadCount(ctx Ctx, userId string) (int64, error) {
+	return ctx.db.Count(&UserNotification{}, Query{W: "user_id = ? and read_at is null", Args: []any{userId}})
+}
+
+// MarkRead marks notifications of a user as read, all the unread ones when no id is given. It returns the number
+// of updated notifications.
+func (i *Inbox) MarkRead(ctx Ctx, userId string, notificationIds ...string) (int64, error) {
+	q := Query{W: "user_id = ? and read_at is null", Args: []any{userId}}
+	if len(notificationIds) > 0 {
+		q.W += " and id in ?"
+		q.Args = append(q.Args, notificationIds)
+	}
+	return ctx.db.Update(&UserNotification{}, q, map[string]interface{}{"read_at": dates.Now()})
+}
+
+// Preferences returns the preferences of a user, the defaults when the user has none.
+func (i *Inbox) Preferences(ctx Ctx, userId string) (*NotificationPreferences, error) {
+	var preferences NotificationPreferences
+	err := ctx.db.First(&preferences, Query{W: "user_id = ?", Args: []any{userId}})
+	if serrors.Is(err, ErrRecordNotFound) {
+		return &NotificationPreferences{UserId: userId}, nil
+	}
+	return &preferences, err
+}
+
+func (i *Inbox) SavePreferences(ctx Ctx, preferences *NotificationPreferences) error {
+	if err := preferences.validate(); err != nil {
+		return err
+	}
+	preferences.UpdatedAt = dates.Now()
+	return ctx.db.Save(preferences)
+}
+
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// ROUTES
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+type markReadInput struct {
+	Ids []string `json:"ids"`
+}
+
+type unreadCount struct {
+	Unread int64 `json:"unread"`
+}
+
+func inboxUser(ctx Ctx) (string, error) {
+	if ctx.Auth == nil || ctx.Auth.UserId == "" {
+		return "", errors.Unauthorized("authentication_required")
+	}
+	return ctx.Auth.UserId, nil
+}
+
+// RegisterInboxRoutes exposes the inbox of the authenticated user under /notifications: list, unread count, mark
+// read, preferences and a stream of server-sent events ("notification") for the real time delivery.
+func RegisterInboxRoutes(r BaseRouter, inbox *Inbox, filters ...MiddlewareFunc) {
+	r.GET("/notifications", func(ctx Ctx, input InboxFilter) (any, error) {
+		userId, err := inboxUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return inbox.List(ctx, userId, input)
+	}, filters...)
+	r.GET("/notifications/unread", func(ctx Ctx) (any, error) {
+		userId, err := inboxUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+		count, err := inbox.UnreadCount(ctx, userId)
+		return unreadCount{Unread: count}, err
+	}, filters...)
+	r.POST("/notifications/read", func(ctx Ctx, input markReadInput) (any, error) {
+		userId, err := inboxUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = inbox.MarkRead(ctx, userId, input.Ids...); err != nil {
+			return nil, err
+		}
+		count, err := inbox.UnreadCount(ctx, userId)
+		return unreadCount{Unread: count}, err
+	}, filters...)
+	r.GET("/notifications/preferences", func(ctx Ctx) (any, error) {
+		userId, err := inboxUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return inbox.Preferences(ctx, userId)
+	}, filters...)
+	r.PUT("/notifications/preferences", func(ctx Ctx, input NotificationPreferences) (any, error) {
+		userId, err := inboxUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+		input.UserId = userId
+		if err = inbox.SavePreferences(ctx, &input); err != nil {
+			return nil, err
+		}
+		return &input, nil
+	}, filters...)
+	r.Stream("/notifications/stream", func(ctx Ctx, stream EventStream) error {
+		userId, err := inboxUser(ctx)
+		if err != nil {
+			return err
+		}
+		received, cancel := inbox.Subscribe(userId)
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Context().Done():
+				return nil
+			case notification := <-received:
+				if err = stream.Send("notification", notification); err != nil {
+					return fmt.Errorf("unable to send notification %s: %v", notification.Id, err)
+				}
+			}
+		}
+	}, filters...)
+}
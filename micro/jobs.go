@@ -0,0 +1,515 @@
+package micro
+
+import (
+	"context"
+	"encoding/json"
+	serrors "errors"
+	"fmt"
+	"github.com/fabriqs/go-micro/schema"
+	"github.com/fabriqs/go-micro/util/dates"
+	"github.com/fabriqs/go-micro/util/errors"
+	"github.com/fabriqs/go-micro/util/ids"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobDead    = "dead"
+)
+
+// QueuedJob is a background job, stored in the tenant DataSource (table z_jobs).
+type QueuedJob struct {
+	Id          string     `json:"id" gorm:"primaryKey"`
+	Type        string     `json:"type" gorm:"index;index:idx_z_jobs_pending_unique_key,unique,where:unique_key <> '' and status <> 'done' and status <> 'dead'"`
+	Payload     string     `json:"payload"`
+	Auth        string     `json:"-"`
+	Status      string     `json:"status" gorm:"index"`
+	Priority    int        `json:"priority"`
+	UniqueKey   string     `json:"unique_key,omitempty" gorm:"index:idx_z_jobs_pending_unique_key,unique,where:unique_key <> '' and status <> 'done' and status <> 'dead'"`
+	Attempts    int        `json:"attempts"`
+	MaxAttempts int        `json:"max_attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	RunAt       time.Time  `json:"run_at" gorm:"index"`
+	LockedBy    string     `json:"locked_by,omitempty" gorm:"index"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (QueuedJob) TableName() string {
+	return "z_jobs"
+}
+
+type EnqueueOptions struct {
+	// Delay postpones the first run
+	Delay time.Duration
+	// Priority: jobs with a higher priority run first
+	Priority int
+	// UniqueKey skips the job when a queued or running job of the same type has the same key
+	UniqueKey string
+	// MaxAttempts defaults to JobsConfig.MaxAttempts
+	MaxAttempts int
+}
+
+type JobsConfig struct {
+	// Workers is the number of jobs run concurrently by this instance
+	Workers int
+	// Interval is the polling interval of idle workers (jobs are also picked right after each commit)
+	Interval    time.Duration
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled on each attempt (capped to 1h)
+	Backoff time.Duration
+	// Lease is how long a job is reserved by a worker; jobs of a crashed worker run again once it expires
+	Lease time.Duration
+	// Retention is how long done jobs are kept (0 keeps them forever)
+	Retention time.Duration
+}
+
+type JobHandler func(ctx Ctx, job *QueuedJob) error
+
+// Jobs is a persistent queue of background jobs, run by a pool of workers. Jobs enqueued within Ctx.Tx are
+// only visible once the transaction is committed.
+type Jobs struct {
+	cfg      JobsConfig
+	tenants  TenantLoader
+	mu       sync.RWMutex
+	handlers map[string]JobHandler
+	notify   chan struct{}
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	// running is the context of the jobs run by the workers, cancelled when Stop gives up waiting for them
+	running context.Context
+	cancel  context.CancelFunc
+}
+
+func NewJobs(cfg JobsConfig, tenants TenantLoader) *Jobs {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = 5 * time.Minute
+	}
+	return &Jobs{
+		cfg:      cfg,
+		tenants:  tenants,
+		handlers: map[string]JobHandler{},
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// Handle registers the handler of a job type.
+func (j *Jobs) Handle(jobType string, handler JobHandler) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.handlers[jobType] = handler
+}
+
+// HandleJob registers a handler receiving the decoded payload of the job.
+func HandleJob[T any](jobs *Jobs, jobType string, handler func(ctx Ctx, payload T) error) {
+	jobs.Handle(jobType, func(ctx Ctx, job *QueuedJob) error {
+		var payload T
+		if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+			return err
+		}
+		return handler(ctx, payload)
+	})
+}
+
+func (j *Jobs) types() []string {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	out := make([]string, 0, len(j.handlers))
+	for jobType := range j.handlers {
+		out = append(out, jobType)
+	}
+	return out
+}
+
+func (j *Jobs) handler(jobType string) (JobHandler, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	handler, ok := j.handlers[jobType]
+	return handler, ok
+}
+
+// Enqueue stores a job in the DataSource of ctx (its transaction when called within Ctx.Tx) and returns its id,
+// or the id of the pending job with the same UniqueKey.
+func (j *Jobs) Enqueue(ctx Ctx, jobType string, payload any, opts ...EnqueueOptions) (string, error) {
+	var o EnqueueOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = j.cfg.MaxAttempts
+	}
+	db := ctx.db
+	if db == nil {
+		return "", errors.ResourceNotFound("tenant_not_found", ctx.TenantId)
+	}
+	if o.UniqueKey != "" {
+		var existing QueuedJob
+		err := db.First(&existing, Query{
+			W:    "type = ? and unique_key = ? and status in ?",
+			Args: []any{jobType, o.UniqueKey, []string{JobQueued, JobRunning}},
+		})
+		if err == nil {
+			return existing.Id, nil
+		}
+		if !serrors.Is(err, ErrRecordNotFound) {
+			return "", err
+		}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	var auth []byte
+	if ctx.Auth != nil {
+		if auth, err = json.Marshal(ctx.Auth); err != nil {
+			return "", err
+		}
+	}
+	now := dates.Now()
+	job := &QueuedJob{
+		Id:          ids.NewId("job"),
+		Type:        jobType,
+		Payload:     string(data),
+		Auth:        string(auth),
+		Status:      JobQueued,
+		Priority:    o.Priority,
+		UniqueKey:   o.UniqueKey,
+		MaxAttempts: o.MaxAttempts,
+		RunAt:       now.Add(o.Delay),
+		CreatedAt:   now,
+	}
+	// the unique index covers the queued and running jobs, a concurrent enqueue of the same key inserts nothing
+	created, err := db.CreateIfAbsent(job)
+	if err != nil {
+		return "", err
+	}
+	if !created {
+		var existing QueuedJob
+		if err = db.First(&existing, Query{
+			W:    "type = ? and unique_key = ? and status in ?",
+			Args: []any{jobType, o.UniqueKey, []string{JobQueued, JobRunning}},
+		}); err != nil {
+			return "", err
+		}
+		return existing.Id, nil
+	}
+	if !ctx.tx {
+		j.Notify()
+	}
+	return job.Id, nil
+}
+
+// Notify wakes up an idle worker, it is called after each committed transaction.
+func (j *Jobs) Notify() {
+	select {
+	case j.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (j *Jobs) Start() {
+	j.stop = make(chan struct{})
+	j.running, j.cancel = context.WithCancel(context.Background())
+	for i := 0; i < j.cfg.Workers; i++ {
+		j.wg.Add(1)
+		go j.worker(j.stop)
+	}
+}
+
+// Stop waits for the running jobs until ctx is done, their context (Ctx.Context) is then cancelled and Stop
+// gives up: the jobs are taken over by another worker once their lease expires.
+func (j *Jobs) Stop(ctx context.Context) error {
+	if j.stop == nil {
+		return nil
+	}
+	close(j.stop)
+	j.stop = nil
+	done := make(chan struct{})
+	go func() {
+		j.wg.Wait()
+		close(done)
+	}()
+	defer j.cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Technical("jobs_drain_timeout", ctx.Err().Error())
+	}
+}
+
+func (j *Jobs) worker(stop <-chan struct{}) {
+	defer j.wg.Done()
+	ticker := time.NewTicker(j.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		ran := false
+		for _, tenantId := range j.tenants.GetTenant() {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			ok, err := j.Work(tenantId)
+			if err != nil {
+				log.Errorf("job worker failed for tenant %s: %v", tenantId, err)
+			}
+			ran = ran || ok
+		}
+		if ran {
+			continue
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-j.notify:
+		}
+	}
+}
+
+// RunPending runs the jobs of the tenant which are due, until there is none left, and returns their number.
+func (j *Jobs) RunPending(tenantId string) (int, error) {
+	count := 0
+	for {
+		ok, err := j.Work(tenantId)
+		if err != nil || !ok {
+			return count, err
+		}
+		count++
+	}
+}
+
+// Work claims a job of the tenant and runs it, it returns false when no job is due.
+func (j *Jobs) Work(tenantId string) (bool, error) {
+	db := NewCtx(tenantId).db
+	if db == nil {
+		return false, nil
+	}
+	job, err := j.claim(db)
+	if err != nil || job == nil {
+		return false, err
+	}
+	stop := j.renew(db, job)
+	err = j.run(tenantId, job)
+	stop()
+	if err != nil {
+		j.failed(db, job, err)
+		return true, nil
+	}
+	return true, j.done(db, job)
+}
+
+// claim reserves the next due job (or a job whose lease expired); concurrent workers skip the rows locked by
+// each other on postgres.
+func (j *Jobs) claim(db DataSource) (*QueuedJob, error) {
+	types := j.types()
+	if len(types) == 0 {
+		return nil, nil
+	}
+	now := dates.Now()
+	token := fmt.Sprintf("%s/%s", InstanceId, ids.NewId("w"))
+	candidates := "select id from z_jobs where type in ? and ((status = ? and run_at <= ?) or (status = ? and locked_until < ?)) " +
+		"order by priority desc, run_at limit 1"
+	if db.Dialect() == "postgres" {
+		candidates += " for update skip locked"
+	}
+	claimed, err := db.Update(&QueuedJob{}, Query{
+		W:    "id in (" + candidates + ")",
+		Args: []any{types, JobQueued, now, JobRunning, now},
+	}, map[string]interface{}{
+		"status":       JobRunning,
+		"locked_by":    token,
+		"locked_until": now.Add(j.cfg.Lease),
+	})
+	if err != nil || claimed == 0 {
+		return nil, err
+	}
+	var job QueuedJob
+	if err = db.First(&job, Query{W: "locked_by = ?", Args: []any{token}}); err != nil {
+		return nil, err
+	}
+	// counted right away, so that a job crashing its worker is not retried forever
+	job.Attempts++
+	if _, err = db.Patch(&QueuedJob{}, job.Id, map[string]interface{}{"attempts": job.Attempts}); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// renew extends the lease of the job while it runs, so that it is not claimed again by another worker
+func (j *Jobs) renew(db DataSource, job *QueuedJob) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(j.cfg.Lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				renewed, err := db.Update(&QueuedJob{}, Query{W: "id = ? and locked_by = ?", Args: []any{job.Id, job.LockedBy}},
+					map[string]interface{}{"locked_until": dates.Now().Add(j.cfg.Lease)})
+				if err != nil || renewed == 0 {
+					log.Warnf("unable to renew the lease of job %s (%s): %v", job.Id, job.Type, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func (j *Jobs) run(tenantId string, job *QueuedJob) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while running job %s: %v", job.Type, r)
+		}
+	}()
+	handler, ok := j.handler(job.Type)
+	if !ok {
+		return fmt.Errorf("no handler for job type %s", job.Type)
+	}
+	ctx := NewCtx(tenantId)
+	if job.Auth != "" {
+		var auth Authentication
+		if err = json.Unmarshal([]byte(job.Auth), &auth); err == nil {
+			ctx.Auth = &auth
+		}
+	}
+	ctx.CorrelationId = job.Id
+	if j.running != nil {
+		ctx = ctx.WithContext(j.running)
+	}
+	return ctx.Tx(func(tx Ctx) error {
+		return handler(tx, job)
+	})
+}
+
+func (j *Jobs) done(db DataSource, job *QueuedJob) error {
+	now := dates.Now()
+	// the lease may have been taken over by another worker, which then owns the result
+	if _, err := db.Update(&QueuedJob{}, Query{W: "id = ? and locked_by = ?", Args: []any{job.Id, job.LockedBy}}, map[string]interface{}{
+		"status":       JobDone,
+		"attempts":     job.Attempts,
+		"locked_by":    "",
+		"locked_until": nil,
+		"finished_at":  now,
+	}); err != nil {
+		return err
+	}
+	if j.cfg.Retention > 0 {
+		_, err := db.Delete(&QueuedJob{}, Query{W: "status = ? and finished_at < ?", Args: []any{JobDone, now.Add(-j.cfg.Retention)}})
+		return err
+	}
+	return nil
+}
+
+func (j *Jobs) failed(db DataSource, job *QueuedJob, cause error) {
+	status := JobQueued
+	var finishedAt *time.Time
+	if job.Attempts >= job.MaxAttempts {
+		status = JobDead
+		finishedAt = dates.NowPrt()
+		log.Errorf("job %s (%s) failed %d times, giving up: %v", job.Id, job.Type, job.Attempts, cause)
+	}
+	delay := time.Duration(math.Min(float64(j.cfg.Backoff)*math.Pow(2, float64(job.Attempts-1)), float64(time.Hour)))
+	if _, err := db.Update(&QueuedJob{}, Query{W: "id = ? and locked_by = ?", Args: []any{job.Id, job.LockedBy}}, map[string]interface{}{
+		"status":       status,
+		"attempts":     job.Attempts,
+		"last_error":   cause.Error(),
+		"run_at":       dates.Now().Add(delay),
+		"locked_by":    "",
+		"locked_until": nil,
+		"finished_at":  finishedAt,
+	}); err != nil {
+		log.Errorf("unable to update job %s: %v", job.Id, err)
+	}
+}
+
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// ADMIN
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+type JobsFilter struct {
+	Status string `query:"status" json:"status"`
+	Type   string `query:"type" json:"type"`
+	Limit  int64  `query:"limit" json:"limit"`
+}
+
+type QueuedJobRef struct {
+	Id string `param:"id" json:"id" validate:"required"`
+}
+
+// List returns the jobs of the tenant of ctx, most recent first.
+func (j *Jobs) List(ctx Ctx, filter JobsFilter) ([]*QueuedJob, error) {
+	q := Query{Sort: "created_at desc", Limit: filter.Limit, W: "1 = 1"}
+	if q.Limit <= 0 || q.Limit > 500 {
+		q.Limit = 100
+	}
+	if filter.Status != "" {
+		q.W += " and status = ?"
+		q.Args = append(q.Args, filter.Status)
+	}
+	if filter.Type != "" {
+		q.W += " and type = ?"
+		q.Args = append(q.Args, filter.Type)
+	}
+	var out []*QueuedJob
+	err := ctx.db.Find(&out, q)
+	return out, err
+}
+
+// Retry queues a dead job again, with its attempts reset.
+func (j *Jobs) Retry(ctx Ctx, id string) error {
+	updated, err := ctx.db.Update(&QueuedJob{}, Query{W: "id = ? and status = ?", Args: []any{id, JobDead}}, map[string]interface{}{
+		"status":      JobQueued,
+		"attempts":    0,
+		"run_at":      dates.Now(),
+		"finished_at": nil,
+	})
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return errors.ResourceNotFound("dead_job_not_found", id)
+	}
+	j.Notify()
+	return nil
+}
+
+// RegisterJobRoutes exposes the job queue of the tenant (list, retry dead jobs).
+func RegisterJobRoutes(r BaseRouter, jobs *Jobs, filters ...MiddlewareFunc) {
+	r.GET("/queue", func(ctx Ctx, input JobsFilter) (any, error) {
+		return jobs.List(ctx, input)
+	}, filters...)
+	r.POST("/queue/:id/retry", func(ctx Ctx, input QueuedJobRef) (any, error) {
+		if err := jobs.Retry(ctx, input.Id); err != nil {
+			return nil, err
+		}
+		return schema.Ack{Value: "queued"}, nil
+	}, filters...)
+}
@@ -0,0 +1,158 @@
+package micro
+
+import (
+	"fmt"
+	"github.com/fabriqs/go-micro/util/dates"
+	"github.com/fabriqs/go-micro/util/ids"
+	"os"
+	"sync"
+	"time"
+)
+
+// Locker provides locks shared by the instances of a service, ex: to run a scheduled job on a single instance.
+type Locker interface {
+	// TryLock acquires key for ttl, ok is false when the lock is held by another owner
+	TryLock(key string, ttl time.Duration) (lock Lock, ok bool, err error)
+}
+
+// Lock is a lease on a key, renewed with Refresh until it is released.
+type Lock interface {
+	Refresh(ttl time.Duration) error
+	Release() error
+}
+
+// InstanceId identifies this process as a lock owner.
+var InstanceId = newInstanceId()
+
+func newInstanceId() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// LOCK TABLE
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// DistributedLock is a row of the lock table (z_locks), the id is the key of the lock.
+type DistributedLock struct {
+	Id        string    `gorm:"primaryKey"`
+	Owner     string    `gorm:"index"`
+	ExpiresAt time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
+
+func (DistributedLock) TableName() string {
+	return "z_locks"
+}
+
+type dbLocker struct {
+	Locker
+	db    DataSource
+	owner string
+}
+
+type dbLock struct {
+	locker *dbLocker
+	key    string
+	// token is the owner of this lease, the owner of the locker and a unique suffix
+	token string
+}
+
+// NewDbLocker uses a lock table (z_locks) of db: a lock is a row, expired rows are taken over.
+// The owner defaults to InstanceId.
+func NewDbLocker(db DataSource, owner string) Locker {
+	if owner == "" {
+		owner = InstanceId
+	}
+	return &dbLocker{db: db, owner: owner}
+}
+
+func (l *dbLocker) TryLock(key string, ttl time.Duration) (Lock, bool, error) {
+	now := dates.Now()
+	if _, err := l.db.Delete(&DistributedLock{}, Query{W: "id = ? and expires_at < ?", Args: []any{key, now}}); err != nil {
+		return nil, false, err
+	}
+	token := fmt.Sprintf("%s/%s", l.owner, ids.NewId("lk"))
+	err := l.db.Create(&DistributedLock{Id: key, Owner: token, ExpiresAt: now.Add(ttl), CreatedAt: now})
+	if err == nil {
+		return &dbLock{locker: l, key: key, token: token}, true, nil
+	}
+	// the key is held by another owner, any other error is reported
+	if exists, _ := l.db.Exists(&DistributedLock{}, Query{W: "id = ?", Args: []any{key}}); exists {
+		return nil, false, nil
+	}
+	return nil, false, err
+}
+
+func (l *dbLock) Refresh(ttl time.Duration) error {
+	updated, err := l.locker.db.Update(&DistributedLock{}, Query{W: "id = ? and owner = ?", Args: []any{l.key, l.token}},
+		map[string]interface{}{"expires_at": dates.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+	if updated != 1 {
+		return fmt.Errorf("lock %s lost", l.key)
+	}
+	return nil
+}
+
+func (l *dbLock) Release() error {
+	_, err := l.locker.db.Delete(&DistributedLock{}, Query{W: "id = ? and owner = ?", Args: []any{l.key, l.token}})
+	return err
+}
+
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// IN-MEMORY
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+type memoryLease struct {
+	lock      *memoryLock
+	expiresAt time.Time
+}
+
+type memoryLocker struct {
+	Locker
+	mu    sync.Mutex
+	locks map[string]memoryLease
+}
+
+type memoryLock struct {
+	locker *memoryLocker
+	key    string
+}
+
+// NewMemoryLocker is a process local Locker (single instance deployments, tests).
+func NewMemoryLocker() Locker {
+	return &memoryLocker{locks: map[string]memoryLease{}}
+}
+
+func (l *memoryLocker) TryLock(key string, ttl time.Duration) (Lock, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := dates.Now()
+	if lease, ok := l.locks[key]; ok && lease.expiresAt.After(now) {
+		return nil, false, nil
+	}
+	lock := &memoryLock{locker: l, key: key}
+	l.locks[key] = memoryLease{lock: lock, expiresAt: now.Add(ttl)}
+	return lock, true, nil
+}
+
+func (l *memoryLock) Refresh(ttl time.Duration) error {
+	l.locker.mu.Lock()
+	defer l.locker.mu.Unlock()
+	if lease, ok := l.locker.locks[l.key]; !ok || lease.lock != l {
+		return fmt.Errorf("lock %s lost", l.key)
+	}
+	l.locker.locks[l.key] = memoryLease{lock: l, expiresAt: dates.Now().Add(ttl)}
+	return nil
+}
+
+func (l *memoryLock) Release() error {
+	l.locker.mu.Lock()
+	defer l.locker.mu.Unlock()
+	if lease, ok := l.locker.locks[l.key]; ok && lease.lock == l {
+		delete(l.locker.locks, l.key)
+	}
+	return nil
+}
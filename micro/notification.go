@@ -1,11 +1,67 @@
 package micro
 
 import (
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"strings"
 )
 
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityError    Severity = "error"
+	SeverityCritical Severity = "critical"
+)
+
+var severityLevels = map[Severity]int{
+	SeverityInfo:     0,
+	SeverityWarning:  1,
+	SeverityError:    2,
+	SeverityCritical: 3,
+}
+
+// AtLeast tells whether s is as severe as min, an empty severity is info.
+func (s Severity) AtLeast(min Severity) bool {
+	return severityLevels[s] >= severityLevels[min]
+}
+
+type NotificationField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type NotificationLink struct {
+	Title string `json:"title"`
+	Url   string `json:"url"`
+}
+
 type Notification struct {
-	Message string `json:"content"`
+	Message  string              `json:"content"`
+	Title    string              `json:"title,omitempty"`
+	Severity Severity            `json:"severity,omitempty"`
+	Fields   []NotificationField `json:"fields,omitempty"`
+	Links    []NotificationLink  `json:"links,omitempty"`
+}
+
+// Text returns the notification as plain text (title, message, fields and links), for the channels without
+// formatting.
+func (n Notification) Text() string {
+	var b strings.Builder
+	if n.Title != "" {
+		b.WriteString(n.Title)
+		b.WriteString("\n")
+	}
+	b.WriteString(n.Message)
+	for _, field := range n.Fields {
+		b.WriteString(fmt.Sprintf("\n%s: %s", field.Name, field.Value))
+	}
+	for _, link := range n.Links {
+		b.WriteString(fmt.Sprintf("\n%s: %s", link.Title, link.Url))
+	}
+	return b.String()
 }
 
 type NotificationService interface {
@@ -24,3 +80,195 @@ func (n *NoopNotificationService) Send(_ Ctx, message Notification) error {
 	log.Warnf("no notifier configured -- message: %s", message.Message)
 	return nil
 }
+
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// ROUTING
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// NotificationConfig routes the notifications to the channels of NOTIFICATION_SENDER (slack, teams, discord,
+// email, sms, webhook), all of them receive every notification when there is no rule.
+type NotificationConfig struct {
+	Rules []NotificationRule
+	// WebhookTemplate renders the body posted to the generic webhooks (see adapters.WebhookNotifierConfig)
+	WebhookTemplate string
+	// WebhookHeaders are added to the requests of the generic webhooks (ex: Authorization)
+	WebhookHeaders map[string]string
+	// Throttle protects each channel from floods (rate limit, deduplication, digests)
+	Throttle *ThrottleConfig
+}
+
+// NotificationRule sends the notifications of MinSeverity and above to a channel, or only the listed Severities
+// when set.
+type NotificationRule struct {
+	Channel     string
+	MinSeverity Severity
+	Severities  []Severity
+}
+
+func (r NotificationRule) matches(severity Severity) bool {
+	if len(r.Severities) > 0 {
+		for _, s := range r.Severities {
+			if s == severity {
+				return true
+			}
+		}
+		return false
+	}
+	return severity.AtLeast(r.MinSeverity)
+}
+
+// NotificationRouter sends the notifications to the channels selected by its rules, to all the channels when
+// there is no rule.
+type NotificationRouter struct {
+	NotificationService
+	channels map[string]NotificationService
+	names    []string
+	rules    []NotificationRule
+}
+
+func NewNotificationRouter(rules ...NotificationRule) *NotificationRouter {
+	return &NotificationRouter{channels: map[string]NotificationService{}, rules: rules}
+}
+
+// Channel adds a named channel (ex: slack, email), the rules refer to it by name.
+func (r *NotificationRouter) Channel(name string, channel NotificationService) *NotificationRouter {
+	if _, exists := r.channels[name]; !exists {
+		r.names = append(r.names, name)
+	}
+	r.channels[name] = channel
+	return r
+}
+
+// Channels returns the names of the channels, in the order they were added.
+func (r *NotificationRouter) Channels() []string {
+	return r.names
+}
+
+// Send delivers the notification to every matching channel, a failing channel doesn't stop the others.
+func (r *NotificationRouter) Send(ctx Ctx, message Notification) error {
+	if message.Severity == "" {
+		message.Severity = SeverityInfo
+	}
+	var errs []error
+	for _, name := range r.targets(message.Severity) {
+		if err := r.channels[name].Send(ctx, message); err != nil {
+			log.Errorf("unable to send notification to %s: %v", name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return joinErrors(errs)
+}
+
+// notificationFlusher is a channel holding notifications, see ThrottledNotifier
+type notificationFlusher interface {
+	Flush(ctx Ctx) error
+}
+
+// Flush sends the notifications held by the channels.
+func (r *NotificationRouter) Flush(ctx Ctx) error {
+	var errs []error
+	for _, name := range r.names {
+		if flusher, ok := r.channels[name].(notificationFlusher); ok {
+			if err := flusher.Flush(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+	return joinErrors(errs)
+}
+
+// joinErrors returns nil, the error or an error listing all of them
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return fmt.Errorf("%s", strings.Join(messages, "; "))
+}
+
+func (r *NotificationRouter) targets(severity Severity) []string {
+	if len(r.rules) == 0 {
+		return r.names
+	}
+	var out []string
+	for _, name := range r.names {
+		for _, rule := range r.rules {
+			if rule.Channel == name && rule.matches(severity) {
+				out = append(out, name)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// EMAIL & SMS
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+type emailNotifier struct {
+	NotificationService
+	mailer Mailer
+	from   *EmailAddress
+	to     []EmailAddress
+}
+
+// NewEmailNotifier sends the notifications by email from the sender (nil: the default sender of the mailer, ex:
+// the from of SMTP_URL), the subject is the title (or the message).
+func NewEmailNotifier(mailer Mailer, from *EmailAddress, to ...EmailAddress) NotificationService {
+	return &emailNotifier{mailer: mailer, from: from, to: to}
+}
+
+func (n *emailNotifier) Send(ctx Ctx, message Notification) error {
+	subject := message.Title
+	if subject == "" {
+		subject = message.Message
+	}
+	if message.Severity != "" && message.Severity != SeverityInfo {
+		subject = fmt.Sprintf("[%s] %s", strings.ToUpper(string(message.Severity)), subject)
+	}
+	_, err := n.mailer.Send(Email{
+		From:     n.from,
+		To:       n.to,
+		Subject:  subject,
+		Body:     message.Text(),
+		TenantId: ctx.TenantId,
+	})
+	return err
+}
+
+// SmsSender is a provider of text messages (ex: adapters.NewTwilioSender).
+type SmsSender interface {
+	SendSms(to string, body string) error
+}
+
+type smsNotifier struct {
+	NotificationService
+	sender SmsSender
+	to     []string
+}
+
+// NewSmsNotifier sends the notifications as text messages (title and message) to the phone numbers.
+func NewSmsNotifier(sender SmsSender, to ...string) NotificationService {
+	return &smsNotifier{sender: sender, to: to}
+}
+
+func (n *smsNotifier) Send(_ Ctx, message Notification) error {
+	body := message.Message
+	if message.Title != "" {
+		body = message.Title + ": " + body
+	}
+	var errs []error
+	for _, to := range n.to {
+		if err := n.sender.SendSms(to, body); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return joinErrors(errs)
+}
@@ -0,0 +1,243 @@
+package micro
+
+import (
+	"fmt"
+	"github.com/fabriqs/go-micro/util/dates"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitError is returned by the channels when the provider rejects a message because of its rate limit
+// (HTTP 429), the throttled channels hold their notifications until RetryAfter.
+type RateLimitError struct {
+	Channel    string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s rate limit reached, retry after %s", e.Channel, e.RetryAfter)
+}
+
+type ThrottleConfig struct {
+	// Limit is the number of notifications sent per Interval (default 1 minute), the others are sent as a digest
+	// at the next interval. No limit when 0.
+	Limit    int
+	Interval time.Duration
+	// DedupWindow: the identical notifications (severity, title and message) received within the window are sent
+	// once, then summarized ("repeated N times") when the window ends
+	DedupWindow time.Duration
+	// Digest holds the notifications and sends them every Digest as a single message, critical ones excepted
+	Digest time.Duration
+}
+
+type dedupEntry struct {
+	notification Notification
+	first        time.Time
+	repeats      int
+}
+
+// ThrottledNotifier protects a channel from floods: rate limit, deduplication and digests. It also holds the
+// notifications while the provider answers with a RateLimitError.
+type ThrottledNotifier struct {
+	NotificationService
+	channel     NotificationService
+	cfg         ThrottleConfig
+	mu          sync.Mutex
+	ctx         Ctx
+	windowStart time.Time
+	sent        int
+	pausedUntil time.Time
+	seen        map[string]*dedupEntry
+	pending     []Notification
+	digestAt    time.Time
+	timer       *time.Timer
+	timerAt     time.Time
+}
+
+func NewThrottledNotifier(channel NotificationService, cfg ThrottleConfig) *ThrottledNotifier {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Minute
+	}
+	return &ThrottledNotifier{channel: channel, cfg: cfg, seen: map[string]*dedupEntry{}}
+}
+
+func (t *ThrottledNotifier) Send(ctx Ctx, message Notification) error {
+	return t.send(ctx, t.accept(ctx, message))
+}
+
+// accept records the notification under the lock, it returns the notifications to send now
+func (t *ThrottledNotifier) accept(ctx Ctx, message Notification) []Notification {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ctx = ctx.detach()
+	now := dates.Now()
+	if t.cfg.DedupWindow > 0 {
+		key := fmt.Sprintf("%s|%s|%s", message.Severity, message.Title, message.Message)
+		if entry, ok := t.seen[key]; ok && now.Sub(entry.first) < t.cfg.DedupWindow {
+			entry.repeats++
+			t.schedule(entry.first.Add(t.cfg.DedupWindow))
+			return nil
+		}
+		for other, entry := range t.seen {
+			if entry.repeats == 0 && now.Sub(entry.first) >= t.cfg.DedupWindow {
+				delete(t.seen, other)
+			}
+		}
+		t.seen[key] = &dedupEntry{notification: message, first: now}
+	}
+	if t.cfg.Digest > 0 && message.Severity != SeverityCritical {
+		if len(t.pending) == 0 {
+			t.digestAt = now.Add(t.cfg.Digest)
+		}
+		t.pending = append(t.pending, message)
+		t.schedule(t.digestAt)
+		return nil
+	}
+	return t.admit(message, now)
+}
+
+// admit returns the notification when it can be sent now, unless the channel is paused or over its limit: it is
+// then held for later. It is called under the lock.
+func (t *ThrottledNotifier) admit(message Notification, now time.Time) []Notification {
+	if now.Before(t.pausedUntil) {
+		t.hold(message, t.pausedUntil)
+		return nil
+	}
+	if t.cfg.Limit > 0 {
+		if now.Sub(t.windowStart) >= t.cfg.Interval {
+			t.windowStart = now
+			t.sent = 0
+		}
+		if t.sent >= t.cfg.Limit {
+			t.hold(message, t.windowStart.Add(t.cfg.Interval))
+			return nil
+		}
+		t.sent++
+	}
+	return []Notification{message}
+}
+
+// send delivers the admitted notifications without the lock, so a slow channel doesn't block the other senders.
+// A RateLimitError pauses the channel and holds the notifications not sent yet.
+func (t *ThrottledNotifier) send(ctx Ctx, messages []Notification) error {
+	var errs []error
+	for i, message := range messages {
+		err := t.channel.Send(ctx, message)
+		if limited, ok := err.(*RateLimitError); ok {
+			log.Warn(limited.Error())
+			t.mu.Lock()
+			t.pausedUntil = dates.Now().Add(limited.RetryAfter)
+			for _, held := range messages[i:] {
+				t.hold(held, t.pausedUntil)
+			}
+			t.mu.Unlock()
+			break
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return joinErrors(errs)
+}
+
+func (t *ThrottledNotifier) hold(message Notification, until time.Time) {
+	t.pending = append(t.pending, message)
+	if t.digestAt.Before(until) {
+		t.digestAt = until
+	}
+	t.schedule(t.digestAt)
+}
+
+// schedule flushes at the given time, unless a flush is planned before
+func (t *ThrottledNotifier) schedule(at time.Time) {
+	if t.timer != nil && !t.timerAt.After(at) {
+		return
+	}
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.timerAt = at
+	t.timer = time.AfterFunc(time.Until(at), func() {
+		t.mu.Lock()
+		t.timer = nil
+		ctx := t.ctx
+		outgoing := t.flush(false)
+		t.mu.Unlock()
+		if err := t.send(ctx, outgoing); err != nil {
+			log.Errorf("unable to send held notifications: %v", err)
+		}
+	})
+}
+
+// Flush sends the summaries of the repeated notifications and the held notifications now, it should be called
+// on shutdown.
+func (t *ThrottledNotifier) Flush(ctx Ctx) error {
+	t.mu.Lock()
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	outgoing := t.flush(true)
+	t.mu.Unlock()
+	return t.send(ctx, outgoing)
+}
+
+// flush returns the summaries and the digest due (all of them when all is set), it is called under the lock
+func (t *ThrottledNotifier) flush(all bool) []Notification {
+	now := dates.Now()
+	var outgoing []Notification
+	for key, entry := range t.seen {
+		ended := now.Sub(entry.first) >= t.cfg.DedupWindow
+		if !ended && !all {
+			if entry.repeats > 0 {
+				t.schedule(entry.first.Add(t.cfg.DedupWindow))
+			}
+			continue
+		}
+		if entry.repeats > 0 {
+			summary := entry.notification
+			summary.Message = fmt.Sprintf("%s (repeated %d times in %s)", summary.Message, entry.repeats,
+				now.Sub(entry.first).Round(time.Second))
+			outgoing = append(outgoing, t.admit(summary, now)...)
+		}
+		if ended {
+			delete(t.seen, key)
+		} else {
+			entry.repeats = 0
+		}
+	}
+	if len(t.pending) > 0 && (all || !now.Before(t.digestAt)) && !now.Before(t.pausedUntil) {
+		pending := t.pending
+		t.pending = nil
+		outgoing = append(outgoing, t.admit(digestOf(pending), now)...)
+	} else if len(t.pending) > 0 {
+		t.schedule(t.digestAt)
+	}
+	return outgoing
+}
+
+// digestOf returns a single notification listing the messages, with the highest severity
+func digestOf(messages []Notification) Notification {
+	if len(messages) == 1 {
+		return messages[0]
+	}
+	digest := Notification{Title: fmt.Sprintf("%d notifications", len(messages)), Severity: SeverityInfo}
+	lines := make([]string, 0, len(messages))
+	for _, message := range messages {
+		if !digest.Severity.AtLeast(message.Severity) {
+			digest.Severity = message.Severity
+		}
+		line := message.Message
+		if message.Title != "" {
+			line = message.Title + ": " + line
+		}
+		if message.Severity != "" {
+			line = "[" + string(message.Severity) + "] " + line
+		}
+		lines = append(lines, "- "+line)
+	}
+	digest.Message = strings.Join(lines, "\n")
+	return digest
+}
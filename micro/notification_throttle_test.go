@@ -0,0 +1,119 @@
+package micro
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedNotifications struct {
+	NotificationService
+	mu      sync.Mutex
+	limited int
+	sent    []Notification
+}
+
+func (r *recordedNotifications) Send(_ Ctx, message Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.limited > 0 {
+		r.limited--
+		return &RateLimitError{Channel: "test", RetryAfter: 20 * time.Millisecond}
+	}
+	r.sent = append(r.sent, message)
+	return nil
+}
+
+func (r *recordedNotifications) messages() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]string, len(r.sent))
+	for i, message := range r.sent {
+		out[i] = message.Message
+	}
+	return out
+}
+
+func TestThrottledNotifier(t *testing.T) {
+	ctx := NewCtx(DefaultTenantId)
+
+	// identical messages are sent once, then summarized
+	channel := &recordedNotifications{}
+	dedup := NewThrottledNotifier(channel, ThrottleConfig{DedupWindow: 30 * time.Millisecond})
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, dedup.Send(ctx, Notification{Message: "db down", Severity: SeverityError}))
+	}
+	assert.Nil(t, dedup.Send(ctx, Notification{Message: "db up"}))
+	assert.Equal(t, []string{"db down", "db up"}, channel.messages())
+	assert.Eventually(t, func() bool { return len(channel.messages()) == 3 }, time.Second, 5*time.Millisecond)
+	assert.Regexp(t, `^db down \(repeated 4 times in .+\)$`, channel.messages()[2])
+
+	// over the limit, the notifications are sent as a digest at the next interval
+	channel = &recordedNotifications{}
+	limited := NewThrottledNotifier(channel, ThrottleConfig{Limit: 2, Interval: 30 * time.Millisecond})
+	for _, message := range []string{"a", "b", "c", "d"} {
+		assert.Nil(t, limited.Send(ctx, Notification{Message: message, Severity: SeverityWarning}))
+	}
+	assert.Equal(t, []string{"a", "b"}, channel.messages())
+	assert.Eventually(t, func() bool { return len(channel.messages()) == 3 }, time.Second, 5*time.Millisecond)
+	channel.mu.Lock()
+	digest := channel.sent[2]
+	channel.mu.Unlock()
+	assert.Equal(t, "2 notifications", digest.Title)
+	assert.Equal(t, SeverityWarning, digest.Severity)
+	assert.Equal(t, "- [warning] c\n- [warning] d", digest.Message)
+
+	// digest mode, critical notifications are sent right away
+	channel = &recordedNotifications{}
+	digests := NewThrottledNotifier(channel, ThrottleConfig{Digest: time.Hour})
+	assert.Nil(t, digests.Send(ctx, Notification{Message: "deployed v1"}))
+	assert.Nil(t, digests.Send(ctx, Notification{Message: "payments down", Severity: SeverityCritical}))
+	assert.Nil(t, digests.Send(ctx, Notification{Message: "deployed v2"}))
+	assert.Equal(t, []string{"payments down"}, channel.messages())
+	assert.Nil(t, digests.Flush(ctx))
+	assert.Equal(t, []string{"payments down", "- deployed v1\n- deployed v2"}, channel.messages())
+
+	// the provider rate limit holds the notifications until retry after
+	channel = &recordedNotifications{limited: 1}
+	paused := NewThrottledNotifier(channel, ThrottleConfig{})
+	assert.Nil(t, paused.Send(ctx, Notification{Message: "first"}))
+	assert.Nil(t, paused.Send(ctx, Notification{Message: "second"}))
+	assert.Empty(t, channel.messages())
+	assert.Eventually(t, func() bool { return len(channel.messages()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "- first\n- second", channel.messages()[0])
+}
+
+// blockingNotifications waits for release on each send
+type blockingNotifications struct {
+	NotificationService
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingNotifications) Send(_ Ctx, _ Notification) error {
+	b.started <- struct{}{}
+	<-b.release
+	return nil
+}
+
+func TestThrottledNotifierSendsWithoutLock(t *testing.T) {
+	ctx := NewCtx(DefaultTenantId)
+	channel := &blockingNotifications{started: make(chan struct{}, 1), release: make(chan struct{})}
+	throttled := NewThrottledNotifier(channel, ThrottleConfig{DedupWindow: time.Minute})
+	done := make(chan error)
+	go func() { done <- throttled.Send(ctx, Notification{Message: "db down"}) }()
+	<-channel.started
+
+	// the slow channel doesn't block the other senders
+	repeated := make(chan error)
+	go func() { repeated <- throttled.Send(ctx, Notification{Message: "db down"}) }()
+	select {
+	case err := <-repeated:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Send blocked by a slow channel")
+	}
+	close(channel.release)
+	assert.Nil(t, <-done)
+}
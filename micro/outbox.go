@@ -0,0 +1,300 @@
+package micro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fabriqs/go-micro/util/dates"
+	"github.com/fabriqs/go-micro/util/errors"
+	"github.com/fabriqs/go-micro/util/ids"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	OutboxPending   = "pending"
+	OutboxProcessed = "processed"
+	OutboxFailed    = "failed"
+)
+
+// OutboxEvent is an event published within a transaction, stored in the tenant DataSource (table z_outbox).
+type OutboxEvent struct {
+	Id          string     `json:"id" gorm:"primaryKey"`
+	Topic       string     `json:"topic" gorm:"index"`
+	AggregateId string     `json:"aggregate_id" gorm:"index"`
+	Payload     string     `json:"payload"`
+	Auth        string     `json:"-"`
+	Status      string     `json:"status" gorm:"index"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	AvailableAt time.Time  `json:"available_at"`
+	LockedBy    string     `json:"locked_by,omitempty" gorm:"index"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	ProcessedAt *time.Time `json:"processed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (OutboxEvent) TableName() string {
+	return "z_outbox"
+}
+
+type OutboxConfig struct {
+	// Interval is the polling interval of the dispatcher (events are also dispatched right after each commit)
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled on each attempt (capped to 1h)
+	Backoff time.Duration
+	// Purge deletes events once processed instead of marking them
+	Purge bool
+	// Retention is how long processed events are kept when not purged (0 keeps them forever)
+	Retention time.Duration
+	// Lease is how long claimed events are reserved by an instance; the events of a crashed instance are delivered
+	// again once it expires
+	Lease time.Duration
+}
+
+// Outbox stores events published within transactions and delivers them after commit, with retries.
+// Events sharing the same aggregate (Event.Subject) are delivered in order: a failing event holds back the next ones.
+// The events are claimed before they are delivered, so that each one is delivered by a single instance.
+type Outbox struct {
+	cfg     OutboxConfig
+	tenants TenantLoader
+	notify  chan struct{}
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+}
+
+func NewOutbox(cfg OutboxConfig, tenants TenantLoader) *Outbox {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = 5 * time.Minute
+	}
+	return &Outbox{
+		cfg:     cfg,
+		tenants: tenants,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+func (o *Outbox) store(ctx Ctx, topic string, payload Event) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var auth []byte
+	if ctx.Auth != nil {
+		if auth, err = json.Marshal(ctx.Auth); err != nil {
+			return err
+		}
+	}
+	now := dates.Now()
+	return ctx.db.Create(&OutboxEvent{
+		Id:          ids.NewId("evt"),
+		Topic:       topic,
+		AggregateId: payload.Subject,
+		Payload:     string(data),
+		Auth:        string(auth),
+		Status:      OutboxPending,
+		AvailableAt: now,
+		CreatedAt:   now,
+	})
+}
+
+// Notify wakes up the dispatcher, it is called after each committed transaction.
+func (o *Outbox) Notify() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Outbox) Start() {
+	o.stop = make(chan struct{})
+	stop := o.stop
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		ticker := time.NewTicker(o.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			case <-o.notify:
+			}
+			o.DispatchAll()
+		}
+	}()
+}
+
+// Stop waits for the dispatch in progress until ctx is done, the undelivered events are dispatched on the next
+// start.
+func (o *Outbox) Stop(ctx context.Context) error {
+	if o.stop == nil {
+		return nil
+	}
+	close(o.stop)
+	o.stop = nil
+	done := make(chan struct{})
+	go func() {
+		o.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Technical("outbox_drain_timeout", ctx.Err().Error())
+	}
+}
+
+// DispatchAll delivers the pending events of every tenant.
+func (o *Outbox) DispatchAll() {
+	for _, tenantId := range o.tenants.GetTenant() {
+		if _, err := o.Dispatch(tenantId); err != nil {
+			log.Errorf("outbox dispatch failed for tenant %s: %v", tenantId, err)
+		}
+	}
+}
+
+// Dispatch delivers one batch of pending events of the tenant and returns the number of events delivered.
+func (o *Outbox) Dispatch(tenantId string) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	db := NewCtx(tenantId).db
+	if db == nil {
+		return 0, nil
+	}
+	delivered := 0
+	for claimed := 0; claimed < o.cfg.BatchSize; {
+		events, err := o.claim(db, o.cfg.BatchSize-claimed)
+		if err != nil || len(events) == 0 {
+			if err == nil {
+				err = o.purge(db)
+			}
+			return delivered, err
+		}
+		claimed += len(events)
+		for _, evt := range events {
+			if err = o.deliver(tenantId, evt); err != nil {
+				o.failed(db, evt, err)
+				continue
+			}
+			delivered++
+			if err = o.processed(db, evt); err != nil {
+				return delivered, err
+			}
+		}
+	}
+	return delivered, o.purge(db)
+}
+
+// claim reserves the first due event of each aggregate (and the events without aggregate), the next event of an
+// aggregate can only be claimed once the previous one is processed. Concurrent instances skip the rows locked by
+// each other on postgres.
+func (o *Outbox) claim(db DataSource, limit int) ([]*OutboxEvent, error) {
+	now := dates.Now()
+	token := fmt.Sprintf("%s/%s", InstanceId, ids.NewId("ob"))
+	candidates := "select c.id from z_outbox c where c.status = ? and c.available_at <= ? " +
+		"and (c.locked_until is null or c.locked_until < ?) " +
+		"and not exists (select 1 from z_outbox p where c.aggregate_id <> '' and p.aggregate_id = c.aggregate_id " +
+		"and p.status = ? and p.id < c.id) order by c.id limit ?"
+	if db.Dialect() == "postgres" {
+		candidates += " for update skip locked"
+	}
+	count, err := db.Update(&OutboxEvent{}, Query{
+		W:    "id in (" + candidates + ")",
+		Args: []any{OutboxPending, now, now, OutboxPending, limit},
+	}, map[string]interface{}{
+		"locked_by":    token,
+		"locked_until": now.Add(o.cfg.Lease),
+	})
+	if err != nil || count == 0 {
+		return nil, err
+	}
+	var events []*OutboxEvent
+	err = db.Find(&events, Query{W: "locked_by = ? and status = ?", Args: []any{token, OutboxPending}, Sort: "id"})
+	return events, err
+}
+
+func (o *Outbox) purge(db DataSource) error {
+	if o.cfg.Retention <= 0 || o.cfg.Purge {
+		return nil
+	}
+	_, err := db.Delete(&OutboxEvent{}, Query{W: "status = ? and processed_at < ?", Args: []any{OutboxProcessed, dates.Now().Add(-o.cfg.Retention)}})
+	return err
+}
+
+func (o *Outbox) deliver(tenantId string, evt *OutboxEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while handling event %s: %v", evt.Topic, r)
+		}
+	}()
+	var payload Event
+	if err = json.Unmarshal([]byte(evt.Payload), &payload); err != nil {
+		return err
+	}
+	ctx := NewCtx(tenantId)
+	if evt.Auth != "" {
+		var auth Authentication
+		if err = json.Unmarshal([]byte(evt.Auth), &auth); err == nil {
+			ctx.Auth = &auth
+		}
+	}
+	return deliver(ctx, evt.Topic, payload)
+}
+
+func (o *Outbox) processed(db DataSource, evt *OutboxEvent) error {
+	// the claim of the event may have expired and been taken over by another instance
+	owned := Query{W: "id = ? and locked_by = ?", Args: []any{evt.Id, evt.LockedBy}}
+	if o.cfg.Purge {
+		_, err := db.Delete(&OutboxEvent{}, owned)
+		return err
+	}
+	_, err := db.Update(&OutboxEvent{}, owned, map[string]interface{}{
+		"status":       OutboxProcessed,
+		"attempts":     evt.Attempts + 1,
+		"locked_by":    "",
+		"locked_until": nil,
+		"processed_at": dates.Now(),
+	})
+	return err
+}
+
+func (o *Outbox) failed(db DataSource, evt *OutboxEvent, cause error) {
+	attempts := evt.Attempts + 1
+	status := OutboxPending
+	if attempts >= o.cfg.MaxAttempts {
+		status = OutboxFailed
+		log.Errorf("outbox event %s (%s) failed %d times, giving up: %v", evt.Id, evt.Topic, attempts, cause)
+	}
+	delay := time.Duration(math.Min(float64(o.cfg.Backoff)*math.Pow(2, float64(attempts-1)), float64(time.Hour)))
+	if _, err := db.Update(&OutboxEvent{}, Query{W: "id = ? and locked_by = ?", Args: []any{evt.Id, evt.LockedBy}}, map[string]interface{}{
+		"status":       status,
+		"attempts":     attempts,
+		"last_error":   cause.Error(),
+		"available_at": dates.Now().Add(delay),
+		"locked_by":    "",
+		"locked_until": nil,
+	}); err != nil {
+		log.Errorf("unable to update outbox event %s: %v", evt.Id, err)
+	}
+}
@@ -1,7 +1,10 @@
 package micro
 
 import (
+	"context"
+	"github.com/fabriqs/go-micro/schema"
 	"net/http"
+	"time"
 )
 
 // AuthKey is used in adapters
@@ -9,22 +12,46 @@ import (
 //goland:noinspection GoUnusedConst
 const AuthKey = "user"
 
+// TenantKey holds the tenant requested by the client (header, subdomain or path), empty if none
+const TenantKey = "tenant"
+
+// SessionKey holds the SessionControl of the request when cookie sessions are enabled
+const SessionKey = "session"
+
 type Router interface {
 	BaseRouter
 	Handler() http.Handler
 	Start(addr string) error
+	// Ready is closed once the server started by Start accepts connections
+	Ready() <-chan struct{}
 	Shutdown() error
 	Group(path string, filters ...MiddlewareFunc) BaseRouter
 }
 
+// contextShutdown is implemented by the routers whose shutdown can be bounded by the caller (App.Run)
+type contextShutdown interface {
+	ShutdownContext(ctx context.Context) error
+}
+
 type BaseRouter interface {
 	POST(path string, handler interface{}, filters ...MiddlewareFunc)
 	PUT(path string, handler interface{}, filters ...MiddlewareFunc)
 	PATCH(path string, handler interface{}, filters ...MiddlewareFunc)
 	GET(path string, handler interface{}, filters ...MiddlewareFunc)
 	DELETE(path string, handler interface{}, filters ...MiddlewareFunc)
+	// Stream serves server-sent events (GET), see StreamHandler
+	Stream(path string, handler StreamHandler, filters ...MiddlewareFunc)
 }
 
+// EventStream sends server-sent events to a client, data is encoded as JSON.
+type EventStream interface {
+	Send(event string, data any) error
+}
+
+// StreamHandler writes events until the client disconnects or the router shuts down (ctx.Context() is done), or it
+// returns. It does not run within a transaction.
+type StreamHandler func(ctx Ctx, stream EventStream) error
+
 type JwtCfg struct {
 	Provider TokenProvider
 }
@@ -38,8 +65,17 @@ type RouterConfig struct {
 	//Prometheus       *PrometheusCfg
 	//JwtAuth    bool
 	TokenProvider TokenProvider
+	ApiKeys       ApiKeyService
+	HmacVerifier  *HmacVerifier
+	Tenancy       *TenantResolution
+	Sessions      *SessionManager
 	SentryDsn     string
 	OnShutdown    func()
+	// Health reports the status of the components on GET /health (ex: Env.Health)
+	Health func(ctx context.Context) *schema.HealthStatus
+	// StreamHeartbeat is the interval of the comments keeping the server-sent events alive through the proxies
+	// (15s by default)
+	StreamHeartbeat time.Duration
 }
 
 type MiddlewareFunc func(ctx Ctx) error
@@ -1,6 +1,7 @@
 package micro
 
 import (
+	"context"
 	"embed"
 	"github.com/fabriqs/go-micro/di"
 	"github.com/fabriqs/go-micro/util/h"
@@ -20,6 +21,54 @@ type Cfg struct {
 	DefaultLocale string
 	Locales       string
 	MultiTenant   bool
+	// ApiKeys enables X-Api-Key authentication (keys stored hashed in each tenant DataSource)
+	ApiKeys bool
+	// HmacCredentials enables signed requests (X-Signature) for the given clients. The replayed signatures are
+	// detected across the instances with DistributedLocks only
+	HmacCredentials []HmacCredential
+	// RolePermissions maps roles to permissions (NewStaticRolePermissions or NewDbRolePermissions)
+	RolePermissions RolePermissions
+	// Policies are the ABAC rules evaluated by Ctx.Can
+	Policies []Policy
+	// Tenancy overrides how tenants are resolved (defaults to X-TenantId header then token issuer)
+	Tenancy *TenantResolution
+	// Sessions enables cookie sessions for server rendered apps (stored in the shared DataSource when there is one)
+	Sessions *SessionConfig
+	// Outbox makes events published within a transaction durable (table z_outbox), delivered after commit with Data
+	// decoded from JSON
+	Outbox *OutboxConfig
+	// DeadLetters stores the events given up by subscriptions with a RetryPolicy (table z_dead_letters)
+	DeadLetters bool
+	// DistributedLocks creates a lock table (z_locks) in the default DataSource, used by the scheduler to run jobs
+	// on a single instance (Env.Locker)
+	DistributedLocks bool
+	// JobHistory stores the runs of scheduled jobs in the default DataSource (table z_job_runs), they are kept in
+	// memory otherwise
+	JobHistory bool
+	// Jobs enables the background job queue (table z_jobs), Env.Jobs
+	Jobs *JobsConfig
+	// EmailOutbox sends the emails queued with Env.Emails in the background (table z_emails), it requires Jobs
+	EmailOutbox *EmailOutboxConfig
+	// Notifications routes the notifications to the channels of NOTIFICATION_SENDER by severity
+	Notifications *NotificationConfig
+	// Inbox enables the in-app notifications of the users (tables z_user_notifications and
+	// z_notification_preferences), Env.Inbox. Its mailer defaults to Env.Emails or Env.Mailer.
+	Inbox *InboxConfig
+	// DevInbox exposes the emails captured by the fake mailer (MAILER=fake://) on this path, outside of production
+	DevInbox string
+	// Admin exposes the admin endpoints of the framework (scheduled jobs, job queue, dead letters)
+	Admin *AdminConfig
+	// DisabledFeatures are skipped by App.Init, along with the ones listed in FEATURES_DISABLED
+	DisabledFeatures []string
+	// ShutdownGrace bounds the whole shutdown (default 30s): the server, the scheduled and queued jobs, the
+	// outbox and the components share this deadline
+	ShutdownGrace time.Duration
+}
+
+// AdminConfig is the path prefix of the admin endpoints and the role they require (defaults to "admin").
+type AdminConfig struct {
+	Path string
+	Role string
 }
 
 // ----------------------------------------------
@@ -38,7 +87,14 @@ func (app *App) Cleanup() {
 	}
 }
 
-func (app *App) Init(features []Feature) *App {
+// OnReady adds a hook run once the server accepts connections, before the scheduler starts. Hooks run in order,
+// Run exits when one fails.
+func (app *App) OnReady(hook func() error) {
+	app.readyHooks = append(app.readyHooks, hook)
+}
+
+// Init registers the Env and initializes the features, it returns the first error of their initialization.
+func (app *App) Init(features []Feature) (*App, error) {
 	//env.components = make([]Component, 0)
 
 	env := app.Env
@@ -47,39 +103,30 @@ func (app *App) Init(features []Feature) *App {
 	globalEnv = env
 	globalApp = app
 
-	if env.Scheduler != nil {
-		di.Register(SchedulerService, env.Scheduler)
-	}
-	if env.TokenProvider != nil {
-		di.Register(TokenProviderService, env.TokenProvider)
+	if env.Events != nil {
+		UseEventTransport(env.Events)
 	}
-	if env.Mailer != nil {
-		di.Register(MailerServer, env.Mailer)
+	if env.Container == nil {
+		env.Container = di.New()
 	}
+	provideEnv(env.Container, env)
 
 	if env.Notifier != nil {
-		di.Register(Notifications, env.Notifier)
-		Subscribe(NotificationTopic, func(ctx Ctx, payload Event) error {
+		err := Subscribe(NotificationTopic, func(ctx Ctx, payload Event) error {
 			return env.Notifier.Send(ctx, Notification{
 				Message: payload.Event,
 			})
 		})
+		if err != nil {
+			return app, err
+		}
 	}
 
-	for _, feat := range features {
-		if feat.Init != nil {
-			component, err := feat.Init(app)
-			if err != nil {
-				log.Fatalf("failed to init feature %s.\n%v", feat.Name, err)
-			}
-			//env.components = append(env.components, component)
-			if component != nil {
-				di.Register(feat.Name, component)
-			}
-		}
+	if err := app.initFeatures(features); err != nil {
+		return app, err
 	}
 
-	return app
+	return app, nil
 }
 
 func (app *App) Run(addr ...string) {
@@ -96,36 +143,118 @@ func (app *App) Run(addr ...string) {
 		port = addr[0]
 	}
 
-	// start the server
-	go func() {
-		_ = app.Env.Router.Start("0.0.0.0:" + port)
-	}()
+	// the stops share one deadline (ShutdownGrace), from the first one
+	var shutdown context.Context
+	cancelShutdown := context.CancelFunc(func() {})
+	defer func() { cancelShutdown() }()
+	deadline := func() context.Context {
+		if shutdown == nil {
+			shutdown, cancelShutdown = context.WithTimeout(context.Background(), app.shutdownGrace())
+		}
+		return shutdown
+	}
 
-	// run the cleanup after the server is terminated
+	// the defers run in the reverse order: the database is closed last
 	defer func() {
-		_ = app.Env.Router.Shutdown()
 		if app.Env.DB != nil {
 			app.Env.Close()
 		}
 	}()
 
-	if app.Env.Scheduler != nil {
-		go func() {
-			time.Sleep(10 * time.Second)
-			app.Env.Scheduler.StartAsync()
-			log.Infof("scheduler started")
+	// start the components (features) first, they are stopped once nothing uses them anymore: after the server,
+	// the jobs and the outbox
+	if app.Env.Container != nil {
+		stopComponents := func() {
+			if err := app.Env.Container.Stop(deadline()); err != nil {
+				log.Warnf("unable to stop the components: %v", err)
+			}
+		}
+		if err := app.Env.Container.Start(context.Background()); err != nil {
+			log.Errorf("unable to start the components: %v", err)
+			stopComponents()
+			exitCode = 1
+			return
+		}
+		defer stopComponents()
+	}
+
+	if flusher, ok := app.Env.Notifier.(notificationFlusher); ok {
+		// send the held notifications (digests, repeated ones) before exiting
+		defer func() {
+			if err := flusher.Flush(NewCtx(DefaultTenantId).WithContext(deadline())); err != nil {
+				log.Warnf("unable to send held notifications: %v", err)
+			}
+		}()
+	}
+
+	if app.Env.Outbox != nil {
+		app.Env.Outbox.Start()
+		defer func() {
+			if err := app.Env.Outbox.Stop(deadline()); err != nil {
+				log.Warnf("outbox dispatch abandoned after %s: %v", app.shutdownGrace(), err)
+			}
 		}()
 	}
-	/*if err != nil {
-		fmt.Printf("error: %v", err)
+
+	if app.Env.Jobs != nil {
+		app.Env.Jobs.Start()
+		defer func() {
+			if err := app.Env.Jobs.Stop(deadline()); err != nil {
+				log.Warnf("queued jobs cancelled after %s: %v", app.shutdownGrace(), err)
+			}
+		}()
+	}
+
+	// start the server, it is shutdown before the jobs and the outbox are stopped
+	failed := make(chan error, 1)
+	go func() {
+		failed <- app.Env.Router.Start("0.0.0.0:" + port)
+	}()
+	defer func() {
+		if router, ok := app.Env.Router.(contextShutdown); ok {
+			_ = router.ShutdownContext(deadline())
+		} else {
+			_ = app.Env.Router.Shutdown()
+		}
+	}()
+
+	select {
+	case <-app.Env.Router.Ready():
+	case err := <-failed:
+		log.Errorf("unable to start the server: %v", err)
 		exitCode = 1
 		return
-	}*/
+	}
+
+	for _, hook := range app.readyHooks {
+		if err := hook(); err != nil {
+			log.Errorf("app not ready: %v", err)
+			exitCode = 1
+			return
+		}
+	}
+
+	if app.Env.Scheduler != nil {
+		app.Env.Scheduler.StartAsync()
+		// stop starting jobs and drain the running ones before the database is closed
+		defer func() {
+			if err := app.Env.Scheduler.Stop(deadline()); err != nil {
+				log.Warnf("scheduled jobs cancelled after %s: %v", app.shutdownGrace(), err)
+			}
+		}()
+	}
 
 	// ensure the server is shutdown gracefully & app runs
 	gracefully()
 }
 
+func (app *App) shutdownGrace() time.Duration {
+	if app.ShutdownGrace <= 0 {
+		return 30 * time.Second
+	}
+	return app.ShutdownGrace
+}
+
 func gracefully() {
 	quit := make(chan os.Signal, 1)
 	defer close(quit)
@@ -1,11 +1,223 @@
 package micro
 
+import (
+	"context"
+	"github.com/fabriqs/go-micro/schema"
+	"github.com/fabriqs/go-micro/util/errors"
+	"sync"
+	"time"
+)
+
 type SchedulerHandler = func(ctx Ctx) error
 
+// JobMode tells where a job runs when the service is scaled to several instances.
+type JobMode string
+
+const (
+	// JobSingleton runs the job on one instance at a time (per tenant), it is the default when the scheduler
+	// has a Locker
+	JobSingleton JobMode = "singleton"
+	// JobEveryInstance runs the job on each instance, ex: to refresh a local cache
+	JobEveryInstance JobMode = "every_instance"
+)
+
+// JobOverlap tells what happens when a job is due while its previous run is not over.
+type JobOverlap string
+
+const (
+	// OverlapSkip skips the run (default)
+	OverlapSkip JobOverlap = "skip"
+	// OverlapQueue runs it once the previous run is over
+	OverlapQueue JobOverlap = "queue"
+	// OverlapAllow runs it concurrently
+	OverlapAllow JobOverlap = "allow"
+)
+
+const (
+	JobRunSucceeded = "succeeded"
+	JobRunFailed    = "failed"
+	JobRunTimeout   = "timeout"
+	JobRunSkipped   = "skipped"
+)
+
+var ErrJobTimeout = errors.Technical("job_timeout")
+
+var ErrSchedulerStopped = errors.Functional("scheduler_stopped")
+
 type Scheduler interface {
+	// StartAsync runs the startup jobs in order, then starts the periodic jobs
 	StartAsync()
+	// Stop stops starting runs and waits for the running ones until ctx is done, they are then cancelled
+	Stop(ctx context.Context) error
 	Every(interval string, handler SchedulerHandler)
+	// Once runs the handler at startup, see JobOptions.Startup
 	Once(handler SchedulerHandler)
 	EveryTenant(interval string, handler SchedulerHandler)
 	OncePerTenant(handler SchedulerHandler)
+	// Schedule adds a named job, see JobOptions
+	Schedule(opts JobOptions, handler SchedulerHandler) error
+	Jobs() []JobInfo
+	Pause(name string) error
+	Resume(name string) error
+	// Trigger runs the job now, in the background, even if it is paused
+	Trigger(name string) error
+	// History returns the last runs of the job, most recent first
+	History(name string, limit int) ([]JobRun, error)
+}
+
+// JobOptions describes a scheduled job: either Every (interval, ex: "30s", "1h") or Cron (5 fields, or 6 with
+// seconds), or a Startup job.
+type JobOptions struct {
+	// Name identifies the job in the runtime API, defaults to job-<n>
+	Name string
+	Tags []string
+	// Startup runs the job once when the scheduler starts, before the periodic jobs
+	Startup bool
+	// Order sorts the startup jobs, they run one after the other (registration order for the same Order)
+	Order int
+	// Every is an interval ("30s", "5m", "1h")
+	Every string
+	// Cron is a cron expression ("0 3 * * *"), evaluated in TimeZone
+	Cron string
+	// TimeZone is an IANA time zone (ex: "Europe/Paris"), defaults to UTC
+	TimeZone string
+	// Jitter delays each run by a random duration up to Jitter, to spread jobs of several instances
+	Jitter time.Duration
+	// PerTenant runs the handler once per tenant, the default tenant otherwise
+	PerTenant bool
+	// Limit is the maximum number of runs, 0 for no limit
+	Limit int
+	// Mode defaults to JobSingleton when the scheduler has a Locker, JobEveryInstance otherwise
+	Mode JobMode
+	// LockTTL is the lease of the lock of singleton jobs (default 1 minute), renewed while the job runs
+	LockTTL time.Duration
+	// Timeout cancels ctx.Context() of the handler and records the run as timed out, 0 for no timeout
+	Timeout time.Duration
+	// Overlap defaults to OverlapSkip
+	Overlap JobOverlap
+	// Parallelism is the number of tenants processed concurrently by PerTenant jobs (default 1)
+	Parallelism int
+}
+
+// JobInfo is the state of a scheduled job returned by the runtime API.
+type JobInfo struct {
+	Name      string     `json:"name"`
+	Tags      []string   `json:"tags,omitempty"`
+	Schedule  string     `json:"schedule"`
+	Mode      JobMode    `json:"mode"`
+	TimeZone  string     `json:"time_zone,omitempty"`
+	Paused    bool       `json:"paused"`
+	Running   bool       `json:"running"`
+	RunCount  int        `json:"run_count"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	NextRun   *time.Time `json:"next_run,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// JobRun is an execution of a job for a tenant.
+type JobRun struct {
+	Id        string     `json:"id" gorm:"primaryKey"`
+	Job       string     `json:"job" gorm:"index"`
+	TenantId  string     `json:"tenant_id"`
+	Instance  string     `json:"instance"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	StartedAt time.Time  `json:"started_at" gorm:"index"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+func (JobRun) TableName() string {
+	return "z_job_runs"
+}
+
+func (r JobRun) Duration() time.Duration {
+	if r.EndedAt == nil {
+		return 0
+	}
+	return r.EndedAt.Sub(r.StartedAt)
+}
+
+// JobHistory stores the runs of scheduled jobs.
+type JobHistory interface {
+	Record(run JobRun) error
+	List(job string, limit int) ([]JobRun, error)
+}
+
+type memoryJobHistory struct {
+	JobHistory
+	mu   sync.Mutex
+	size int
+	runs map[string][]JobRun
+}
+
+// NewMemoryJobHistory keeps the last size runs of each job.
+func NewMemoryJobHistory(size int) JobHistory {
+	if size <= 0 {
+		size = 100
+	}
+	return &memoryJobHistory{size: size, runs: map[string][]JobRun{}}
+}
+
+func (h *memoryJobHistory) Record(run JobRun) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	runs := append([]JobRun{run}, h.runs[run.Job]...)
+	if len(runs) > h.size {
+		runs = runs[:h.size]
+	}
+	h.runs[run.Job] = runs
+	return nil
+}
+
+func (h *memoryJobHistory) List(job string, limit int) ([]JobRun, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	runs := h.runs[job]
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return append([]JobRun{}, runs...), nil
+}
+
+type dbJobHistory struct {
+	JobHistory
+	db DataSource
+}
+
+// NewDbJobHistory stores the runs in a table of db (z_job_runs), shared by the instances.
+func NewDbJobHistory(db DataSource) JobHistory {
+	return &dbJobHistory{db: db}
+}
+
+func (h *dbJobHistory) Record(run JobRun) error {
+	return h.db.Create(&run)
+}
+
+func (h *dbJobHistory) List(job string, limit int) ([]JobRun, error) {
+	var runs []JobRun
+	err := h.db.Find(&runs, Query{W: "job = ?", Args: []any{job}, Sort: "started_at desc", Limit: int64(limit)})
+	return runs, err
+}
+
+type JobRef struct {
+	Name string `param:"name" json:"name" validate:"required"`
+}
+
+// RegisterSchedulerRoutes exposes the runtime API of the scheduler (list, history, pause, resume and trigger jobs).
+func RegisterSchedulerRoutes(r BaseRouter, scheduler Scheduler, filters ...MiddlewareFunc) {
+	r.GET("/jobs", func(ctx Ctx) (any, error) {
+		return scheduler.Jobs(), nil
+	}, filters...)
+	r.GET("/jobs/:name/runs", func(ctx Ctx, input JobRef) (any, error) {
+		return scheduler.History(input.Name, 100)
+	}, filters...)
+	r.POST("/jobs/:name/pause", func(ctx Ctx, input JobRef) (any, error) {
+		return schema.Ack{Value: "paused"}, scheduler.Pause(input.Name)
+	}, filters...)
+	r.POST("/jobs/:name/resume", func(ctx Ctx, input JobRef) (any, error) {
+		return schema.Ack{Value: "resumed"}, scheduler.Resume(input.Name)
+	}, filters...)
+	r.POST("/jobs/:name/trigger", func(ctx Ctx, input JobRef) (any, error) {
+		return schema.Ack{Value: "triggered"}, scheduler.Trigger(input.Name)
+	}, filters...)
 }
@@ -0,0 +1,279 @@
+package micro
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	serrors "errors"
+	"github.com/fabriqs/go-micro/util/dates"
+	"github.com/fabriqs/go-micro/util/errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session is a server side login, referenced by a signed cookie.
+type Session struct {
+	Id        string    `json:"id" gorm:"primaryKey"`
+	TenantId  string    `json:"tenant_id"`
+	UserId    string    `json:"user_id"`
+	CsrfToken string    `json:"-"`
+	Data      string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (Session) TableName() string {
+	return "z_sessions"
+}
+
+func (s *Session) Authentication() (*Authentication, error) {
+	var auth Authentication
+	if err := json.Unmarshal([]byte(s.Data), &auth); err != nil {
+		return nil, err
+	}
+	auth.Authenticated = true
+	auth.Scheme = AuthSchemeSession
+	return &auth, nil
+}
+
+type SessionStore interface {
+	Get(id string) (*Session, error)
+	Save(session *Session) error
+	Delete(id string) error
+}
+
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// STORES
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+type MemorySessionStore struct {
+	SessionStore
+	mu       sync.RWMutex
+	sessions map[string]Session
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: map[string]Session{}}
+}
+
+func (s *MemorySessionStore) Get(id string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if session, ok := s.sessions[id]; ok {
+		return &session, nil
+	}
+	return nil, nil
+}
+
+func (s *MemorySessionStore) Save(session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := dates.Now()
+	for id, existing := range s.sessions {
+		if existing.ExpiresAt.Before(now) {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[session.Id] = *session
+	return nil
+}
+
+func (s *MemorySessionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// DbSessionStore keeps sessions in the shared (DefaultTenantId) DataSource, table z_sessions.
+// Expired sessions are purged on logout.
+type DbSessionStore struct {
+	SessionStore
+	db DataSource
+}
+
+func NewDbSessionStore(db DataSource) *DbSessionStore {
+	return &DbSessionStore{db: db}
+}
+
+func (s *DbSessionStore) Get(id string) (*Session, error) {
+	var session Session
+	err := s.db.First(&session, Query{W: "id = ?", Args: []any{id}})
+	if serrors.Is(err, ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *DbSessionStore) Save(session *Session) error {
+	return s.db.Save(session)
+}
+
+func (s *DbSessionStore) Delete(id string) error {
+	_, err := s.db.Delete(&Session{}, Query{W: "id = ? or expires_at < ?", Args: []any{id, dates.Now()}})
+	return err
+}
+
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// MANAGER
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+type SessionConfig struct {
+	CookieName string
+	Secret     string
+	// TTL is the idle timeout, it slides on every request
+	TTL time.Duration
+	// Deprecated: the cookie is always Secure unless Insecure is set.
+	Secure bool
+	// Insecure lets the cookie go over plain HTTP, for local development only
+	Insecure bool
+	Domain   string
+	Path     string
+	Store    SessionStore
+}
+
+type SessionManager struct {
+	SessionConfig
+}
+
+func NewSessionManager(cfg SessionConfig) *SessionManager {
+	if cfg.CookieName == "" {
+		cfg.CookieName = "sid"
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 12 * time.Hour
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/"
+	}
+	if cfg.Store == nil {
+		cfg.Store = NewMemorySessionStore()
+	}
+	cfg.Secure = !cfg.Insecure
+	return &SessionManager{SessionConfig: cfg}
+}
+
+// Create persists a new session for auth and returns it with its signed cookie value.
+func (m *SessionManager) Create(auth *Authentication) (*Session, string, error) {
+	id, err := randomSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	csrf, err := randomSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return nil, "", err
+	}
+	now := dates.Now()
+	session := &Session{
+		Id:        id,
+		TenantId:  auth.TenantId,
+		UserId:    auth.UserId,
+		CsrfToken: csrf,
+		Data:      string(data),
+		ExpiresAt: now.Add(m.TTL),
+		CreatedAt: now,
+	}
+	if err = m.Store.Save(session); err != nil {
+		return nil, "", err
+	}
+	return session, m.sign(id), nil
+}
+
+// Load verifies the cookie value and returns the active session, extending its expiry (sliding expiration).
+// The second value is true when the expiry was extended and the cookie must be refreshed.
+func (m *SessionManager) Load(cookie string) (*Session, bool, error) {
+	id, ok := m.verify(cookie)
+	if !ok {
+		return nil, false, errors.Unauthorized("invalid_session")
+	}
+	session, err := m.Store.Get(id)
+	if err != nil {
+		return nil, false, err
+	}
+	now := dates.Now()
+	if session == nil || session.ExpiresAt.Before(now) {
+		return nil, false, errors.Unauthorized("session_expired")
+	}
+	// only write when half of the ttl is consumed
+	if session.ExpiresAt.Sub(now) < m.TTL/2 {
+		session.ExpiresAt = now.Add(m.TTL)
+		if err = m.Store.Save(session); err != nil {
+			return nil, false, err
+		}
+		return session, true, nil
+	}
+	return session, false, nil
+}
+
+func (m *SessionManager) Destroy(cookie string) error {
+	if id, ok := m.verify(cookie); ok {
+		return m.Store.Delete(id)
+	}
+	return nil
+}
+
+func (m *SessionManager) sign(id string) string {
+	mac := hmac.New(sha256.New, []byte(m.Secret))
+	mac.Write([]byte(id))
+	return id + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (m *SessionManager) verify(cookie string) (string, bool) {
+	parts := strings.SplitN(cookie, ".", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	return parts[0], hmac.Equal([]byte(m.sign(parts[0])), []byte(cookie))
+}
+
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// CTX HELPERS
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// SessionControl is provided by the router adapter to let handlers open and close cookie sessions.
+type SessionControl interface {
+	Login(auth *Authentication) (*Session, error)
+	Logout() error
+	Current() *Session
+}
+
+func (ctx Ctx) WithSession(control SessionControl) Ctx {
+	ctx.session = control
+	return ctx
+}
+
+// Login opens a cookie session for the given authentication.
+func (ctx Ctx) Login(auth *Authentication) error {
+	if ctx.session == nil {
+		return errors.Technical("sessions_not_configured")
+	}
+	if auth.TenantId == "" {
+		auth.TenantId = ctx.TenantId
+	}
+	_, err := ctx.session.Login(auth)
+	return err
+}
+
+func (ctx Ctx) Logout() error {
+	if ctx.session == nil {
+		return errors.Technical("sessions_not_configured")
+	}
+	return ctx.session.Logout()
+}
+
+// CsrfToken returns the token that must be sent back (X-CSRF-Token header or _csrf form field) on unsafe requests.
+func (ctx Ctx) CsrfToken() string {
+	if ctx.session == nil || ctx.session.Current() == nil {
+		return ""
+	}
+	return ctx.session.Current().CsrfToken
+}
@@ -0,0 +1,125 @@
+package micro
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type TenantStrategy string
+
+const (
+	TenantFromHeader    TenantStrategy = "header"
+	TenantFromSubdomain TenantStrategy = "subdomain"
+	TenantFromPath      TenantStrategy = "path"
+	TenantFromClaim     TenantStrategy = "claim"
+)
+
+// TenantResolution configures how the router finds the tenant of a request.
+// Request strategies (header, subdomain, path) are tried in order; the claim strategy reads the tenant of the
+// authenticated token. Resolved tenants are validated against Loader, and a request tenant must match the token tenant.
+type TenantResolution struct {
+	Strategies []TenantStrategy
+	// Header defaults to X-TenantId
+	Header string
+	// PathPrefix is required for path resolution, it is stripped before routing: /t/acme/orders is routed as /orders
+	// for tenant acme when set to /t
+	PathPrefix string
+	// BaseDomain is required for subdomain resolution (acme.example.com -> acme when set to example.com)
+	BaseDomain string
+	// Claim defaults to iss
+	Claim  string
+	Loader TenantLoader
+}
+
+func NewTenantResolution(loader TenantLoader, strategies ...TenantStrategy) *TenantResolution {
+	if len(strategies) == 0 {
+		strategies = []TenantStrategy{TenantFromHeader, TenantFromClaim}
+	}
+	return &TenantResolution{
+		Strategies: strategies,
+		Loader:     loader,
+	}
+}
+
+// Validate checks the settings required by the strategies.
+func (t *TenantResolution) Validate() error {
+	if t.Has(TenantFromPath) && strings.Trim(t.PathPrefix, "/") == "" {
+		return fmt.Errorf("tenant resolution from path requires a PathPrefix (ex: /t)")
+	}
+	if t.Has(TenantFromSubdomain) && t.BaseDomain == "" {
+		return fmt.Errorf("tenant resolution from subdomain requires a BaseDomain")
+	}
+	return nil
+}
+
+func (t *TenantResolution) Has(strategy TenantStrategy) bool {
+	for _, s := range t.Strategies {
+		if s == strategy {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *TenantResolution) ClaimName() string {
+	if t.Claim == "" {
+		return "iss"
+	}
+	return t.Claim
+}
+
+// Resolve returns the tenant requested by r (empty if none) and the path to route.
+func (t *TenantResolution) Resolve(r *http.Request) (string, string) {
+	path := r.URL.Path
+	for _, strategy := range t.Strategies {
+		switch strategy {
+		case TenantFromHeader:
+			header := t.Header
+			if header == "" {
+				header = "X-TenantId"
+			}
+			if value := r.Header.Get(header); value != "" {
+				return value, path
+			}
+		case TenantFromSubdomain:
+			host := strings.Split(r.Host, ":")[0]
+			if t.BaseDomain != "" && strings.HasSuffix(host, "."+t.BaseDomain) {
+				if value := strings.TrimSuffix(host, "."+t.BaseDomain); value != "" && !strings.Contains(value, ".") {
+					return value, path
+				}
+			}
+		case TenantFromPath:
+			prefix := strings.TrimSuffix(t.PathPrefix, "/") + "/"
+			if prefix != "/" && strings.HasPrefix(path, prefix) {
+				parts := strings.SplitN(strings.TrimPrefix(path, prefix), "/", 2)
+				if parts[0] != "" {
+					rest := "/"
+					if len(parts) == 2 {
+						rest += parts[1]
+					}
+					return parts[0], rest
+				}
+			}
+		}
+	}
+	return "", path
+}
+
+// Exists checks the tenant against the loader, Env.TenantLoader when there is none. Only the default tenant
+// exists without any loader: a client can't pick a tenant the app doesn't know.
+func (t *TenantResolution) Exists(tenantId string) bool {
+	loader := t.Loader
+	if loader == nil && globalEnv != nil {
+		loader = globalEnv.TenantLoader
+	}
+	if loader == nil {
+		return tenantId == DefaultTenantId
+	}
+	for _, tenant := range loader.GetTenant() {
+		if tenant == tenantId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package micro
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fabriqs/go-micro/util/dates"
+	"github.com/fabriqs/go-micro/util/ids"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+type SubscribeOptions struct {
+	// Async runs the handler in its own goroutine with a fresh Ctx (in-memory transport only, broker transports are
+	// always async)
+	Async bool
+	// Group is the consumer group of broker transports: each event is handled by a single subscriber of the group.
+	// Defaults to a group of its own (see SubscriptionGroups), ignored by the in-memory transport.
+	Group string
+	// Name identifies the subscription, it is required with Retry (dead letters are replayed by name)
+	Name string
+	// Retry enables retries and dead letters, without it a failed event is only logged. It requires Async, the
+	// publisher is not held during the backoff.
+	Retry *RetryPolicy
+	// Tx runs each attempt of the handler in its own transaction
+	Tx bool
+}
+
+// EventTransport moves events from Publish to the subscribed handlers.
+// The in-memory transport passes the publisher Ctx as is; broker transports serialize the event in an Envelope.
+type EventTransport interface {
+	// Publish returns the error of the synchronous handlers (in-memory) or of the broker
+	Publish(ctx Ctx, topic string, payload Event) error
+	Subscribe(topic string, opts SubscribeOptions, handle SubscribeFunc) error
+	WaitAsync()
+	Close() error
+}
+
+// SubscriptionGroups derives the consumer group of the broker subscriptions without SubscribeOptions.Group:
+// <base>.<topic>.<name> for the named ones, <base>.<topic>.<n> otherwise (n-th subscription to the topic). Like with
+// the in-memory bus, each subscriber of the process gets every event, the replicas of the app share the groups.
+type SubscriptionGroups struct {
+	base   string
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// NewSubscriptionGroups returns the default groups of a transport, base is usually the app name.
+func NewSubscriptionGroups(base string) *SubscriptionGroups {
+	return &SubscriptionGroups{base: base, counts: map[string]int{}}
+}
+
+// Group returns the group of a subscription to topic.
+func (g *SubscriptionGroups) Group(topic string, opts SubscribeOptions) string {
+	if opts.Group != "" {
+		return opts.Group
+	}
+	if opts.Name != "" {
+		return fmt.Sprintf("%s.%s.%s", g.base, topic, opts.Name)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := g.counts[topic]
+	g.counts[topic]++
+	return fmt.Sprintf("%s.%s.%d", g.base, topic, n)
+}
+
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// SERIALIZATION
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+// Envelope is the wire format of an Event sent through a broker transport.
+type Envelope struct {
+	Id            string          `json:"id"`
+	Topic         string          `json:"topic"`
+	Time          time.Time       `json:"time"`
+	TenantId      string          `json:"tenant_id"`
+	Auth          *Authentication `json:"auth,omitempty"`
+	CorrelationId string          `json:"correlation_id,omitempty"`
+	Subject       string          `json:"subject,omitempty"`
+	Event         string          `json:"event,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	Data          json.RawMessage `json:"data,omitempty"`
+}
+
+func EncodeEvent(ctx Ctx, topic string, payload Event) ([]byte, error) {
+	data, err := json.Marshal(payload.Data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{
+		Id:            ids.NewId("evt"),
+		Topic:         topic,
+		Time:          dates.Now(),
+		TenantId:      ctx.TenantId,
+		Auth:          ctx.Auth,
+		CorrelationId: ctx.CorrelationId,
+		Subject:       payload.Subject,
+		Event:         payload.Event,
+		Error:         payload.Error,
+		Data:          data,
+	})
+}
+
+// DecodeEvent rebuilds the Ctx (tenant and authentication) and the Event of a message.
+// Data is decoded as generic json (map, slice or scalar).
+func DecodeEvent(message []byte) (Ctx, Event, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		return Ctx{}, Event{}, err
+	}
+	var data interface{}
+	if len(envelope.Data) > 0 {
+		if err := json.Unmarshal(envelope.Data, &data); err != nil {
+			return Ctx{}, Event{}, err
+		}
+	}
+	tenantId := envelope.TenantId
+	if tenantId == "" {
+		tenantId = DefaultTenantId
+	}
+	ctx := NewCtx(tenantId)
+	ctx.Auth = envelope.Auth
+	ctx.CorrelationId = envelope.CorrelationId
+	return ctx, Event{
+		Subject: envelope.Subject,
+		Event:   envelope.Event,
+		Error:   envelope.Error,
+		Data:    data,
+	}, nil
+}
+
+// HandleMessage decodes a broker message and calls the handler, recovering panics.
+func HandleMessage(message []byte, handle SubscribeFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while handling event: %v", r)
+		}
+	}()
+	ctx, payload, err := DecodeEvent(message)
+	if err != nil {
+		return err
+	}
+	return handle(ctx, payload)
+}
+
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+// IN-MEMORY TRANSPORT
+// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
+
+type memoryHandler struct {
+	async  bool
+	handle SubscribeFunc
+}
+
+// memoryTransport calls the handlers without holding a lock, so that a handler can publish in turn.
+type memoryTransport struct {
+	EventTransport
+	mu       sync.RWMutex
+	handlers map[string][]memoryHandler
+	wg       sync.WaitGroup
+}
+
+// NewMemoryTransport is the default, in-process transport.
+func NewMemoryTransport() EventTransport {
+	return &memoryTransport{handlers: map[string][]memoryHandler{}}
+}
+
+func (t *memoryTransport) Publish(ctx Ctx, topic string, payload Event) error {
+	t.mu.RLock()
+	handlers := append([]memoryHandler(nil), t.handlers[topic]...)
+	t.mu.RUnlock()
+
+	var errs []error
+	for _, handler := range handlers {
+		if handler.async {
+			t.wg.Add(1)
+			go func(handle SubscribeFunc) {
+				defer t.wg.Done()
+				if err := handle(ctx, payload); err != nil {
+					log.Errorf("error handling event: %s", err)
+				}
+			}(handler.handle)
+			continue
+		}
+		if err := handler.handle(ctx, payload); err != nil {
+			log.Errorf("error handling event: %s", err)
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d event handler(s) failed, first error: %v", len(errs), errs[0])
+}
+
+func (t *memoryTransport) Subscribe(topic string, opts SubscribeOptions, handle SubscribeFunc) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handlers[topic] = append(t.handlers[topic], memoryHandler{async: opts.Async, handle: handle})
+	return nil
+}
+
+func (t *memoryTransport) WaitAsync() {
+	t.wg.Wait()
+}
+
+func (t *memoryTransport) Close() error {
+	t.wg.Wait()
+	return nil
+}
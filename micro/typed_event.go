@@ -0,0 +1,228 @@
+package micro
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fabriqs/go-micro/util/dates"
+	"github.com/fabriqs/go-micro/util/ids"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DomainEvent is implemented by the payloads of typed events. EventType is the name of the event and the topic
+// it is published on (ex: "order.created").
+type DomainEvent interface {
+	EventType() string
+}
+
+// VersionedEvent is implemented by events whose schema changed, the version defaults to 1.
+type VersionedEvent interface {
+	EventVersion() int
+}
+
+// SubjectEvent is implemented by events bound to an aggregate; the subject keeps the outbox ordering per aggregate.
+type SubjectEvent interface {
+	EventSubject() string
+}
+
+// EventMeta is the standard metadata of a typed event.
+type EventMeta struct {
+	Id            string    `json:"id"`
+	Type          string    `json:"type"`
+	Version       int       `json:"version"`
+	OccurredAt    time.Time `json:"occurred_at"`
+	TenantId      string    `json:"tenant_id,omitempty"`
+	Actor         string    `json:"actor,omitempty"`
+	CorrelationId string    `json:"correlation_id,omitempty"`
+}
+
+// TypedEvent is the Data of the Event published by PublishTyped.
+type TypedEvent[T DomainEvent] struct {
+	Meta EventMeta `json:"meta"`
+	Data T         `json:"data"`
+}
+
+// Upcaster migrates the json data of an event from a version to the next one.
+type Upcaster func(data map[string]interface{}) (map[string]interface{}, error)
+
+// EventSchema is an entry of the event schema registry.
+type EventSchema struct {
+	Type      string `json:"type"`
+	Version   int    `json:"version"`
+	GoType    string `json:"go_type"`
+	upcasters map[int]Upcaster
+}
+
+var eventSchemas = struct {
+	sync.RWMutex
+	types map[string]*EventSchema
+}{types: map[string]*EventSchema{}}
+
+func eventVersion(evt DomainEvent) int {
+	if v, ok := evt.(VersionedEvent); ok && v.EventVersion() > 0 {
+		return v.EventVersion()
+	}
+	return 1
+}
+
+func schemaOf(eventType string) *EventSchema {
+	schema, ok := eventSchemas.types[eventType]
+	if !ok {
+		schema = &EventSchema{Type: eventType, Version: 1, upcasters: map[int]Upcaster{}}
+		eventSchemas.types[eventType] = schema
+	}
+	return schema
+}
+
+// RegisterEvent adds T to the schema registry, it is called by PublishTyped and On.
+func RegisterEvent[T DomainEvent]() string {
+	var zero T
+	eventSchemas.Lock()
+	defer eventSchemas.Unlock()
+	schema := schemaOf(zero.EventType())
+	schema.Version = eventVersion(zero)
+	schema.GoType = reflect.TypeOf(zero).String()
+	return schema.Type
+}
+
+// RegisterUpcaster registers the migration of eventType from fromVersion to fromVersion+1.
+func RegisterUpcaster(eventType string, fromVersion int, up Upcaster) {
+	eventSchemas.Lock()
+	defer eventSchemas.Unlock()
+	schemaOf(eventType).upcasters[fromVersion] = up
+}
+
+// EventSchemas lists the registered event types with their current version.
+func EventSchemas() []EventSchema {
+	eventSchemas.RLock()
+	defer eventSchemas.RUnlock()
+	out := make([]EventSchema, 0, len(eventSchemas.types))
+	for _, schema := range eventSchemas.types {
+		out = append(out, EventSchema{Type: schema.Type, Version: schema.Version, GoType: schema.GoType})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Type < out[j].Type })
+	return out
+}
+
+// upcast migrates data to the current version of eventType
+func upcast(eventType string, version int, data map[string]interface{}) (map[string]interface{}, error) {
+	eventSchemas.RLock()
+	schema, ok := eventSchemas.types[eventType]
+	eventSchemas.RUnlock()
+	if !ok {
+		return data, nil
+	}
+	if version > schema.Version {
+		return nil, fmt.Errorf("event %s: unsupported version %d (current is %d)", eventType, version, schema.Version)
+	}
+	var err error
+	for v := version; v < schema.Version; v++ {
+		up, ok := schema.upcasters[v]
+		if !ok {
+			return nil, fmt.Errorf("event %s: no upcaster from version %d", eventType, v)
+		}
+		if data, err = up(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// NewEventMeta builds the metadata of an event published within ctx.
+func NewEventMeta(ctx Ctx, evt DomainEvent) EventMeta {
+	meta := EventMeta{
+		Id:            ids.NewId("evt"),
+		Type:          evt.EventType(),
+		Version:       eventVersion(evt),
+		OccurredAt:    dates.Now(),
+		TenantId:      ctx.TenantId,
+		CorrelationId: ctx.CorrelationId,
+	}
+	if ctx.Auth != nil {
+		meta.Actor = ctx.Auth.UserId
+	}
+	if meta.CorrelationId == "" {
+		meta.CorrelationId = meta.Id
+	}
+	return meta
+}
+
+// PublishTyped publishes evt on the topic named after its type.
+func PublishTyped[T DomainEvent](ctx Ctx, evt T) {
+	topic := RegisterEvent[T]()
+	var subject string
+	if s, ok := any(evt).(SubjectEvent); ok {
+		subject = s.EventSubject()
+	}
+	Publish(ctx, topic, Event{
+		Subject: subject,
+		Event:   topic,
+		Data:    TypedEvent[T]{Meta: NewEventMeta(ctx, evt), Data: evt},
+	})
+}
+
+// DecodeTyped extracts the typed event of a payload: as is from the in-memory transport, from its json form
+// (broker, outbox) otherwise, applying the upcasters of older versions.
+func DecodeTyped[T DomainEvent](payload Event) (TypedEvent[T], error) {
+	switch data := payload.Data.(type) {
+	case TypedEvent[T]:
+		return data, nil
+	case *TypedEvent[T]:
+		return *data, nil
+	}
+	var out TypedEvent[T]
+	raw, err := json.Marshal(payload.Data)
+	if err != nil {
+		return out, err
+	}
+	var stored struct {
+		Meta EventMeta              `json:"meta"`
+		Data map[string]interface{} `json:"data"`
+	}
+	if err = json.Unmarshal(raw, &stored); err != nil {
+		return out, err
+	}
+	if stored.Meta.Type == "" {
+		return out, fmt.Errorf("event %s is not a typed event", payload.Event)
+	}
+	data, err := upcast(stored.Meta.Type, stored.Meta.Version, stored.Data)
+	if err != nil {
+		return out, err
+	}
+	if raw, err = json.Marshal(data); err != nil {
+		return out, err
+	}
+	if err = json.Unmarshal(raw, &out.Data); err != nil {
+		return out, err
+	}
+	out.Meta = stored.Meta
+	out.Meta.Version = eventVersion(out.Data)
+	return out, nil
+}
+
+// On subscribes handle to the events of type T.
+func On[T DomainEvent](handle func(ctx Ctx, evt T) error, opts ...SubscribeOptions) error {
+	return OnEvent(func(ctx Ctx, evt TypedEvent[T]) error {
+		return handle(ctx, evt.Data)
+	}, opts...)
+}
+
+// OnEvent subscribes handle to the events of type T, with their metadata. The correlation id of the event is
+// set on the ctx so that the events published by the handler are correlated.
+func OnEvent[T DomainEvent](handle func(ctx Ctx, evt TypedEvent[T]) error, opts ...SubscribeOptions) error {
+	topic := RegisterEvent[T]()
+	var options SubscribeOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	return SubscribeWith(topic, options, func(ctx Ctx, payload Event) error {
+		evt, err := DecodeTyped[T](payload)
+		if err != nil {
+			return err
+		}
+		ctx.CorrelationId = evt.Meta.CorrelationId
+		return handle(ctx, evt)
+	})
+}
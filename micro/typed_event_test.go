@@ -0,0 +1,74 @@
+package micro
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type orderPlaced struct {
+	OrderId string  `json:"order_id"`
+	Amount  float64 `json:"amount"`
+	// Currency was added in version 2
+	Currency string `json:"currency"`
+}
+
+func (orderPlaced) EventType() string      { return "order.placed" }
+func (orderPlaced) EventVersion() int      { return 2 }
+func (e orderPlaced) EventSubject() string { return e.OrderId }
+
+func TestTypedEvents(t *testing.T) {
+	Reset()
+	RegisterUpcaster("order.placed", 1, func(data map[string]interface{}) (map[string]interface{}, error) {
+		data["currency"] = "EUR"
+		return data, nil
+	})
+
+	var received []TypedEvent[orderPlaced]
+	assert.Nil(t, OnEvent(func(ctx Ctx, evt TypedEvent[orderPlaced]) error {
+		assert.Equal(t, evt.Meta.CorrelationId, ctx.CorrelationId)
+		received = append(received, evt)
+		return nil
+	}))
+
+	ctx := NewAuthCtx(&Authentication{UserId: "u1", TenantId: "acme"})
+	ctx.CorrelationId = "req-1"
+	PublishTyped(ctx, orderPlaced{OrderId: "o1", Amount: 10, Currency: "USD"})
+
+	assert.Len(t, received, 1)
+	assert.Equal(t, "USD", received[0].Data.Currency)
+	assert.Equal(t, EventMeta{
+		Id:            received[0].Meta.Id,
+		Type:          "order.placed",
+		Version:       2,
+		OccurredAt:    received[0].Meta.OccurredAt,
+		TenantId:      "acme",
+		Actor:         "u1",
+		CorrelationId: "req-1",
+	}, received[0].Meta)
+
+	// an event serialized by a broker transport or the outbox
+	message, err := EncodeEvent(ctx, "order.placed", Event{Subject: "o1", Event: "order.placed", Data: received[0]})
+	assert.Nil(t, err)
+	_, payload, err := DecodeEvent(message)
+	assert.Nil(t, err)
+	evt, err := DecodeTyped[orderPlaced](payload)
+	assert.Nil(t, err)
+	assert.Equal(t, received[0].Data, evt.Data)
+	assert.Equal(t, received[0].Meta.Id, evt.Meta.Id)
+
+	// an event stored with version 1
+	old := Event{Event: "order.placed", Data: map[string]interface{}{
+		"meta": map[string]interface{}{"id": "evt1", "type": "order.placed", "version": 1},
+		"data": map[string]interface{}{"order_id": "o2", "amount": 5},
+	}}
+	evt, err = DecodeTyped[orderPlaced](old)
+	assert.Nil(t, err)
+	assert.Equal(t, orderPlaced{OrderId: "o2", Amount: 5, Currency: "EUR"}, evt.Data)
+	assert.Equal(t, 2, evt.Meta.Version)
+
+	old.Data.(map[string]interface{})["meta"].(map[string]interface{})["version"] = 3
+	_, err = DecodeTyped[orderPlaced](old)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, []EventSchema{{Type: "order.placed", Version: 2, GoType: "micro.orderPlaced"}}, EventSchemas())
+}
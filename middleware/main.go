@@ -34,3 +34,17 @@ func AuthenticatedWithRole(roles ...string) micro.MiddlewareFunc {
 		return errors.Forbidden(fmt.Sprintf("missing_role: %s", strings.Join(roles, ",")))
 	}
 }
+
+// RequirePermission returns a middleware that checks if the user is authenticated and is granted all the given
+// permissions, either directly or through its roles. Wildcards are supported (orders:* grants orders:read).
+func RequirePermission(permissions ...string) micro.MiddlewareFunc {
+	return func(ctx micro.Ctx) error {
+		if !ctx.IsAuthenticated() {
+			return errors.Unauthorized("Unauthorized")
+		}
+		if !ctx.HasPermission(permissions...) {
+			return errors.Forbidden(fmt.Sprintf("missing_permission: %s", strings.Join(permissions, ",")))
+		}
+		return nil
+	}
+}
@@ -163,6 +163,12 @@ func (r *HttpTestResult) Status(status int) *HttpTestResult {
 	return r
 }
 
+func (r *HttpTestResult) Cookie(name string) *StringExpect {
+	return &StringExpect{
+		value: r.result.Cookie(name).Value(),
+	}
+}
+
 func (r *HttpTestResult) JSON() *ValueExpect {
 	return &ValueExpect{
 		value: r.result.JSON(),